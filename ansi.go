@@ -0,0 +1,95 @@
+package rcebot
+
+import "io"
+
+// ansiState is the state of an [ansiStripper] between writes.
+type ansiState uint8
+
+const (
+	ansiStateGround ansiState = iota
+	ansiStateEscape
+	ansiStateCSI
+	ansiStateString
+	ansiStateStringEscape
+)
+
+// ansiStripper is an [io.Writer] that removes ANSI escape sequences and control characters
+// other than newlines and tabs from the bytes written to it, and writes the rest to w.
+//
+// Escape sequences may span multiple writes.
+type ansiStripper struct {
+	w     io.Writer
+	state ansiState
+	buf   []byte
+}
+
+// Write implements [io.Writer].
+func (s *ansiStripper) Write(p []byte) (int, error) {
+	s.buf = s.strip(s.buf[:0], p)
+	if len(s.buf) > 0 {
+		if _, err := s.w.Write(s.buf); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// strip appends the bytes in p that are not part of escape sequences or control characters to dst.
+func (s *ansiStripper) strip(dst, p []byte) []byte {
+	for _, c := range p {
+		switch s.state {
+		case ansiStateGround:
+			switch {
+			case c == 0x1b:
+				s.state = ansiStateEscape
+			case c == '\n' || c == '\t':
+				dst = append(dst, c)
+			case c < 0x20 || c == 0x7f:
+				// Drop other control characters, including carriage returns.
+			default:
+				dst = append(dst, c)
+			}
+
+		case ansiStateEscape:
+			switch {
+			case c == '[':
+				s.state = ansiStateCSI
+			case c == ']' || c == 'P' || c == 'X' || c == '^' || c == '_':
+				// OSC, DCS, SOS, PM and APC strings are terminated by BEL or ST.
+				s.state = ansiStateString
+			case c >= 0x20 && c <= 0x2f:
+				// Intermediate bytes of an nF escape sequence, e.g. character set designation.
+			default:
+				s.state = ansiStateGround
+			}
+
+		case ansiStateCSI:
+			// Parameter and intermediate bytes are in 0x20–0x3f, the final byte is in 0x40–0x7e.
+			if c >= 0x40 && c <= 0x7e {
+				s.state = ansiStateGround
+			}
+
+		case ansiStateString:
+			switch c {
+			case 0x07:
+				s.state = ansiStateGround
+			case 0x1b:
+				s.state = ansiStateStringEscape
+			}
+
+		case ansiStateStringEscape:
+			if c == '\\' {
+				s.state = ansiStateGround
+			} else {
+				s.state = ansiStateString
+			}
+		}
+	}
+	return dst
+}
+
+// StripANSI returns b with ANSI escape sequences and control characters other than newlines and tabs removed.
+func StripANSI(b []byte) []byte {
+	var s ansiStripper
+	return s.strip(nil, b)
+}
@@ -0,0 +1,67 @@
+package rcebot_test
+
+import (
+	"testing"
+
+	rcebot "github.com/database64128/cubic-rce-bot"
+)
+
+func TestStripANSI(t *testing.T) {
+	for _, c := range [...]struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "Plain",
+			input: "hello\tworld\n",
+			want:  "hello\tworld\n",
+		},
+		{
+			name:  "CRLF",
+			input: "line 1\r\nline 2\r\n",
+			want:  "line 1\nline 2\n",
+		},
+		{
+			name:  "SGR",
+			input: "\x1b[1;31mred\x1b[0m",
+			want:  "red",
+		},
+		{
+			name:  "CursorMovement",
+			input: "\x1b[2J\x1b[H\x1b[?25lscreen",
+			want:  "screen",
+		},
+		{
+			name:  "OSCWithBEL",
+			input: "\x1b]0;title\x07text",
+			want:  "text",
+		},
+		{
+			name:  "OSCWithST",
+			input: "\x1b]8;;https://example.com\x1b\\link\x1b]8;;\x1b\\",
+			want:  "link",
+		},
+		{
+			name:  "CharacterSet",
+			input: "\x1b(Bascii",
+			want:  "ascii",
+		},
+		{
+			name:  "ControlCharacters",
+			input: "a\bb\x07c\x7f",
+			want:  "abc",
+		},
+		{
+			name:  "UTF8",
+			input: "\x1b[32m✓\x1b[0m 完成",
+			want:  "✓ 完成",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := string(rcebot.StripANSI([]byte(c.input))); got != c.want {
+				t.Errorf("StripANSI(%q) = %q, want %q", c.input, got, c.want)
+			}
+		})
+	}
+}
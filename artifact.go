@@ -0,0 +1,267 @@
+package rcebot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	// ScratchDirEnv is the environment variable that holds the path to the scratch directory
+	// of an execution of a command with [Command.Artifacts].
+	ScratchDirEnv = "CUBIC_RCE_BOT_SCRATCH_DIR"
+
+	// DefaultMaxArtifacts is the default maximum number of artifacts uploaded per execution.
+	DefaultMaxArtifacts = 10
+
+	// DefaultMaxArtifactSize is the default maximum size of an uploaded artifact in bytes,
+	// which is the upload limit of the official bot API.
+	DefaultMaxArtifactSize = 50 * 1024 * 1024
+
+	// maxPhotoSize is the maximum size of an image artifact in bytes to be uploaded as a photo.
+	// Larger images are uploaded as documents.
+	maxPhotoSize = 10 * 1024 * 1024
+
+	// maxListedSkippedArtifacts is the maximum number of skipped artifacts listed by name after the upload.
+	maxListedSkippedArtifacts = 20
+)
+
+// initArtifacts validates the artifact settings of the command.
+func (c *Command) initArtifacts() error {
+	if len(c.Artifacts) == 0 {
+		if c.MaxArtifacts != 0 || c.MaxArtifactSize != 0 {
+			return errors.New("artifact limits require artifact patterns")
+		}
+		return nil
+	}
+
+	for _, pattern := range c.Artifacts {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid artifact pattern %q: %w", pattern, err)
+		}
+		if !filepath.IsLocal(pattern) {
+			return fmt.Errorf("artifact pattern %q is not relative to the scratch directory", pattern)
+		}
+	}
+
+	if c.MaxArtifacts < 0 || c.MaxArtifactSize < 0 {
+		return errors.New("artifact limits must not be negative")
+	}
+	if c.MaxArtifacts == 0 {
+		c.MaxArtifacts = DefaultMaxArtifacts
+	}
+	if c.MaxArtifactSize == 0 {
+		c.MaxArtifactSize = DefaultMaxArtifactSize
+	}
+
+	if c.tempDirHidden() {
+		return errors.New("scratch directories in the temporary directory are hidden by the private /tmp of the sandbox")
+	}
+
+	return nil
+}
+
+// artifactFile is a file collected from a scratch directory, open for uploading.
+type artifactFile struct {
+	name string
+	file *os.File
+	size int64
+}
+
+// isPhoto returns whether the file is an image small enough to be uploaded as a photo.
+func (f *artifactFile) isPhoto() bool {
+	if f.size > maxPhotoSize {
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(f.name)) {
+	case ".jpg", ".jpeg", ".png", ".webp":
+		return true
+	default:
+		return false
+	}
+}
+
+// artifactSet is the set of artifacts collected from the scratch directories of one or more executions.
+// The zero value is an empty set.
+type artifactSet struct {
+	files    []artifactFile
+	skipped  []string
+	cleanups []func()
+}
+
+// add moves the artifacts of other into the set.
+func (s *artifactSet) add(other *artifactSet) {
+	s.files = append(s.files, other.files...)
+	s.skipped = append(s.skipped, other.skipped...)
+	s.cleanups = append(s.cleanups, other.cleanups...)
+	*other = artifactSet{}
+}
+
+// close closes the artifact files and removes the scratch directories.
+func (s *artifactSet) close() {
+	for _, f := range s.files {
+		_ = f.file.Close()
+	}
+	for _, cleanup := range s.cleanups {
+		cleanup()
+	}
+	*s = artifactSet{}
+}
+
+// newScratchDir creates a new private scratch directory for an execution of the command,
+// and returns its path and a function that removes it.
+func (c *Command) newScratchDir() (dir string, remove func(), err error) {
+	dir, remove, err = c.mkdirTemp("cubic-rce-bot-scratch-")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create scratch directory: %w", err)
+	}
+	return dir, remove, nil
+}
+
+// collectArtifacts opens the regular files in dir that match the command's artifact patterns, and adds them to set,
+// up to the configured count and size limits. Files over the limits are recorded as skipped.
+func (c *Command) collectArtifacts(dir string, set *artifactSet) {
+	// Resolve every match through the scratch directory root, so that symbolic links,
+	// either as the file itself or as any directory on its path, cannot reach outside of it.
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		set.skipped = append(set.skipped, "scratch directory: "+err.Error())
+		return
+	}
+	defer root.Close()
+	fsys := root.FS()
+
+	var count int
+	seen := make(map[string]struct{})
+
+	for _, pattern := range c.Artifacts {
+		matches, _ := fs.Glob(fsys, filepath.ToSlash(pattern))
+		for _, name := range matches {
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			path := filepath.FromSlash(name)
+
+			// Only regular files are collected. The file itself is not followed if it is a symbolic link.
+			info, err := root.Lstat(path)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+
+			if count >= c.MaxArtifacts {
+				set.skipped = append(set.skipped, name+": over the limit of "+strconv.Itoa(c.MaxArtifacts)+" files")
+				continue
+			}
+			if info.Size() > c.MaxArtifactSize {
+				set.skipped = append(set.skipped, name+": "+formatByteSize(info.Size())+" is over the limit of "+formatByteSize(c.MaxArtifactSize))
+				continue
+			}
+
+			f, err := root.Open(path)
+			if err != nil {
+				set.skipped = append(set.skipped, name+": "+err.Error())
+				continue
+			}
+			if openedInfo, err := f.Stat(); err != nil || !os.SameFile(info, openedInfo) {
+				_ = f.Close()
+				set.skipped = append(set.skipped, name+": file changed while being collected")
+				continue
+			}
+
+			set.files = append(set.files, artifactFile{
+				name: name,
+				file: f,
+				size: info.Size(),
+			})
+			count++
+		}
+	}
+}
+
+// sendArtifacts uploads the artifacts in reply to message, images as photos and other files as documents,
+// followed by a message listing the skipped files, if any.
+// If message has no ID, they are sent to its chat and thread without replying.
+//
+// Only the size of each file at the time it was collected is uploaded, in case a process left behind
+// by the command is still writing to it.
+func sendArtifacts(ctx context.Context, b *bot.Bot, message *models.Message, set *artifactSet) error {
+	for i := range set.files {
+		f := &set.files[i]
+
+		if f.isPhoto() {
+			_, err := b.SendPhoto(ctx, &bot.SendPhotoParams{
+				ChatID:          message.Chat.ID,
+				MessageThreadID: message.MessageThreadID,
+				Photo: &models.InputFileUpload{
+					Filename: filepath.Base(f.name),
+					Data:     io.NewSectionReader(f.file, 0, f.size),
+				},
+				Caption:         f.name,
+				ReplyParameters: replyParameters(message),
+			})
+			if err == nil {
+				continue
+			}
+			// Telegram rejects some images as photos, such as those with extreme aspect ratios.
+			// Fall back to uploading them as documents.
+		}
+
+		if _, err := b.SendDocument(ctx, &bot.SendDocumentParams{
+			ChatID:          message.Chat.ID,
+			MessageThreadID: message.MessageThreadID,
+			Document: &models.InputFileUpload{
+				Filename: filepath.Base(f.name),
+				Data:     io.NewSectionReader(f.file, 0, f.size),
+			},
+			Caption:         f.name,
+			ReplyParameters: replyParameters(message),
+		}); err != nil {
+			return fmt.Errorf("failed to upload artifact %q: %w", f.name, err)
+		}
+	}
+
+	if len(set.skipped) > 0 {
+		if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:          message.Chat.ID,
+			MessageThreadID: message.MessageThreadID,
+			Text:            skippedArtifactsText(set.skipped),
+			ReplyParameters: replyParameters(message),
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// skippedArtifactsText returns the message listing the skipped artifacts.
+// At most [maxListedSkippedArtifacts] are listed, and the message fits in [MaxMessageLength],
+// with the number of unlisted ones at the end.
+func skippedArtifactsText(skipped []string) string {
+	// Leave room for the last line counting the unlisted ones.
+	const budget = MaxMessageLength - 32
+
+	var sb strings.Builder
+	sb.WriteString("Skipped artifacts:\n")
+	for i, s := range skipped {
+		line := "- " + s + "\n"
+		if i == maxListedSkippedArtifacts || MessageLength(sb.String())+MessageLength(line) > budget {
+			sb.WriteString("- and ")
+			sb.WriteString(strconv.Itoa(len(skipped) - i))
+			sb.WriteString(" more\n")
+			break
+		}
+		sb.WriteString(line)
+	}
+	return sb.String()
+}
@@ -0,0 +1,243 @@
+package rcebot
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestUserCommandsByIDArtifacts(t *testing.T) {
+	for _, c := range [...]struct {
+		name     string
+		commands []Command
+		wantErr  string
+	}{
+		{
+			name:     "Valid",
+			commands: []Command{{Name: "true", Artifacts: []string{"*.png", "out/report-?.pdf"}, MaxArtifacts: 2}},
+		},
+		{
+			name:     "Absolute",
+			commands: []Command{{Name: "true", Artifacts: []string{"/etc/*"}}},
+			wantErr:  `artifact pattern "/etc/*" is not relative to the scratch directory`,
+		},
+		{
+			name:     "Parent",
+			commands: []Command{{Name: "true", Artifacts: []string{"../*"}}},
+			wantErr:  `artifact pattern "../*" is not relative to the scratch directory`,
+		},
+		{
+			name:     "BadPattern",
+			commands: []Command{{Name: "true", Artifacts: []string{"[a-"}}},
+			wantErr:  `invalid artifact pattern "[a-"`,
+		},
+		{
+			name:     "LimitsWithoutPatterns",
+			commands: []Command{{Name: "true", MaxArtifactSize: 1024}},
+			wantErr:  "artifact limits require artifact patterns",
+		},
+		{
+			name:     "NegativeLimit",
+			commands: []Command{{Name: "true", Artifacts: []string{"*"}, MaxArtifacts: -1}},
+			wantErr:  "artifact limits must not be negative",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			config := Config{
+				Users: []User{{ID: 1, Commands: c.commands}},
+			}
+			_, err := config.UserCommandsByID()
+			if c.wantErr == "" {
+				if err != nil {
+					t.Errorf("UserCommandsByID() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("UserCommandsByID() error = %v, want %q", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestCommandCollectArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+
+	for _, name := range [...]string{"secret.txt", "passwd"} {
+		if err := os.WriteFile(filepath.Join(outside, name), []byte("secret"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, size := range map[string]int{
+		"report.txt":    5,
+		"sub/inner.txt": 3,
+		"large.txt":     100,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, target := range map[string]string{
+		// A symbolic link to a file outside the scratch directory.
+		"link.txt": filepath.Join(outside, "secret.txt"),
+		// A symbolic link to a directory outside the scratch directory.
+		"out": outside,
+		// A symbolic link to a directory inside the scratch directory.
+		"alias": "sub",
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	command := Command{
+		Name:            "build",
+		Artifacts:       []string{"*.txt", "out/*", "out/secret.txt", "sub/*.txt", "alias/*.txt"},
+		MaxArtifactSize: 50,
+	}
+	if err := command.init(); err != nil {
+		t.Fatalf("command.init() = %v", err)
+	}
+
+	var set artifactSet
+	command.collectArtifacts(dir, &set)
+	defer set.close()
+
+	var names []string
+	for _, f := range set.files {
+		names = append(names, f.name)
+	}
+	if want := []string{"report.txt", "sub/inner.txt", "alias/inner.txt"}; !slices.Equal(names, want) {
+		t.Errorf("collected %q, want %q", names, want)
+	}
+	if want := []string{"large.txt: 100 B is over the limit of 50 B"}; !slices.Equal(set.skipped, want) {
+		t.Errorf("skipped %q, want %q", set.skipped, want)
+	}
+}
+
+func TestSendArtifactsUploadsCollectedSize(t *testing.T) {
+	b, api := newFakeBotAPI(t, func(req fakeBotAPIRequest) fakeBotAPIResponse {
+		switch req.Method {
+		case "sendPhoto":
+			// Reject the photo, so that it is uploaded again as a document.
+			return fakeBotAPIResponse{ErrorCode: http.StatusBadRequest, Description: "Bad Request: PHOTO_INVALID_DIMENSIONS"}
+		default:
+			return fakeBotAPIResponse{Result: models.Message{ID: 100}}
+		}
+	})
+
+	dir := t.TempDir()
+	for _, name := range [...]string{"chart.png", "log.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("collected\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	command := Command{Name: "build", Artifacts: []string{"*"}}
+	if err := command.init(); err != nil {
+		t.Fatalf("command.init() = %v", err)
+	}
+	var set artifactSet
+	command.collectArtifacts(dir, &set)
+	defer set.close()
+
+	// A process left behind by the command keeps writing to the files after they are collected.
+	for _, name := range [...]string{"chart.png", "log.txt"} {
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.WriteString("written after collection\n"); err != nil {
+			t.Fatal(err)
+		}
+		if err = f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	message := &models.Message{ID: 1, Chat: models.Chat{ID: 2}}
+	if err := sendArtifacts(t.Context(), b, message, &set); err != nil {
+		t.Fatalf("sendArtifacts() = %v", err)
+	}
+
+	photos := api.Requests("sendPhoto")
+	if len(photos) != 1 {
+		t.Fatalf("sent %d photos, want 1", len(photos))
+	}
+	if got := string(photos[0].Files["photo"]); got != "collected\n" {
+		t.Errorf("uploaded photo = %q, want %q", got, "collected\n")
+	}
+
+	documents := api.Requests("sendDocument")
+	if len(documents) != 2 {
+		t.Fatalf("sent %d documents, want 2", len(documents))
+	}
+	for i, want := range [...]string{"chart.png", "log.txt"} {
+		if got := documents[i].Form.Get("caption"); got != want {
+			t.Errorf("document %d caption = %q, want %q", i, got, want)
+		}
+		if got := string(documents[i].Files["document"]); got != "collected\n" {
+			t.Errorf("uploaded document %d = %q, want %q", i, got, "collected\n")
+		}
+	}
+}
+
+func TestSkippedArtifactsText(t *testing.T) {
+	names := func(n, length int) []string {
+		skipped := make([]string, n)
+		for i := range skipped {
+			skipped[i] = fmt.Sprintf("%0*d: over the limit of 10 files", length, i)
+		}
+		return skipped
+	}
+
+	for _, c := range [...]struct {
+		name       string
+		skipped    []string
+		wantListed int
+	}{
+		{"One", names(1, 3), 1},
+		{"AtCountLimit", names(maxListedSkippedArtifacts, 3), maxListedSkippedArtifacts},
+		{"OverCountLimit", names(500, 3), maxListedSkippedArtifacts},
+		{"OverLengthLimit", names(50, 1000), 3},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			text := skippedArtifactsText(c.skipped)
+			if n := MessageLength(text); n > MaxMessageLength {
+				t.Errorf("message length = %d, want at most %d", n, MaxMessageLength)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+			if lines[0] != "Skipped artifacts:" {
+				t.Errorf("first line = %q, want %q", lines[0], "Skipped artifacts:")
+			}
+			lines = lines[1:]
+
+			wantLines := c.wantListed
+			if unlisted := len(c.skipped) - c.wantListed; unlisted > 0 {
+				wantLines++
+				if want := fmt.Sprintf("- and %d more", unlisted); lines[len(lines)-1] != want {
+					t.Errorf("last line = %q, want %q", lines[len(lines)-1], want)
+				}
+			}
+			if len(lines) != wantLines {
+				t.Fatalf("listed %d lines, want %d", len(lines), wantLines)
+			}
+			for i := range c.wantListed {
+				if want := "- " + c.skipped[i]; lines[i] != want {
+					t.Errorf("line %d = %q, want %q", i, lines[i], want)
+				}
+			}
+		})
+	}
+}
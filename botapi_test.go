@@ -0,0 +1,123 @@
+package rcebot
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot"
+)
+
+// fakeBotToken is the bot token used with [fakeBotAPI].
+const fakeBotToken = "123456:fake-bot-token-secret"
+
+// fakeBotAPIRequest is a method call received by [fakeBotAPI].
+type fakeBotAPIRequest struct {
+	Time   time.Time
+	Method string
+	Form   url.Values
+
+	// Files maps the names of the uploaded files' form fields to their contents.
+	Files map[string][]byte
+}
+
+// fakeBotAPIResponse is the response of [fakeBotAPI] to a method call.
+// If ErrorCode is zero, the call succeeds with Result.
+type fakeBotAPIResponse struct {
+	Result      any
+	ErrorCode   int
+	Description string
+	RetryAfter  int
+}
+
+// fakeBotAPI is a fake Bot API server that records method calls,
+// and answers them with the responses of a handler.
+type fakeBotAPI struct {
+	// Mux serves the requests to the server. Tests may register handlers for other paths,
+	// such as file downloads under "/file/bot<token>/".
+	Mux *http.ServeMux
+
+	server *httptest.Server
+	handle func(req fakeBotAPIRequest) fakeBotAPIResponse
+
+	mu       sync.Mutex
+	requests []fakeBotAPIRequest
+}
+
+// newFakeBotAPI starts a fake Bot API server that answers method calls with handle,
+// and returns it along with a bot that uses it.
+func newFakeBotAPI(t *testing.T, handle func(req fakeBotAPIRequest) fakeBotAPIResponse) (*bot.Bot, *fakeBotAPI) {
+	t.Helper()
+
+	api := fakeBotAPI{
+		Mux:    http.NewServeMux(),
+		handle: handle,
+	}
+	api.Mux.HandleFunc("POST /bot"+fakeBotToken+"/{method}", api.serveMethod)
+	api.server = httptest.NewServer(api.Mux)
+	t.Cleanup(api.server.Close)
+
+	b, err := bot.New(fakeBotToken, bot.WithServerURL(api.server.URL), bot.WithSkipGetMe())
+	if err != nil {
+		t.Fatalf("bot.New() = %v", err)
+	}
+	return b, &api
+}
+
+func (api *fakeBotAPI) serveMethod(w http.ResponseWriter, r *http.Request) {
+	req := fakeBotAPIRequest{
+		Time:   time.Now(),
+		Method: r.PathValue("method"),
+		Form:   url.Values{},
+	}
+	if err := r.ParseMultipartForm(1 << 20); err == nil {
+		req.Form = r.MultipartForm.Value
+		for field, headers := range r.MultipartForm.File {
+			if req.Files == nil {
+				req.Files = make(map[string][]byte)
+			}
+			if f, err := headers[0].Open(); err == nil {
+				req.Files[field], _ = io.ReadAll(f)
+				_ = f.Close()
+			}
+		}
+	}
+
+	api.mu.Lock()
+	api.requests = append(api.requests, req)
+	api.mu.Unlock()
+
+	resp := api.handle(req)
+
+	body := map[string]any{"ok": resp.ErrorCode == 0}
+	if resp.ErrorCode == 0 {
+		body["result"] = resp.Result
+	} else {
+		body["error_code"] = resp.ErrorCode
+		body["description"] = resp.Description
+		if resp.RetryAfter != 0 {
+			body["parameters"] = map[string]int{"retry_after": resp.RetryAfter}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// Requests returns the method calls received so far with the given method name, in order.
+func (api *fakeBotAPI) Requests(method string) []fakeBotAPIRequest {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	var requests []fakeBotAPIRequest
+	for _, req := range api.requests {
+		if strings.EqualFold(req.Method, method) {
+			requests = append(requests, req)
+		}
+	}
+	return requests
+}
@@ -0,0 +1,276 @@
+package rcebot
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultOutputHeadSize is the default number of bytes kept from the start of a command's output.
+	DefaultOutputHeadSize = 512 << 10
+
+	// DefaultOutputTailSize is the default number of bytes kept from the end of a command's output.
+	DefaultOutputTailSize = 512 << 10
+)
+
+// OutputMode specifies how a command's standard output and standard error are captured and rendered.
+type OutputMode string
+
+const (
+	// OutputModeMerged captures both streams into a single pipe, preserving their interleaving order exactly,
+	// and renders them in a single code block.
+	OutputModeMerged OutputMode = "merged"
+
+	// OutputModeSeparate captures the streams separately, and renders them in two labelled code blocks.
+	OutputModeSeparate OutputMode = "separate"
+
+	// OutputModeStdout captures the streams separately, and renders only standard output,
+	// unless the command fails, in which case standard error is rendered after it in a labelled code block.
+	OutputModeStdout OutputMode = "stdout"
+)
+
+// IsValid returns whether the mode is a known mode.
+func (m OutputMode) IsValid() bool {
+	switch m {
+	case OutputModeMerged, OutputModeSeparate, OutputModeStdout:
+		return true
+	default:
+		return false
+	}
+}
+
+// errOutputLimitExceeded is the cancellation cause of a command that exceeded its hard output limit.
+var errOutputLimitExceeded = errors.New("output exceeded the limit")
+
+// OutputBuffer captures command output in bounded memory. It keeps a fixed number of bytes from the start
+// of the output, and a fixed number of bytes from the end in a ring buffer, dropping everything in between.
+//
+// It is safe for concurrent use. The zero value keeps nothing; use [NewOutputBuffer] to create a useful buffer.
+type OutputBuffer struct {
+	mu        sync.Mutex
+	headSize  int
+	tailSize  int
+	head      []byte
+	tail      []byte
+	tailStart int
+	written   int64
+}
+
+// NewOutputBuffer returns a new output buffer that keeps the first headSize and the last tailSize bytes.
+func NewOutputBuffer(headSize, tailSize int) *OutputBuffer {
+	return &OutputBuffer{
+		headSize: headSize,
+		tailSize: tailSize,
+	}
+}
+
+// Write implements [io.Writer]. It never fails.
+func (b *OutputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(p)
+	b.written += int64(n)
+
+	if room := b.headSize - len(b.head); room > 0 {
+		room = min(room, len(p))
+		b.head = append(b.head, p[:room]...)
+		p = p[room:]
+	}
+
+	if room := b.tailSize - len(b.tail); room > 0 {
+		room = min(room, len(p))
+		b.tail = append(b.tail, p[:room]...)
+		p = p[room:]
+	}
+
+	if len(p) == 0 {
+		return n, nil
+	}
+
+	// The ring is full. Overwrite the oldest bytes.
+	if len(p) >= len(b.tail) {
+		copy(b.tail, p[len(p)-len(b.tail):])
+		b.tailStart = 0
+		return n, nil
+	}
+	copied := copy(b.tail[b.tailStart:], p)
+	copy(b.tail, p[copied:])
+	b.tailStart = (b.tailStart + len(p)) % len(b.tail)
+	return n, nil
+}
+
+// Written returns the total number of bytes written to the buffer.
+func (b *OutputBuffer) Written() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.written
+}
+
+// Dropped returns the number of bytes written to the buffer but not kept.
+func (b *OutputBuffer) Dropped() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.droppedLocked()
+}
+
+func (b *OutputBuffer) droppedLocked() int64 {
+	return b.written - int64(len(b.head)) - int64(len(b.tail))
+}
+
+// Bytes returns a copy of the kept output. If any bytes were dropped, a marker line with
+// the number of dropped bytes is inserted between the head and the tail.
+func (b *OutputBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	dropped := b.droppedLocked()
+	if dropped == 0 {
+		buf := make([]byte, 0, len(b.head)+len(b.tail))
+		buf = append(buf, b.head...)
+		return b.appendTailLocked(buf)
+	}
+
+	// Cut both ends of the gap on rune boundaries.
+	head := b.head
+	for len(head) > 0 && !utf8.FullRune(head[lastRuneStart(head):]) {
+		cut := lastRuneStart(head)
+		dropped += int64(len(head) - cut)
+		head = head[:cut]
+	}
+
+	tail := b.appendTailLocked(make([]byte, 0, len(b.tail)))
+	for len(tail) > 0 && !utf8.RuneStart(tail[0]) {
+		tail = tail[1:]
+		dropped++
+	}
+
+	buf := make([]byte, 0, len(head)+1+len("[... 18446744073709551615 bytes dropped ...]\n")+len(tail))
+	buf = append(buf, head...)
+	if len(head) > 0 && head[len(head)-1] != '\n' {
+		buf = append(buf, '\n')
+	}
+	buf = fmt.Appendf(buf, "[... %d bytes dropped ...]\n", dropped)
+	return append(buf, tail...)
+}
+
+// appendTailLocked appends the contents of the ring buffer in order to dst.
+func (b *OutputBuffer) appendTailLocked(dst []byte) []byte {
+	dst = append(dst, b.tail[b.tailStart:]...)
+	return append(dst, b.tail[:b.tailStart]...)
+}
+
+// AppendTail appends the last at most n bytes of the kept output to dst,
+// with any incomplete UTF-8 sequence at the start removed.
+func (b *OutputBuffer) AppendTail(dst []byte, n int) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	segments := [3][]byte{b.tail[:b.tailStart], b.tail[b.tailStart:], b.head}
+	if b.droppedLocked() > 0 {
+		// Do not join the head to the tail across the gap.
+		segments[2] = nil
+	}
+
+	var count int
+	i := 0
+	for ; i < len(segments) && count < n; i++ {
+		count += len(segments[i])
+	}
+
+	start := len(dst)
+	for i--; i >= 0; i-- {
+		seg := segments[i]
+		if count > n {
+			seg = seg[count-n:]
+			count = n
+		}
+		dst = append(dst, seg...)
+	}
+
+	tail := dst[start:]
+	for len(tail) > 0 && !utf8.RuneStart(tail[0]) {
+		tail = tail[1:]
+	}
+	return append(dst[:start], tail...)
+}
+
+// lastRuneStart returns the index of the start of the last rune in b.
+func lastRuneStart(b []byte) int {
+	i := len(b) - 1
+	for i > 0 && len(b)-i < utf8.UTFMax && !utf8.RuneStart(b[i]) {
+		i--
+	}
+	return max(i, 0)
+}
+
+// outputLimiter counts the bytes written through all its [limitedWriter]s,
+// and calls exceeded once the total exceeds limit.
+type outputLimiter struct {
+	limit    int64
+	written  atomic.Int64
+	once     sync.Once
+	exceeded func()
+}
+
+// limitedWriter is an [io.Writer] that counts the bytes written to w against a shared [outputLimiter].
+type limitedWriter struct {
+	w       io.Writer
+	limiter *outputLimiter
+}
+
+// Write implements [io.Writer].
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.limiter.written.Add(int64(len(p))) > w.limiter.limit {
+		w.limiter.once.Do(w.limiter.exceeded)
+	}
+	return w.w.Write(p)
+}
+
+// timestampLayout is the layout of the timestamp prefixed to each line of output.
+const timestampLayout = "15:04:05.000 "
+
+// timestampWriter is an [io.Writer] that prefixes each line written to w with the time
+// at which its first byte was written.
+type timestampWriter struct {
+	w       io.Writer
+	midLine bool
+	buf     []byte
+}
+
+// Write implements [io.Writer].
+func (w *timestampWriter) Write(p []byte) (int, error) {
+	w.buf = w.buf[:0]
+	for _, c := range p {
+		if !w.midLine {
+			w.buf = time.Now().AppendFormat(w.buf, timestampLayout)
+			w.midLine = true
+		}
+		w.buf = append(w.buf, c)
+		if c == '\n' {
+			w.midLine = false
+		}
+	}
+
+	if _, err := w.w.Write(w.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// wrapOutput returns the writer that the command writes to w through, applying the shared hard output limit
+// if limiter is not nil, and prefixing timestamps if enabled.
+func (c *Command) wrapOutput(w io.Writer, limiter *outputLimiter) io.Writer {
+	if c.Timestamps {
+		w = &timestampWriter{w: w}
+	}
+	if limiter != nil {
+		w = &limitedWriter{w: w, limiter: limiter}
+	}
+	return w
+}
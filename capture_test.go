@@ -0,0 +1,102 @@
+package rcebot_test
+
+import (
+	"testing"
+
+	rcebot "github.com/database64128/cubic-rce-bot"
+)
+
+func TestOutputBuffer(t *testing.T) {
+	for _, c := range [...]struct {
+		name        string
+		headSize    int
+		tailSize    int
+		writes      []string
+		wantBytes   string
+		wantDropped int64
+		wantTail4   string
+	}{
+		{
+			name:      "Empty",
+			headSize:  4,
+			tailSize:  4,
+			wantBytes: "",
+		},
+		{
+			name:      "FitsInHead",
+			headSize:  8,
+			tailSize:  4,
+			writes:    []string{"abc", "de"},
+			wantBytes: "abcde",
+			wantTail4: "bcde",
+		},
+		{
+			name:      "FillsHeadAndTail",
+			headSize:  4,
+			tailSize:  4,
+			writes:    []string{"abcdef", "gh"},
+			wantBytes: "abcdefgh",
+			wantTail4: "efgh",
+		},
+		{
+			name:        "WrapsTail",
+			headSize:    4,
+			tailSize:    4,
+			writes:      []string{"abc\n", "12", "34", "56", "7\n"},
+			wantBytes:   "abc\n[... 4 bytes dropped ...]\n567\n",
+			wantDropped: 4,
+			wantTail4:   "567\n",
+		},
+		{
+			name:        "LargeWrite",
+			headSize:    2,
+			tailSize:    3,
+			writes:      []string{"0123456789"},
+			wantBytes:   "01\n[... 5 bytes dropped ...]\n789",
+			wantDropped: 5,
+			wantTail4:   "789",
+		},
+		{
+			name:        "NoHead",
+			headSize:    0,
+			tailSize:    3,
+			writes:      []string{"0123", "45"},
+			wantBytes:   "[... 3 bytes dropped ...]\n345",
+			wantDropped: 3,
+			wantTail4:   "345",
+		},
+		{
+			name:        "CutsOnRuneBoundaries",
+			headSize:    3,
+			tailSize:    3,
+			writes:      []string{"a✓", "xyz", "✓b"},
+			wantBytes:   "a\n[... 9 bytes dropped ...]\nb",
+			wantDropped: 5,
+			wantTail4:   "b",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			b := rcebot.NewOutputBuffer(c.headSize, c.tailSize)
+			var written int64
+			for _, w := range c.writes {
+				n, err := b.Write([]byte(w))
+				if err != nil || n != len(w) {
+					t.Fatalf("Write(%q) = %d, %v, want %d, nil", w, n, err, len(w))
+				}
+				written += int64(n)
+			}
+			if got := b.Written(); got != written {
+				t.Errorf("Written() = %d, want %d", got, written)
+			}
+			if got := b.Dropped(); got != c.wantDropped {
+				t.Errorf("Dropped() = %d, want %d", got, c.wantDropped)
+			}
+			if got := string(b.Bytes()); got != c.wantBytes {
+				t.Errorf("Bytes() = %q, want %q", got, c.wantBytes)
+			}
+			if got := string(b.AppendTail(nil, 4)); got != c.wantTail4 {
+				t.Errorf("AppendTail(nil, 4) = %q, want %q", got, c.wantTail4)
+			}
+		})
+	}
+}
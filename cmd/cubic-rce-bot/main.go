@@ -38,6 +38,8 @@ func init() {
 }
 
 func main() {
+	rcebot.MaybeRunExecHelper()
+
 	flag.Parse()
 
 	mainModuleVersion := "unknown"
@@ -86,6 +88,7 @@ func main() {
 	}
 
 	if testConf {
+		r.CheckSandboxProfiles()
 		logger.Info("Config test OK", slog.String("confPath", confPath))
 		return
 	}
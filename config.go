@@ -1,9 +1,13 @@
 package rcebot
 
 import (
-	"bytes"
-	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
 	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/database64128/cubic-rce-bot/jsoncfg"
@@ -16,6 +20,9 @@ const (
 
 	// DefaultExitTimeout is the default command exit timeout.
 	DefaultExitTimeout = 5 * time.Second
+
+	// DefaultJobHistorySize is the default number of finished jobs kept in the history of each user.
+	DefaultJobHistorySize = 20
 )
 
 // Config is the configuration for the bot.
@@ -30,8 +37,42 @@ type Config struct {
 	// Webhook is the webhook server configuration.
 	Webhook webhook.Config `json:"webhook,omitzero"`
 
+	// MaxConcurrentJobs is the maximum number of commands running at the same time across all users.
+	// Further executions wait in a queue until a running command exits.
+	//
+	// If zero, there is no limit.
+	MaxConcurrentJobs int `json:"maxConcurrentJobs,omitzero"`
+
+	// JobHistorySize is the number of finished jobs kept in memory for each user,
+	// for `/jobs`, `/status` and `/output`.
+	//
+	// If zero, [DefaultJobHistorySize] is used.
+	JobHistorySize int `json:"jobHistorySize,omitzero"`
+
+	// ResponseTemplate is the optional default response template of commands without [Command.ResponseTemplate].
+	// See [ParseResponseTemplate] for the template syntax.
+	//
+	// Pipelines, commands with [Command.Diff], and commands with [OverflowPolicySplit] always use the default response.
+	ResponseTemplate string `json:"responseTemplate,omitzero"`
+
+	// DefaultLimits is the default resource limits of all commands.
+	// Limits set in [Command.Limits] take precedence.
+	//
+	// Only supported on Linux.
+	DefaultLimits ResourceLimits `json:"defaultLimits,omitzero"`
+
+	// SandboxProfiles is the map of sandbox profile names to profiles,
+	// which commands reference by name in [Command.Sandbox].
+	//
+	// Only supported on Linux.
+	SandboxProfiles map[string]SandboxProfile `json:"sandboxProfiles,omitzero"`
+
 	// Users is the list of authorized users.
 	Users []User `json:"users"`
+
+	// Schedules is the optional list of commands to run on a schedule,
+	// with their results posted to the configured chats.
+	Schedules []Schedule `json:"schedules,omitzero"`
 }
 
 // User is an authorized user.
@@ -39,6 +80,15 @@ type User struct {
 	// ID is the Telegram user ID.
 	ID int64 `json:"id"`
 
+	// Priority is the queue priority of the user's executions.
+	// When executions are queued, those with higher priority start first,
+	// and those with the same priority start in the order they were requested.
+	Priority int `json:"priority,omitzero"`
+
+	// Admin allows the user to view the jobs of all users, including scheduled commands,
+	// with `/jobs`, `/status` and `/output`, and to cancel them with `/cancel <job ID>`.
+	Admin bool `json:"admin,omitzero"`
+
 	// Commands is the list of commands the user is allowed to execute.
 	Commands []Command `json:"commands"`
 }
@@ -49,46 +99,613 @@ type Command struct {
 	Name string `json:"name"`
 
 	// Args is the list of command arguments.
+	//
+	// Arguments may contain "${name}" placeholders, which are replaced with the values of the named parameters.
 	Args []string `json:"args,omitzero"`
 
+	// Description is the optional short description of the command shown in `/list`.
+	Description string `json:"description,omitzero"`
+
+	// Script is the optional body of an inline script to run with [Command.Interpreter].
+	// [Command.Args] are passed to the script.
+	//
+	// If set, [Command.Name] is only a name for the script shown in `/list` and logs,
+	// and the script body is not shown in `/list`.
+	Script string `json:"script,omitzero"`
+
+	// Interpreter is the command line of the interpreter that runs [Command.Script], split on white space,
+	// e.g., "/bin/sh -eu" or "python3".
+	//
+	// If empty, [DefaultInterpreter] is used.
+	Interpreter string `json:"interpreter,omitzero"`
+
+	// ScriptMode specifies how [Command.Script] is passed to the interpreter.
+	//
+	// If empty, [ScriptModeFile] is used.
+	ScriptMode ScriptMode `json:"scriptMode,omitzero"`
+
+	// Steps makes the command a pipeline, which runs other commands of the same user in order as its steps,
+	// and replies with the status and output of each step. When a step fails, the remaining steps are skipped,
+	// unless it is set to continue on error. Canceling the pipeline stops the current step and skips the rest.
+	//
+	// Pipelines only use [Command.Name], [Command.Description], [Command.MaxConcurrency], [Command.Overflow],
+	// [Command.CompressDocument], [Command.Diff], [Command.Detach], [Command.Notify] and [Command.NotifyAfter]
+	// of their own. Each step runs with the settings of its command.
+	Steps []PipelineStep `json:"steps,omitzero"`
+
+	// Guard is the optional step of a pipeline that runs first, and must succeed for the other steps to run.
+	Guard *PipelineStep `json:"guard,omitzero"`
+
+	// Params is the optional list of parameters the user may supply when executing the command.
+	Params []CommandParam `json:"params,omitzero"`
+
 	// ExecTimeout is the command execution timeout.
-	// When command execution exceeds this timeout, an interrupt signal is sent to the process.
-	// If the process does not exit within [ExitTimeoutSec], it is terminated.
+	// When command execution exceeds this timeout, or the command is canceled,
+	// the command is stopped by walking the [StopSignals] ladder.
 	//
-	// If zero, [DefaultExecTimeout] is used.
+	// If zero, [DefaultExecTimeout] is used, except for interactive sessions,
+	// which then run until they are idle for [Command.IdleTimeout].
 	ExecTimeout jsoncfg.Duration `json:"execTimeout,omitzero"`
 
 	// ExitTimeout is the command exit timeout.
-	// When command execution exceeds [ExecTimeoutSec], an interrupt signal is sent to the process.
-	// If the process does not exit within this timeout, it is terminated.
+	// It is only used when [StopSignals] is empty, in which case an interrupt signal is sent to the process group,
+	// and if the command does not exit within this timeout, it is killed.
 	//
 	// If zero, [DefaultExitTimeout] is used.
 	ExitTimeout jsoncfg.Duration `json:"exitTimeout,omitzero"`
 
-	cancel          atomic.Pointer[context.CancelFunc]
-	outputBuffer    bytes.Buffer
-	responseBuilder CommandOutputResponseBuilder
+	// StopSignals is the optional ladder of signals sent to the command's process group to stop it,
+	// e.g., SIGINT, wait 5s, SIGTERM, wait 10s, SIGKILL.
+	// If the command is still running after the last step, it is killed.
+	//
+	// On Unix-like systems, each command runs in its own process group, so that its descendants are also stopped.
+	// Once the command exits after a signal, the processes left in its process group are killed.
+	StopSignals []StopStep `json:"stopSignals,omitzero"`
+
+	// Dir is the optional working directory of the command.
+	//
+	// If empty, the command runs in the bot's working directory.
+	Dir string `json:"dir,omitzero"`
+
+	// Env is the optional map of environment variables to set for the command.
+	// These take precedence over inherited variables.
+	Env map[string]string `json:"env,omitzero"`
+
+	// InheritEnv is the optional allowlist of environment variables to pass through from the bot's environment.
+	//
+	// If null or omitted, the command inherits the bot's entire environment.
+	// If an empty list, no variables are inherited.
+	InheritEnv []string `json:"inheritEnv,omitzero"`
+
+	// Umask is the optional file mode creation mask of the command.
+	// It must be an octal number in a string (e.g., "0027").
+	//
+	// Only supported on Unix-like systems.
+	Umask *jsoncfg.FileMode `json:"umask,omitzero"`
+
+	// RunAsUser optionally sets the user the command runs as.
+	// It can be an integer user ID or a string username.
+	//
+	// Only supported on Unix-like systems. The bot must have the privilege to switch users.
+	RunAsUser jsoncfg.IntOrString `json:"runAsUser,omitzero"`
+
+	// RunAsGroup optionally sets the primary group the command runs as.
+	// It can be an integer group ID or a string group name.
+	//
+	// If not set, the primary group of [RunAsUser] is used.
+	RunAsGroup jsoncfg.IntOrString `json:"runAsGroup,omitzero"`
+
+	// SupplementaryGroups optionally sets the supplementary groups of the command.
+	// Each can be an integer group ID or a string group name.
+	//
+	// If null or omitted, the groups [RunAsUser] is a member of are used,
+	// or none if [RunAsUser] is a user ID without a user account.
+	// If [RunAsUser] is not set either, the bot's supplementary groups are kept.
+	SupplementaryGroups []jsoncfg.IntOrString `json:"supplementaryGroups,omitzero"`
+
+	// Stream enables streaming mode, in which a progress message is sent as soon as the command starts,
+	// and periodically edited to show the elapsed time and the latest output, until the command exits.
+	Stream bool `json:"stream,omitzero"`
+
+	// StreamInterval is the interval between edits of the progress message in streaming mode.
+	//
+	// If zero, [DefaultStreamInterval] is used. It must not be less than [MinStreamInterval].
+	StreamInterval jsoncfg.Duration `json:"streamInterval,omitzero"`
+
+	// Overflow is the policy for handling output that does not fit in a single message.
+	//
+	// If empty, [OverflowPolicyTruncate] is used.
+	Overflow OverflowPolicy `json:"overflow,omitzero"`
+
+	// CompressDocument controls whether the output document is gzip-compressed
+	// when [OverflowPolicyDocument] is in effect.
+	CompressDocument bool `json:"compressDocument,omitzero"`
+
+	// MaxConcurrency is the maximum number of concurrent executions of the command.
+	//
+	// If zero, the command can only be executed once at a time.
+	MaxConcurrency int `json:"maxConcurrency,omitzero"`
+
+	// OutputHeadSize is the number of bytes kept from the start of the output.
+	//
+	// If zero, [DefaultOutputHeadSize] is used.
+	OutputHeadSize int `json:"outputHeadSize,omitzero"`
+
+	// OutputTailSize is the number of bytes kept from the end of the output.
+	// Output between the head and the tail is dropped, and the number of dropped bytes is reported in the response.
+	//
+	// If zero, [DefaultOutputTailSize] is used.
+	OutputTailSize int `json:"outputTailSize,omitzero"`
+
+	// MaxOutputSize is the hard limit on the total number of bytes the command may output.
+	// The command is stopped once it exceeds the limit.
+	//
+	// If zero, there is no hard limit.
+	MaxOutputSize int64 `json:"maxOutputSize,omitzero"`
+
+	// OutputMode specifies how standard output and standard error are captured and rendered.
+	//
+	// If empty, [OutputModeMerged] is used.
+	OutputMode OutputMode `json:"outputMode,omitzero"`
+
+	// Timestamps prefixes each line of output with the time it was written.
+	Timestamps bool `json:"timestamps,omitzero"`
+
+	// Stdin is where the command's standard input comes from.
+	//
+	// If empty, [StdinModeNone] is used.
+	Stdin StdinMode `json:"stdin,omitzero"`
+
+	// MaxStdinSize is the maximum size of the standard input in bytes.
+	//
+	// If zero, [DefaultMaxStdinSize] is used.
+	MaxStdinSize int64 `json:"maxStdinSize,omitzero"`
+
+	// UploadDir is the optional absolute path to the directory where documents sent with `/exec` are saved.
+	// If set, the command takes a document, either attached to the `/exec` message as its caption,
+	// or in the message `/exec` replies to. The document is saved under a sanitized unique name,
+	// and the "${upload}" placeholder in [Command.Args] is replaced with the path of the saved file.
+	//
+	// The directory and its missing parents are created if it does not exist. It should not be shared
+	// with other files, as expired files in it are removed. It cannot be combined with [Command.Stdin] modes
+	// that read documents.
+	UploadDir string `json:"uploadDir,omitzero"`
+
+	// UploadMIMETypes is the optional list of MIME types of documents the command accepts,
+	// either exact types (e.g., "application/pdf") or type wildcards (e.g., "image/*").
+	//
+	// If null or omitted, documents of any type are accepted.
+	UploadMIMETypes []string `json:"uploadMIMETypes,omitzero"`
+
+	// MaxUploadSize is the maximum size of an uploaded document in bytes.
+	//
+	// If zero, [DefaultMaxUploadSize] is used.
+	MaxUploadSize int64 `json:"maxUploadSize,omitzero"`
+
+	// UploadRetention is how long saved documents are kept in [Command.UploadDir].
+	// Expired files are removed when the next document is saved, unless an execution that uses them
+	// is still queued or running.
+	//
+	// If zero, each document is removed as soon as the command exits.
+	UploadRetention jsoncfg.Duration `json:"uploadRetention,omitzero"`
+
+	// Interactive runs the command in an interactive session on a pseudo-terminal.
+	// Its output is streamed with escape sequences removed, and subsequent plain-text messages
+	// from the user in the same chat are typed into the terminal as input lines.
+	//
+	// Interactive sessions are only supported on Linux, and cannot be combined with [Command.Stdin].
+	// In group chats, the bot only receives plain-text messages if its privacy mode is disabled via @BotFather,
+	// or if it is an administrator of the group.
+	Interactive bool `json:"interactive,omitzero"`
+
+	// IdleTimeout is how long an interactive session may go without input or output before it is stopped.
+	//
+	// If zero, [DefaultIdleTimeout] is used.
+	IdleTimeout jsoncfg.Duration `json:"idleTimeout,omitzero"`
+
+	// Diff makes the response show the unified diff of the output against that of the previous run
+	// of the same command line by the same user, or that nothing changed, with a button to show the full output.
+	// The first run shows the full output. Previous outputs are kept in memory,
+	// including those of background runs whose result is not sent.
+	//
+	// Interactive and streaming commands cannot show diffs.
+	Diff bool `json:"diff,omitzero"`
+
+	// Retry is the optional policy for retrying failed executions of the command.
+	// The result lists the status of every attempt, and shows the output of the last one.
+	// The command keeps its execution slot while waiting to retry, and canceling it stops further retries.
+	//
+	// Interactive and streaming commands cannot be retried.
+	Retry *RetryPolicy `json:"retry,omitzero"`
+
+	// ResponseTemplate is the optional template of the response to the command, which replaces the default response.
+	// See [ParseResponseTemplate] for the template syntax.
+	// If the template fails to execute, or Telegram rejects its result, the default response is sent instead.
+	// A response template produces a single message: if it does not fit, the output is shortened,
+	// and also sent as a document with [OverflowPolicyDocument].
+	//
+	// If empty, [Config.ResponseTemplate] is used.
+	// Pipelines, commands with [Command.Diff], and commands with [OverflowPolicySplit] cannot have response templates.
+	ResponseTemplate string `json:"responseTemplate,omitzero"`
+
+	// ExitStatuses is the optional map of exit codes to status labels (e.g., "🟡 degraded"),
+	// passed to the response template as [ResponseTemplateData.Status].
+	//
+	// Requires a response template.
+	ExitStatuses map[int]string `json:"exitStatuses,omitzero"`
+
+	// Detach makes `/exec` run the command in the background, like `/run`.
+	// The bot replies with the job ID right away, and sends the result in reply to the original message
+	// according to [Command.Notify] when the command exits.
+	//
+	// Interactive commands cannot be detached.
+	Detach bool `json:"detach,omitzero"`
+
+	// Notify is the policy for sending the result of the command when it runs in the background.
+	// Results that are not sent are still kept in the job history, but the artifacts of those runs are discarded.
+	//
+	// If empty, [NotifyPolicyAlways] is used.
+	Notify NotifyPolicy `json:"notify,omitzero"`
+
+	// NotifyAfter makes [NotifyPolicyFailure] also send the result of successful runs
+	// that take longer than this duration.
+	NotifyAfter jsoncfg.Duration `json:"notifyAfter,omitzero"`
+
+	// Artifacts is the optional list of glob patterns, in the syntax of [filepath.Match], of files to upload
+	// after the command exits. The patterns are relative to a fresh private scratch directory created for
+	// each execution, whose path is passed to the command in the [ScratchDirEnv] environment variable.
+	// Matching images are uploaded as photos, and other files as documents.
+	// The scratch directory is removed after the upload, or without uploading anything
+	// if the run is in the background and its result is not sent according to [Command.Notify].
+	Artifacts []string `json:"artifacts,omitzero"`
+
+	// MaxArtifacts is the maximum number of artifacts uploaded per execution.
+	//
+	// If zero, [DefaultMaxArtifacts] is used.
+	MaxArtifacts int `json:"maxArtifacts,omitzero"`
+
+	// MaxArtifactSize is the maximum size of an uploaded artifact in bytes. Larger files are skipped.
+	//
+	// If zero, [DefaultMaxArtifactSize] is used.
+	MaxArtifactSize int64 `json:"maxArtifactSize,omitzero"`
+
+	// Limits is the optional set of resource limits of the command.
+	// Unset limits are taken from [Config.DefaultLimits].
+	//
+	// Only supported on Linux.
+	Limits *ResourceLimits `json:"limits,omitzero"`
+
+	// Sandbox is the optional name of the sandbox profile in [Config.SandboxProfiles] the command runs in.
+	//
+	// Profiles with namespaces cannot be combined with [Command.RunAsUser], [Command.RunAsGroup]
+	// or [Command.SupplementaryGroups].
+	Sandbox string `json:"sandbox,omitzero"`
+
+	priority               int
+	defaultLimits          ResourceLimits
+	limits                 ResourceLimits
+	rlimits                []execHelperRlimit
+	sandboxProfiles        map[string]SandboxProfile
+	sandbox                *SandboxProfile
+	globalResponseTemplate string
+	responseTemplate       *template.Template
+	credential             *credential
+	pipeline               []pipelineStep
+	running                atomic.Int32
 }
 
-// UserCommandsByID returns a map of user ID to list of commands.
-func (c Config) UserCommandsByID() map[int64][]Command {
+// init validates the command and initializes its internal state.
+func (c *Command) init() error {
+	if c.isPipeline() {
+		if err := c.validatePipeline(); err != nil {
+			return err
+		}
+	}
+
+	if c.ExecTimeout == 0 && !c.Interactive {
+		c.ExecTimeout = jsoncfg.Duration(DefaultExecTimeout)
+	}
+
+	if c.ExitTimeout == 0 {
+		c.ExitTimeout = jsoncfg.Duration(DefaultExitTimeout)
+	}
+
+	if c.MaxConcurrency < 0 {
+		return fmt.Errorf("negative max concurrency %d", c.MaxConcurrency)
+	}
+	if c.MaxConcurrency == 0 {
+		c.MaxConcurrency = 1
+	}
+
+	if err := c.initStopSignals(); err != nil {
+		return err
+	}
+
+	if c.StreamInterval != 0 && c.StreamInterval.Value() < MinStreamInterval {
+		return fmt.Errorf("stream interval %s is less than the minimum %s", c.StreamInterval.Value(), MinStreamInterval)
+	}
+
+	for key := range c.Env {
+		if key == "" || strings.ContainsAny(key, "=\x00") {
+			return fmt.Errorf("invalid environment variable name %q", key)
+		}
+	}
+
+	if c.Umask != nil && !execHelperSupported {
+		return fmt.Errorf("umask is not supported on %s", runtime.GOOS)
+	}
+
+	if err := c.initCredential(); err != nil {
+		return err
+	}
+
+	if err := c.initRlimits(); err != nil {
+		return err
+	}
+
+	if err := c.initSandbox(); err != nil {
+		return err
+	}
+
+	if c.Overflow != "" && !c.Overflow.IsValid() {
+		return fmt.Errorf("unknown overflow policy %q", c.Overflow)
+	}
+
+	if c.OutputHeadSize < 0 || c.OutputTailSize < 0 || c.MaxOutputSize < 0 {
+		return errors.New("output sizes must not be negative")
+	}
+	if c.OutputHeadSize == 0 {
+		c.OutputHeadSize = DefaultOutputHeadSize
+	}
+	if c.OutputTailSize == 0 {
+		c.OutputTailSize = DefaultOutputTailSize
+	}
+
+	if c.OutputMode == "" {
+		c.OutputMode = OutputModeMerged
+	}
+	if !c.OutputMode.IsValid() {
+		return fmt.Errorf("unknown output mode %q", c.OutputMode)
+	}
+
+	if err := c.initStdin(); err != nil {
+		return err
+	}
+
+	if err := c.initUpload(); err != nil {
+		return err
+	}
+
+	if c.Interactive {
+		if !ptySupported {
+			return fmt.Errorf("interactive sessions are not supported on %s", runtime.GOOS)
+		}
+		if c.Stdin != StdinModeNone {
+			return errors.New("interactive sessions cannot read stdin from messages or documents")
+		}
+		if c.OutputMode != OutputModeMerged {
+			return errors.New("interactive sessions only support the merged output mode")
+		}
+		if c.IdleTimeout == 0 {
+			c.IdleTimeout = jsoncfg.Duration(DefaultIdleTimeout)
+		}
+	}
+
+	if c.Diff && (c.Interactive || c.Stream) {
+		return errors.New("interactive and streaming commands cannot show diffs")
+	}
+
+	if err := c.initRetry(); err != nil {
+		return err
+	}
+
+	if err := c.initDetach(); err != nil {
+		return err
+	}
+
+	if err := c.initScript(); err != nil {
+		return err
+	}
+
+	if err := c.initArtifacts(); err != nil {
+		return err
+	}
+
+	if err := c.initResponseTemplate(); err != nil {
+		return err
+	}
+
+	if (c.Stream || c.Interactive) && c.StreamInterval == 0 {
+		c.StreamInterval = jsoncfg.Duration(DefaultStreamInterval)
+	}
+
+	for i := range c.Params {
+		param := &c.Params[i]
+		if err := param.init(); err != nil {
+			return err
+		}
+		if c.param(param.Name) != param {
+			return fmt.Errorf("duplicate parameter %q", param.Name)
+		}
+	}
+
+	for _, arg := range c.Args {
+		if err := forEachArgPlaceholder(arg, func(name string) error {
+			if c.param(name) == nil && (name != UploadPlaceholder || c.UploadDir == "") {
+				return fmt.Errorf("unknown parameter %q", name)
+			}
+			return nil
+		}); err != nil {
+			return fmt.Errorf("invalid argument %q: %w", arg, err)
+		}
+	}
+
+	return nil
+}
+
+// acquire reserves a concurrent execution slot of the command.
+// It returns false if the command is already running [MaxConcurrency] times.
+func (c *Command) acquire() bool {
+	if c.running.Add(1) > int32(c.MaxConcurrency) {
+		c.running.Add(-1)
+		return false
+	}
+	return true
+}
+
+// release releases a slot reserved by [Command.acquire].
+func (c *Command) release() {
+	c.running.Add(-1)
+}
+
+// param returns the first parameter with the given name, or nil if not found.
+func (c *Command) param(name string) *CommandParam {
+	for i := range c.Params {
+		if c.Params[i].Name == name {
+			return &c.Params[i]
+		}
+	}
+	return nil
+}
+
+// ResolveArgs validates the user-supplied parameter values and returns the command arguments
+// with all placeholders substituted.
+func (c *Command) ResolveArgs(values map[string]string) ([]string, error) {
+	resolved, err := c.resolveParams(values)
+	if err != nil {
+		return nil, err
+	}
+	return c.expandArgs(resolved), nil
+}
+
+// resolveParams validates the user-supplied parameter values, and returns the values of all parameters
+// with defaults filled in.
+func (c *Command) resolveParams(values map[string]string) (map[string]string, error) {
+	for name := range values {
+		if c.param(name) == nil {
+			return nil, fmt.Errorf("unknown parameter %q", name)
+		}
+	}
+
+	resolved := make(map[string]string, len(c.Params)+1)
+	for i := range c.Params {
+		param := &c.Params[i]
+		value, ok := values[param.Name]
+		if !ok {
+			if !param.Default.IsValid() {
+				return nil, fmt.Errorf("missing required parameter %q", param.Name)
+			}
+			value = param.defaultValue
+		}
+		value, err := param.resolve(value)
+		if err != nil {
+			return nil, err
+		}
+		resolved[param.Name] = value
+	}
+	return resolved, nil
+}
+
+// expandArgs returns the command arguments with placeholders replaced with the resolved values.
+func (c *Command) expandArgs(resolved map[string]string) []string {
+	if len(resolved) == 0 {
+		return c.Args
+	}
+
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = expandArg(arg, resolved)
+	}
+	return args
+}
+
+// Validate returns an error if the configuration is invalid.
+func (c Config) Validate() error {
+	if c.MaxConcurrentJobs < 0 {
+		return fmt.Errorf("negative max concurrent jobs %d", c.MaxConcurrentJobs)
+	}
+	if c.JobHistorySize < 0 {
+		return fmt.Errorf("negative job history size %d", c.JobHistorySize)
+	}
+	if c.ResponseTemplate != "" {
+		if _, err := ParseResponseTemplate("responseTemplate", c.ResponseTemplate); err != nil {
+			return fmt.Errorf("invalid response template: %w", err)
+		}
+	}
+	for name, profile := range c.SandboxProfiles {
+		if err := profile.Validate(); err != nil {
+			return fmt.Errorf("sandbox profile %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// UserCommandsByID validates the commands and returns a map of user ID to list of commands.
+func (c Config) UserCommandsByID() (map[int64][]Command, error) {
 	userCommandsByID := make(map[int64][]Command, len(c.Users))
 
 	for _, user := range c.Users {
 		for i := range user.Commands {
-			command := &user.Commands[i]
-
-			if command.ExecTimeout == 0 {
-				command.ExecTimeout = jsoncfg.Duration(DefaultExecTimeout)
+			if err := c.initCommand(&user.Commands[i], user.Priority); err != nil {
+				return nil, fmt.Errorf("user %d: command %d: %w", user.ID, i, err)
 			}
+		}
 
-			if command.ExitTimeout == 0 {
-				command.ExitTimeout = jsoncfg.Duration(DefaultExitTimeout)
+		for i := range user.Commands {
+			if err := user.Commands[i].initPipeline(user.Commands); err != nil {
+				return nil, fmt.Errorf("user %d: command %d: %w", user.ID, i, err)
 			}
 		}
 
 		userCommandsByID[user.ID] = user.Commands
 	}
 
-	return userCommandsByID
+	return userCommandsByID, nil
+}
+
+// AdminIDs returns the set of IDs of the users with [User.Admin].
+func (c Config) AdminIDs() map[int64]struct{} {
+	adminIDs := make(map[int64]struct{})
+	for _, user := range c.Users {
+		if user.Admin {
+			adminIDs[user.ID] = struct{}{}
+		}
+	}
+	return adminIDs
+}
+
+// ScheduledCommands validates the schedules and returns them with their commands initialized.
+func (c Config) ScheduledCommands() ([]Schedule, error) {
+	schedules := slices.Clone(c.Schedules)
+	names := make(map[string]struct{}, len(schedules))
+
+	for i := range schedules {
+		s := &schedules[i]
+		if _, ok := names[s.Name]; ok {
+			return nil, fmt.Errorf("schedule %d: duplicate name %q", i, s.Name)
+		}
+		names[s.Name] = struct{}{}
+
+		if s.UserID != 0 && !slices.ContainsFunc(c.Users, func(u User) bool { return u.ID == s.UserID }) {
+			return nil, fmt.Errorf("schedule %q: unknown user %d", s.Name, s.UserID)
+		}
+
+		if err := c.initCommand(&s.Command, s.Priority); err != nil {
+			return nil, fmt.Errorf("schedule %q: command: %w", s.Name, err)
+		}
+		if err := s.init(); err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", s.Name, err)
+		}
+	}
+
+	return schedules, nil
+}
+
+// initCommand sets the command's global settings and initializes it.
+func (c Config) initCommand(command *Command, priority int) error {
+	command.priority = priority
+	command.defaultLimits = c.DefaultLimits
+	command.sandboxProfiles = c.SandboxProfiles
+	command.globalResponseTemplate = c.ResponseTemplate
+	return command.init()
 }
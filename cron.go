@@ -0,0 +1,239 @@
+package rcebot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronField is the set of allowed values of a cron expression field, as a bit set.
+type cronField uint64
+
+// has returns whether v is in the set.
+func (f cronField) has(v int) bool {
+	return f&(1<<v) != 0
+}
+
+// cronFieldSpec describes the range and value names of a cron expression field.
+type cronFieldSpec struct {
+	name  string
+	min   int
+	max   int
+	names []string
+}
+
+var (
+	cronMinuteSpec = cronFieldSpec{name: "minute", min: 0, max: 59}
+	cronHourSpec   = cronFieldSpec{name: "hour", min: 0, max: 23}
+	cronDaySpec    = cronFieldSpec{name: "day of month", min: 1, max: 31}
+	cronMonthSpec  = cronFieldSpec{name: "month", min: 1, max: 12, names: []string{
+		"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec",
+	}}
+	cronWeekdaySpec = cronFieldSpec{name: "day of week", min: 0, max: 7, names: []string{
+		"sun", "mon", "tue", "wed", "thu", "fri", "sat",
+	}}
+)
+
+// cronDescriptors maps the predefined schedule descriptors to their equivalent expressions.
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// CronSchedule is a parsed schedule, either a standard 5-field cron expression
+// (minute, hour, day of month, month, day of week), a predefined descriptor such as "@daily",
+// or a fixed interval in the form "@every <duration>".
+type CronSchedule struct {
+	minute  cronField
+	hour    cronField
+	day     cronField
+	month   cronField
+	weekday cronField
+
+	// dayOrWeekday is true if both the day of month and the day of week fields are restricted,
+	// i.e., do not start with "*", in which case a time matches if either of them matches.
+	dayOrWeekday bool
+
+	// every is the fixed interval of an "@every" schedule, or 0 for cron expressions.
+	every time.Duration
+}
+
+// ParseCronSchedule parses a schedule specification.
+func ParseCronSchedule(spec string) (*CronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+
+	if d, ok := strings.CutPrefix(spec, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil {
+			return nil, err
+		}
+		if every < time.Second {
+			return nil, fmt.Errorf("interval %s is less than 1s", every)
+		}
+		return &CronSchedule{every: every}, nil
+	}
+
+	if strings.HasPrefix(spec, "@") {
+		expr, ok := cronDescriptors[spec]
+		if !ok {
+			return nil, fmt.Errorf("unknown descriptor %q", spec)
+		}
+		spec = expr
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+
+	var (
+		s   CronSchedule
+		err error
+	)
+	if s.minute, err = parseCronField(fields[0], &cronMinuteSpec); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[1], &cronHourSpec); err != nil {
+		return nil, err
+	}
+	if s.day, err = parseCronField(fields[2], &cronDaySpec); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[3], &cronMonthSpec); err != nil {
+		return nil, err
+	}
+	if s.weekday, err = parseCronField(fields[4], &cronWeekdaySpec); err != nil {
+		return nil, err
+	}
+
+	// Both 0 and 7 are Sunday.
+	if s.weekday.has(7) {
+		s.weekday |= 1
+	}
+
+	s.dayOrWeekday = !strings.HasPrefix(fields[2], "*") && !strings.HasPrefix(fields[4], "*")
+	return &s, nil
+}
+
+// parseCronField parses a comma-separated list of "*", values, and ranges, each with an optional "/step".
+func parseCronField(field string, spec *cronFieldSpec) (cronField, error) {
+	var f cronField
+	for part := range strings.SplitSeq(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		var lo, hi int
+		switch {
+		case rangePart == "*":
+			lo, hi = spec.min, spec.max
+		default:
+			loPart, hiPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = parseCronValue(loPart, spec); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = parseCronValue(hiPart, spec); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// "n/step" is short for "n-max/step".
+				hi = spec.max
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid %s range %q", spec.name, rangePart)
+			}
+		}
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid %s step %q", spec.name, stepPart)
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			f |= 1 << v
+		}
+	}
+	return f, nil
+}
+
+// parseCronValue parses a single value or name of a cron expression field.
+func parseCronValue(s string, spec *cronFieldSpec) (int, error) {
+	for i, name := range spec.names {
+		if strings.EqualFold(s, name) {
+			return spec.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < spec.min || v > spec.max {
+		return 0, fmt.Errorf("invalid %s %q", spec.name, s)
+	}
+	return v, nil
+}
+
+// errNoNextTime is returned when a cron expression never matches, such as "0 0 31 2 *".
+var errNoNextTime = errors.New("schedule never matches")
+
+// Next returns the first time after t that matches the schedule, in t's location.
+// It returns the zero time if there is no such time within the next five years.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every).Truncate(time.Second)
+	}
+
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !s.month.has(int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.hour.has(t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.minute.has(t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// matchesDay returns whether the day of t matches the day of month and day of week fields.
+func (s *CronSchedule) matchesDay(t time.Time) bool {
+	day := s.day.has(t.Day())
+	weekday := s.weekday.has(int(t.Weekday()))
+	if s.dayOrWeekday {
+		return day || weekday
+	}
+	return day && weekday
+}
+
+// validate returns an error if the schedule never matches.
+func (s *CronSchedule) validate() error {
+	if s.every > 0 {
+		return nil
+	}
+	if s.Next(time.Now()).IsZero() {
+		return errNoNextTime
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package rcebot_test
+
+import (
+	"testing"
+	"time"
+
+	rcebot "github.com/database64128/cubic-rce-bot"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	from := time.Date(2026, time.October, 16, 19, 30, 15, 0, time.UTC)
+
+	for _, c := range [...]struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, time.October, 16, 19, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, time.October, 16, 19, 45, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2026, time.October, 17, 3, 0, 0, 0, time.UTC)},
+		{"30 9-17/4 * * mon-fri", time.Date(2026, time.October, 19, 9, 30, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 13 * 5", time.Date(2026, time.October, 23, 12, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)},
+		{"5,10 20 * * *", time.Date(2026, time.October, 16, 20, 5, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, time.October, 16, 20, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)},
+		{"@every 1h30m", time.Date(2026, time.October, 16, 21, 0, 15, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+	} {
+		s, err := rcebot.ParseCronSchedule(c.spec)
+		if err != nil {
+			t.Errorf("ParseCronSchedule(%q) = %v", c.spec, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(c.want) {
+			t.Errorf("ParseCronSchedule(%q).Next(%v) = %v, want %v", c.spec, from, got, c.want)
+		}
+	}
+}
+
+func TestParseCronScheduleError(t *testing.T) {
+	for _, spec := range [...]string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * foo *",
+		"@every 10ms",
+		"@every soon",
+		"@reboot",
+	} {
+		if _, err := rcebot.ParseCronSchedule(spec); err == nil {
+			t.Errorf("ParseCronSchedule(%q) = nil, want error", spec)
+		}
+	}
+}
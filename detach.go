@@ -0,0 +1,82 @@
+package rcebot
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// NotifyPolicy is the policy for sending the result of a command that runs in the background.
+type NotifyPolicy string
+
+const (
+	// NotifyPolicyAlways sends the result of every run.
+	NotifyPolicyAlways NotifyPolicy = "always"
+
+	// NotifyPolicyFailure only sends the result of failed runs,
+	// and of successful runs that take longer than [Command.NotifyAfter], if set.
+	NotifyPolicyFailure NotifyPolicy = "failure"
+)
+
+// IsValid returns whether the policy is a known policy.
+func (p NotifyPolicy) IsValid() bool {
+	switch p {
+	case NotifyPolicyAlways, NotifyPolicyFailure:
+		return true
+	default:
+		return false
+	}
+}
+
+// initDetach validates the background execution settings of the command.
+func (c *Command) initDetach() error {
+	if c.Notify == "" {
+		c.Notify = NotifyPolicyAlways
+	}
+	if !c.Notify.IsValid() {
+		return fmt.Errorf("unknown notify policy %q", c.Notify)
+	}
+
+	if c.NotifyAfter < 0 {
+		return fmt.Errorf("negative notify after %s", c.NotifyAfter.Value())
+	}
+	if c.NotifyAfter != 0 && c.Notify != NotifyPolicyFailure {
+		return fmt.Errorf("notify after only applies to the %q notify policy", NotifyPolicyFailure)
+	}
+
+	if c.Detach && c.Interactive {
+		return errors.New("interactive sessions cannot be detached")
+	}
+	if c.Notify == NotifyPolicyFailure && c.Stream {
+		return errors.New("streamed output is always shown, and cannot be sent only on failure")
+	}
+
+	return nil
+}
+
+// describeNotify returns a short human-readable description of when the result of a background run is sent.
+func (c *Command) describeNotify() string {
+	if c.Notify != NotifyPolicyFailure {
+		return "result sent when it exits"
+	}
+	if notifyAfter := c.NotifyAfter.Value(); notifyAfter > 0 {
+		return "result sent only if it fails or runs longer than " + notifyAfter.String()
+	}
+	return "result sent only if it fails"
+}
+
+// notifies returns whether the result of the job should be sent when it runs in the background,
+// according to the command's notify policy. It must be called after the job's result is recorded.
+func (j *job) notifies() bool {
+	if j.command.Notify != NotifyPolicyFailure || !j.success {
+		return true
+	}
+	notifyAfter := j.command.NotifyAfter.Value()
+	return notifyAfter > 0 && j.endTime.Sub(j.runStartTime) > notifyAfter
+}
+
+// markStarted records that the job has left the queue and started running at the current time.
+func (j *job) markStarted() {
+	j.runStartTime = time.Now()
+	j.started.Store(true)
+}
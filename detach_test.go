@@ -0,0 +1,100 @@
+package rcebot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/database64128/cubic-rce-bot/jsoncfg"
+)
+
+func TestUserCommandsByIDDetach(t *testing.T) {
+	for _, c := range [...]struct {
+		name     string
+		commands []Command
+		wantErr  string
+	}{
+		{
+			name:     "Detach",
+			commands: []Command{{Name: "reflector", Detach: true}},
+		},
+		{
+			name:     "NotifyFailureAfter",
+			commands: []Command{{Name: "reflector", Detach: true, Notify: NotifyPolicyFailure, NotifyAfter: jsoncfg.Duration(time.Minute)}},
+		},
+		{
+			name:     "UnknownNotifyPolicy",
+			commands: []Command{{Name: "reflector", Notify: "never"}},
+			wantErr:  `unknown notify policy "never"`,
+		},
+		{
+			name:     "NotifyAfterWithoutFailurePolicy",
+			commands: []Command{{Name: "reflector", NotifyAfter: jsoncfg.Duration(time.Minute)}},
+			wantErr:  `notify after only applies to the "failure" notify policy`,
+		},
+		{
+			name:     "NegativeNotifyAfter",
+			commands: []Command{{Name: "reflector", Notify: NotifyPolicyFailure, NotifyAfter: jsoncfg.Duration(-time.Minute)}},
+			wantErr:  "negative notify after -1m0s",
+		},
+		{
+			name:     "NotifyFailureStream",
+			commands: []Command{{Name: "reflector", Notify: NotifyPolicyFailure, Stream: true}},
+			wantErr:  "streamed output is always shown, and cannot be sent only on failure",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			config := Config{
+				Users: []User{{ID: 1, Commands: c.commands}},
+			}
+			_, err := config.UserCommandsByID()
+			if c.wantErr == "" {
+				if err != nil {
+					t.Errorf("UserCommandsByID() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("UserCommandsByID() error = %v, want %q", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestJobNotifies(t *testing.T) {
+	for _, c := range [...]struct {
+		name        string
+		notify      NotifyPolicy
+		notifyAfter time.Duration
+		success     bool
+		duration    time.Duration
+		want        bool
+	}{
+		{name: "AlwaysSuccess", notify: NotifyPolicyAlways, success: true, duration: time.Second, want: true},
+		{name: "AlwaysFailure", notify: NotifyPolicyAlways, duration: time.Second, want: true},
+		{name: "FailureSuccess", notify: NotifyPolicyFailure, success: true, duration: time.Hour},
+		{name: "FailureFailure", notify: NotifyPolicyFailure, duration: time.Second, want: true},
+		{name: "FailureAfterFailure", notify: NotifyPolicyFailure, notifyAfter: time.Minute, duration: time.Second, want: true},
+		{name: "FailureAfterQuickSuccess", notify: NotifyPolicyFailure, notifyAfter: time.Minute, success: true, duration: 30 * time.Second},
+		{name: "FailureAfterExactSuccess", notify: NotifyPolicyFailure, notifyAfter: time.Minute, success: true, duration: time.Minute},
+		{name: "FailureAfterSlowSuccess", notify: NotifyPolicyFailure, notifyAfter: time.Minute, success: true, duration: 2 * time.Minute, want: true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			command := Command{Name: "reflector", Detach: true, Notify: c.notify, NotifyAfter: jsoncfg.Duration(c.notifyAfter)}
+			if err := command.init(); err != nil {
+				t.Fatalf("command.init() = %v", err)
+			}
+
+			j := newJob(t.Context(), 1, 2, &command, 0, nil, nil)
+			j.markStarted()
+			j.record(nil, "", "", c.success)
+			// Time spent in the queue before the job started does not count.
+			j.startTime = j.runStartTime.Add(-time.Hour)
+			j.endTime = j.runStartTime.Add(c.duration)
+
+			if got := j.notifies(); got != c.want {
+				t.Errorf("j.notifies() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
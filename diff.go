@@ -0,0 +1,302 @@
+package rcebot
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// diffContextLines is the number of unchanged lines shown around each change in a unified diff.
+	diffContextLines = 3
+
+	// maxDiffEditDistance is the maximum number of inserted and deleted lines for which a minimal diff is computed.
+	// Larger diffs replace all lines, to bound the memory used by the diff algorithm.
+	maxDiffEditDistance = 1000
+
+	// maxOutputBaselinesPerUser is the maximum number of outputs kept per user for commands with [Command.Diff].
+	maxOutputBaselinesPerUser = 32
+)
+
+// UnifiedDiff returns the line-based unified diff from a to b, without file headers,
+// with [diffContextLines] lines of context around each change. It returns nil if a and b have the same lines.
+func UnifiedDiff(a, b []byte) []byte {
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	// aLines[i] and bLines[i] are the numbers of lines of a and b before ops[i].
+	aLines := make([]int, len(ops)+1)
+	bLines := make([]int, len(ops)+1)
+	for i, op := range ops {
+		aLines[i+1] = aLines[i]
+		bLines[i+1] = bLines[i]
+		if op.kind != '+' {
+			aLines[i+1]++
+		}
+		if op.kind != '-' {
+			bLines[i+1]++
+		}
+	}
+
+	var out []byte
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// Extend the hunk over changes separated by no more than twice the context.
+		start := max(i-diffContextLines, 0)
+		end := i
+		for {
+			for end < len(ops) && ops[end].kind != ' ' {
+				end++
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*diffContextLines {
+				end = min(end+diffContextLines, len(ops))
+				break
+			}
+			end = next
+		}
+
+		out = append(out, "@@ -"...)
+		out = appendHunkRange(out, aLines[start], aLines[end]-aLines[start])
+		out = append(out, " +"...)
+		out = appendHunkRange(out, bLines[start], bLines[end]-bLines[start])
+		out = append(out, " @@\n"...)
+		for _, op := range ops[start:end] {
+			out = append(out, op.kind)
+			out = append(out, op.line...)
+			out = append(out, '\n')
+		}
+
+		i = end
+	}
+	return out
+}
+
+// appendHunkRange appends the range of a hunk in the unified diff format to b,
+// given the number of lines before the hunk and the number of lines in it.
+func appendHunkRange(b []byte, before, count int) []byte {
+	start := before + 1
+	if count == 0 {
+		start = before
+	}
+	b = strconv.AppendInt(b, int64(start), 10)
+	if count != 1 {
+		b = append(b, ',')
+		b = strconv.AppendInt(b, int64(count), 10)
+	}
+	return b
+}
+
+// splitLines splits text into lines, ignoring the final newline.
+func splitLines(text []byte) []string {
+	if len(text) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(text), "\n"), "\n")
+}
+
+// diffOp is a line in an edit script: kept (' '), deleted ('-'), or inserted ('+').
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines returns the shortest edit script from a to b, computed with Myers' algorithm.
+// If the edit distance exceeds [maxDiffEditDistance], it returns an edit script that replaces all lines.
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	maxD := min(n+m, maxDiffEditDistance)
+
+	// v[offset+k] is the furthest x reached on diagonal k.
+	// trace[d] is the part of v for diagonals -d to d at the start of round d.
+	offset := maxD + 1
+	v := make([]int, 2*maxD+3)
+	var trace [][]int
+
+	for d := 0; d <= maxD; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrackDiff(a, b, trace)
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, n+m)
+	for _, line := range a {
+		ops = append(ops, diffOp{'-', line})
+	}
+	for _, line := range b {
+		ops = append(ops, diffOp{'+', line})
+	}
+	return ops
+}
+
+// backtrackDiff walks the trace of Myers' algorithm back from the end of a and b, and returns the edit script.
+func backtrackDiff(a, b []string, trace [][]int) []diffOp {
+	var ops []diffOp
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d] // v[d+k] is the furthest x on diagonal k.
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[d+k-1] < v[d+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		var prevX int
+		if d > 0 {
+			prevX = v[d+prevK]
+		}
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{'+', b[y-1]})
+			} else {
+				ops = append(ops, diffOp{'-', a[x-1]})
+			}
+			x, y = prevX, prevY
+		}
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// outputBaselineKey identifies the outputs of a command line run by a user.
+type outputBaselineKey struct {
+	userID      int64
+	commandLine string
+}
+
+// outputBaseline is the output of the last run of a command line by a user.
+type outputBaseline struct {
+	jobID  uint64
+	output []byte
+}
+
+// outputBaselines keeps the last output of each command line run by each user, for commands with [Command.Diff].
+// The number of outputs kept per user is bounded, evicting the oldest ones.
+type outputBaselines struct {
+	mu sync.Mutex
+	m  map[outputBaselineKey]outputBaseline
+}
+
+// swap stores baseline as the last output of key, and returns the previous one, if any.
+func (s *outputBaselines) swap(key outputBaselineKey, baseline outputBaseline) (outputBaseline, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, ok := s.m[key]
+	if !ok {
+		if s.m == nil {
+			s.m = make(map[outputBaselineKey]outputBaseline)
+		}
+
+		var (
+			count     int
+			oldestKey outputBaselineKey
+			oldestID  uint64
+		)
+		for k, b := range s.m {
+			if k.userID != key.userID {
+				continue
+			}
+			count++
+			if oldestID == 0 || b.jobID < oldestID {
+				oldestKey, oldestID = k, b.jobID
+			}
+		}
+		if count >= maxOutputBaselinesPerUser {
+			delete(s.m, oldestKey)
+		}
+	}
+
+	s.m[key] = baseline
+	return prev, ok
+}
+
+// recordBaseline stores the output of the job of a command with [Command.Diff] as the baseline for the next run
+// of the same command line by the same user, and keeps the previous baseline for the job's response.
+// It is called when the job's result is recorded, so that runs whose result is not sent still update the baseline.
+func (j *job) recordBaseline(baselines *outputBaselines) {
+	if !j.command.Diff {
+		return
+	}
+
+	var sb strings.Builder
+	writeCommandLine(&sb, j.command.Name, j.args)
+
+	prev, ok := baselines.swap(outputBaselineKey{
+		userID:      j.userID,
+		commandLine: sb.String(),
+	}, outputBaseline{
+		jobID:  j.id,
+		output: diffableOutput(j.sections),
+	})
+	if ok {
+		j.baseline = &prev
+	}
+}
+
+// diffResponse returns the sections and MarkdownV2 footer of the response to the job of a command with [Command.Diff],
+// showing the unified diff of the job's output against the baseline kept by [job.recordBaseline].
+// On the first run, the full output is shown. It also returns whether the response is a diff.
+func (j *job) diffResponse() (sections []OutputSection, footer string, isDiff bool) {
+	if j.baseline == nil {
+		return j.sections, "No previous output to compare with\\.\n" + j.footer, false
+	}
+
+	prevID := strconv.FormatUint(j.baseline.jobID, 10)
+	diff := UnifiedDiff(j.baseline.output, diffableOutput(j.sections))
+	if diff == nil {
+		return nil, "🟰 No changes since job " + prevID + "\\.\n" + j.footer, true
+	}
+	return []OutputSection{{Label: "changes since job " + prevID, Output: diff}}, j.footer, true
+}
+
+// diffableOutput returns the text of the output sections to diff, with the label of each section on its own line.
+func diffableOutput(sections []OutputSection) []byte {
+	if len(sections) == 1 && sections[0].Label == "" {
+		return sections[0].Output
+	}
+	var buf bytes.Buffer
+	for _, section := range sections {
+		buf.WriteString("[")
+		buf.WriteString(section.Label)
+		buf.WriteString("]\n")
+		buf.Write(section.Output)
+		if len(section.Output) > 0 && section.Output[len(section.Output)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
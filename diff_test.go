@@ -0,0 +1,168 @@
+package rcebot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUserCommandsByIDDiff(t *testing.T) {
+	for _, c := range [...]struct {
+		name     string
+		commands []Command
+		wantErr  string
+	}{
+		{
+			name:     "Diff",
+			commands: []Command{{Name: "df", Args: []string{"-h"}, Diff: true}},
+		},
+		{
+			name:     "Stream",
+			commands: []Command{{Name: "df", Args: []string{"-h"}, Diff: true, Stream: true}},
+			wantErr:  "interactive and streaming commands cannot show diffs",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			config := Config{
+				Users: []User{{ID: 1, Commands: c.commands}},
+			}
+			_, err := config.UserCommandsByID()
+			if c.wantErr == "" {
+				if err != nil {
+					t.Errorf("UserCommandsByID() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("UserCommandsByID() error = %v, want %q", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	for _, c := range [...]struct {
+		name string
+		a    string
+		b    string
+		want string
+	}{
+		{"Equal", "a\nb\nc\n", "a\nb\nc\n", ""},
+		{"BothEmpty", "", "", ""},
+		{"FinalNewline", "a\nb", "a\nb\n", ""},
+		{"FromEmpty", "", "a\nb\n", "@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"ToEmpty", "a\n", "", "@@ -1 +0,0 @@\n-a\n"},
+		{"Change", "a\nb\nc\n", "a\nx\nc\n", "@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{
+			"Context",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			"1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			"@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			"SeparateHunks",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			"one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			"@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+		{
+			"MergedHunks",
+			"1\n2\n3\n4\n5\n6\n7\n8\n",
+			"one\n2\n3\n4\n5\n6\n7\neight\n",
+			"@@ -1,8 +1,8 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+eight\n",
+		},
+		{
+			"Insert",
+			"sda1 ok\nsda2 ok\n",
+			"sda1 ok\nsdb1 failed\nsda2 ok\n",
+			"@@ -1,2 +1,3 @@\n sda1 ok\n+sdb1 failed\n sda2 ok\n",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := string(UnifiedDiff([]byte(c.a), []byte(c.b))); got != c.want {
+				t.Errorf("UnifiedDiff(%q, %q) = %q, want %q", c.a, c.b, got, c.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffLarge(t *testing.T) {
+	var a, b strings.Builder
+	for i := range 3000 {
+		a.WriteString("old ")
+		a.WriteString(strings.Repeat("x", i%7))
+		a.WriteByte('\n')
+		b.WriteString("new\n")
+	}
+
+	diff := string(UnifiedDiff([]byte(a.String()), []byte(b.String())))
+	if !strings.HasPrefix(diff, "@@ -1,3000 +1,3000 @@\n-old \n") {
+		t.Errorf("UnifiedDiff() = %q..., want a single hunk replacing all lines", diff[:min(len(diff), 64)])
+	}
+	if got, want := strings.Count(diff, "\n"), 1+3000+3000; got != want {
+		t.Errorf("UnifiedDiff() has %d lines, want %d", got, want)
+	}
+}
+
+func TestJobDiffResponse(t *testing.T) {
+	command := Command{Name: "df", Args: []string{"-h"}, Diff: true}
+	var baselines outputBaselines
+
+	newJob := func(id uint64, output string) *job {
+		j := &job{
+			id:       id,
+			userID:   1,
+			command:  &command,
+			args:     command.Args,
+			sections: []OutputSection{{Output: []byte(output)}},
+			footer:   "footer",
+		}
+		j.recordBaseline(&baselines)
+		return j
+	}
+
+	// The first run shows the full output.
+	j := newJob(1, "a\nb\n")
+	sections, footer, isDiff := j.diffResponse()
+	if isDiff {
+		t.Error("first run isDiff = true, want false")
+	}
+	if len(sections) != 1 || string(sections[0].Output) != "a\nb\n" {
+		t.Errorf("first run sections = %q, want the full output", sections)
+	}
+	if want := "No previous output to compare with\\.\nfooter"; footer != want {
+		t.Errorf("first run footer = %q, want %q", footer, want)
+	}
+
+	// A run whose response is never built still becomes the baseline of the next run.
+	_ = newJob(2, "a\nc\n")
+
+	j = newJob(3, "a\nc\n")
+	sections, footer, isDiff = j.diffResponse()
+	if !isDiff {
+		t.Error("unchanged run isDiff = false, want true")
+	}
+	if len(sections) != 0 {
+		t.Errorf("unchanged run sections = %q, want none", sections)
+	}
+	if want := "🟰 No changes since job 2\\.\nfooter"; footer != want {
+		t.Errorf("unchanged run footer = %q, want %q", footer, want)
+	}
+
+	j = newJob(4, "a\nd\n")
+	sections, footer, isDiff = j.diffResponse()
+	if !isDiff {
+		t.Error("changed run isDiff = false, want true")
+	}
+	if len(sections) != 1 || sections[0].Label != "changes since job 3" || string(sections[0].Output) != "@@ -1,2 +1,2 @@\n a\n-c\n+d\n" {
+		t.Errorf("changed run sections = %q, want the diff against job 3", sections)
+	}
+	if footer != "footer" {
+		t.Errorf("changed run footer = %q, want %q", footer, "footer")
+	}
+
+	// Other command lines have their own baselines.
+	command.Args = []string{"-i"}
+	if _, _, isDiff = newJob(5, "a\nd\n").diffResponse(); isDiff {
+		t.Error("run with other arguments isDiff = true, want false")
+	}
+}
@@ -0,0 +1,407 @@
+package rcebot
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/database64128/cubic-rce-bot/osuser"
+)
+
+// execHelperEnvKey is the environment variable that carries the [execHelperSpec] to the exec helper.
+const execHelperEnvKey = "CUBIC_RCE_BOT_EXEC_HELPER"
+
+// execHelperArg0 is the argv[0] of the exec helper process.
+const execHelperArg0 = "cubic-rce-bot-exec-helper"
+
+// execHelperSpec specifies the process attributes that the exec helper applies to itself
+// before replacing itself with the command.
+//
+// These are attributes that cannot be set on a child process via [os/exec],
+// and cannot be safely changed in the multi-threaded bot process.
+type execHelperSpec struct {
+	// Umask is the optional file mode creation mask.
+	Umask *int `json:"umask,omitzero"`
+
+	// Rlimits is the optional list of resource limits.
+	Rlimits []execHelperRlimit `json:"rlimits,omitzero"`
+
+	// Sandbox is the optional sandbox profile.
+	Sandbox *SandboxProfile `json:"sandbox,omitzero"`
+
+	// Probe makes the exec helper exit successfully after applying the spec, instead of executing the command.
+	Probe bool `json:"probe,omitzero"`
+}
+
+// execHelperRlimit is a resource limit applied by the exec helper.
+type execHelperRlimit struct {
+	Resource int    `json:"resource"`
+	Cur      uint64 `json:"cur"`
+	Max      uint64 `json:"max"`
+}
+
+// isZero returns whether the spec requires no changes, in which case the exec helper is not needed.
+func (s *execHelperSpec) isZero() bool {
+	return s.Umask == nil && len(s.Rlimits) == 0 && s.Sandbox == nil
+}
+
+// execHelperSpec returns the exec helper spec for the command.
+func (c *Command) execHelperSpec() execHelperSpec {
+	var spec execHelperSpec
+	if c.Umask != nil {
+		umask := int(c.Umask.Value().Perm())
+		spec.Umask = &umask
+	}
+	spec.Rlimits = c.rlimits
+	spec.Sandbox = c.sandbox
+	return spec
+}
+
+// credential is the resolved user and group identity a command runs as.
+type credential struct {
+	uid         uint32
+	gid         uint32
+	groups      []uint32
+	noSetGroups bool
+}
+
+// initCredential resolves the user and groups the command runs as, and checks that the bot has
+// the privilege to switch to them.
+func (c *Command) initCredential() error {
+	c.credential = nil
+
+	if !c.RunAsUser.IsValid() && !c.RunAsGroup.IsValid() && c.SupplementaryGroups == nil {
+		return nil
+	}
+
+	if !credentialSupported {
+		return fmt.Errorf("running commands as a different user or group is not supported on %s", runtime.GOOS)
+	}
+
+	cred := credential{
+		uid: uint32(os.Getuid()),
+		gid: uint32(os.Getgid()),
+	}
+
+	var (
+		u   *user.User
+		err error
+	)
+	if c.RunAsUser.IsValid() {
+		if c.RunAsUser.IsInt() {
+			if cred.uid, err = credentialID("user", c.RunAsUser.Int()); err != nil {
+				return err
+			}
+		}
+
+		u, err = osuser.LookupUser(c.RunAsUser)
+		if err != nil {
+			return err
+		}
+
+		if u != nil {
+			uid, err := osuser.ParseID(u.Uid)
+			if err != nil {
+				return err
+			}
+			if cred.uid, err = credentialID("user", uid); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch {
+	case c.RunAsGroup.IsValid():
+		gid, err := osuser.LookupGID(c.RunAsGroup)
+		if err != nil {
+			return err
+		}
+		if cred.gid, err = credentialID("group", gid); err != nil {
+			return err
+		}
+
+	case u != nil:
+		gid, err := osuser.ParseID(u.Gid)
+		if err != nil {
+			return err
+		}
+		if cred.gid, err = credentialID("group", gid); err != nil {
+			return err
+		}
+
+	case c.RunAsUser.IsValid():
+		return fmt.Errorf("user ID %d has no user account, runAsGroup must be specified", c.RunAsUser.Int())
+	}
+
+	switch {
+	case c.SupplementaryGroups != nil:
+		cred.groups = make([]uint32, len(c.SupplementaryGroups))
+		for i, group := range c.SupplementaryGroups {
+			gid, err := osuser.LookupGID(group)
+			if err != nil {
+				return err
+			}
+			if cred.groups[i], err = credentialID("group", gid); err != nil {
+				return err
+			}
+		}
+
+	case u != nil:
+		gids, err := u.GroupIds()
+		if err != nil {
+			return fmt.Errorf("failed to lookup groups of user %q: %w", u.Username, err)
+		}
+		cred.groups = make([]uint32, len(gids))
+		for i, s := range gids {
+			gid, err := osuser.ParseID(s)
+			if err != nil {
+				return err
+			}
+			if cred.groups[i], err = credentialID("group", gid); err != nil {
+				return err
+			}
+		}
+
+	case c.RunAsUser.IsValid():
+		// Do not leak the bot's supplementary groups to a user without an account.
+		cred.groups = []uint32{}
+
+	default:
+		// Only the primary group is changed. Keep the bot's supplementary groups.
+		cred.noSetGroups = true
+	}
+
+	if err := checkCredentialPrivilege(&cred); err != nil {
+		return err
+	}
+
+	c.credential = &cred
+	return nil
+}
+
+// credentialID returns id as a user or group ID of the given kind,
+// or an error if it is outside [0, math.MaxUint32). The ID math.MaxUint32 is (uid_t)-1,
+// which set*id(2) calls treat as leaving the ID unchanged.
+func credentialID(kind string, id int) (uint32, error) {
+	if id < 0 || uint64(id) >= math.MaxUint32 {
+		return 0, fmt.Errorf("%s ID %d is out of range [0, %d)", kind, id, uint64(math.MaxUint32))
+	}
+	return uint32(id), nil
+}
+
+// environ returns the environment of the command, or nil to inherit the bot's environment.
+func (c *Command) environ() []string {
+	if c.InheritEnv == nil && len(c.Env) == 0 {
+		return nil
+	}
+
+	var env []string
+	if c.InheritEnv == nil {
+		env = os.Environ()
+	} else {
+		env = make([]string, 0, len(c.InheritEnv)+len(c.Env))
+		for _, key := range c.InheritEnv {
+			if value, ok := os.LookupEnv(key); ok {
+				env = append(env, key+"="+value)
+			}
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(c.Env)) {
+		env = append(env, key+"="+c.Env[key])
+	}
+	return env
+}
+
+// mkdirTemp creates a new private temporary directory owned by the user the command runs as,
+// and returns its path and a function that removes it.
+func (c *Command) mkdirTemp(pattern string) (dir string, remove func(), err error) {
+	dir, err = os.MkdirTemp("", pattern)
+	if err != nil {
+		return "", nil, err
+	}
+	remove = func() {
+		_ = os.RemoveAll(dir)
+	}
+
+	if c.credential != nil {
+		if err = os.Chown(dir, int(c.credential.uid), int(c.credential.gid)); err != nil {
+			remove()
+			return "", nil, fmt.Errorf("failed to change owner of %q: %w", dir, err)
+		}
+	}
+
+	return dir, remove, nil
+}
+
+// tempDirHidden returns whether the temporary directory is hidden from the command by the private /tmp of its sandbox.
+func (c *Command) tempDirHidden() bool {
+	return c.sandbox != nil && c.sandbox.PrivateTmp && strings.HasPrefix(filepath.Clean(os.TempDir())+"/", "/tmp/")
+}
+
+// newCmd returns a new [*exec.Cmd] that runs the command with the given arguments.
+// Use [Command.run] to run it.
+//
+// scratchDir is the optional scratch directory of the run, passed to the command in [ScratchDirEnv].
+//
+// If the command's sandbox restricts file system access with Landlock, the command may also read its script file,
+// and create, modify and remove files beneath the scratch directory and its upload directory.
+//
+// The caller must call cleanup after the command exits, to remove any files created for it.
+func (c *Command) newCmd(args []string, scratchDir string) (cmd *exec.Cmd, cleanup func(), err error) {
+	cleanup = func() {}
+
+	var readOnlyPaths, writablePaths []string
+	if scratchDir != "" {
+		writablePaths = append(writablePaths, scratchDir)
+	}
+
+	if c.Script == "" {
+		cmd = exec.Command(c.Name, args...)
+	} else {
+		interpreter := strings.Fields(c.Interpreter)
+		argv := interpreter[1:len(interpreter):len(interpreter)]
+		switch c.ScriptMode {
+		case ScriptModeFile:
+			var path string
+			path, cleanup, err = c.writeScriptFile()
+			if err != nil {
+				return nil, nil, err
+			}
+			argv = append(argv, path)
+			readOnlyPaths = append(readOnlyPaths, path)
+		}
+		cmd = exec.Command(interpreter[0], append(argv, args...)...)
+		if c.ScriptMode == ScriptModeStdin {
+			cmd.Stdin = strings.NewReader(c.Script)
+		}
+	}
+
+	cmd.Dir = c.Dir
+	cmd.Env = c.environ()
+	if scratchDir != "" {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, ScratchDirEnv+"="+scratchDir)
+	}
+	setSysProcAttr(cmd, c)
+
+	// Bound the wait for I/O to complete after the command exits,
+	// in case a descendant that escaped its process group holds the pipes open.
+	for _, step := range c.stopLadder() {
+		cmd.WaitDelay += step.Wait.Value()
+	}
+
+	// Leave lookup errors to be returned by cmd.Start.
+	if cmd.Err != nil {
+		return cmd, cleanup, nil
+	}
+
+	// The upload directory only exists once a file has been uploaded.
+	if c.UploadDir != "" {
+		if _, err := os.Stat(c.UploadDir); err == nil {
+			writablePaths = append(writablePaths, c.UploadDir)
+		}
+	}
+
+	if spec := c.execHelperSpec(); !spec.isZero() {
+		if spec.Sandbox != nil {
+			spec.Sandbox = spec.Sandbox.withPaths(readOnlyPaths, writablePaths)
+		}
+		if err := useExecHelper(cmd, &spec); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+	}
+
+	return cmd, cleanup, nil
+}
+
+// useExecHelper rewrites cmd to start the exec helper, which applies spec and then executes the original command.
+func useExecHelper(cmd *exec.Cmd, spec *execHelperSpec) error {
+	specJSON, err := json.Marshal(spec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal exec helper spec: %w", err)
+	}
+
+	exe := "/proc/self/exe"
+	if runtime.GOOS != "linux" {
+		exe, err = os.Executable()
+		if err != nil {
+			return fmt.Errorf("failed to get executable path: %w", err)
+		}
+	}
+
+	// The helper runs in cmd.Dir, so resolve a relative path against it, as [exec.Cmd] does,
+	// and pass it as an absolute path.
+	path := cmd.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cmd.Dir, path)
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path of %q: %w", cmd.Path, err)
+	}
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+
+	cmd.Args = append([]string{execHelperArg0, path}, cmd.Args...)
+	cmd.Path = exe
+	cmd.Env = append(env, execHelperEnvKey+"="+string(specJSON))
+	return nil
+}
+
+// MaybeRunExecHelper checks whether the current process was started as an exec helper.
+// If so, it applies the requested process attributes and replaces the process with the command,
+// and never returns. Otherwise, it returns immediately.
+//
+// It must be called at the beginning of main, before any other initialization.
+func MaybeRunExecHelper() {
+	specJSON, ok := os.LookupEnv(execHelperEnvKey)
+	if !ok || len(os.Args) < 3 || os.Args[0] != execHelperArg0 {
+		return
+	}
+
+	if err := runExecHelper(specJSON, os.Args[1], os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", execHelperArg0, err)
+		os.Exit(127)
+	}
+
+	// Only a probe returns without error.
+	os.Exit(0)
+}
+
+// runExecHelper applies the spec and replaces the process with the command at path.
+// It only returns on error, or after applying the spec of a probe.
+func runExecHelper(specJSON, path string, argv []string) error {
+	var spec execHelperSpec
+	if err := json.Unmarshal([]byte(specJSON), &spec); err != nil {
+		return fmt.Errorf("failed to unmarshal spec: %w", err)
+	}
+
+	if err := os.Unsetenv(execHelperEnvKey); err != nil {
+		return fmt.Errorf("failed to unset %s: %w", execHelperEnvKey, err)
+	}
+
+	if err := applyExecHelperSpec(&spec); err != nil {
+		return err
+	}
+
+	if spec.Probe {
+		return nil
+	}
+
+	return execve(path, argv, os.Environ())
+}
@@ -0,0 +1,48 @@
+package rcebot
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strconv"
+)
+
+const (
+	capSetgid = 6
+	capSetuid = 7
+)
+
+// hasEffectiveCapability returns whether the bot process has the capability in its effective set.
+func hasEffectiveCapability(capability uint) bool {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return os.Geteuid() == 0
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Bytes()
+		if value, ok := bytes.CutPrefix(line, []byte("CapEff:")); ok {
+			caps, err := strconv.ParseUint(string(bytes.TrimSpace(value)), 16, 64)
+			if err != nil {
+				return false
+			}
+			return caps&(1<<capability) != 0
+		}
+	}
+	return false
+}
+
+// maxRSSUnit returns the unit of [syscall.Rusage.Maxrss] in bytes.
+func maxRSSUnit() int64 {
+	return 1024
+}
+
+func hasSetuidPrivilege() bool {
+	return hasEffectiveCapability(capSetuid)
+}
+
+func hasSetgidPrivilege() bool {
+	return hasEffectiveCapability(capSetgid)
+}
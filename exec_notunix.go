@@ -0,0 +1,45 @@
+//go:build !unix
+
+package rcebot
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+const (
+	execHelperSupported = false
+	credentialSupported = false
+)
+
+func setSysProcAttr(_ *exec.Cmd, _ *Command) {}
+
+// signalProcessGroup sends sig to p. Process groups are not supported on this platform.
+func signalProcessGroup(p *os.Process, sig syscall.Signal) error {
+	if sig == syscall.SIGKILL {
+		return p.Kill()
+	}
+	return p.Signal(sig)
+}
+
+func processSignal(_ *os.ProcessState) Signal {
+	return 0
+}
+
+func processMaxRSS(_ *os.ProcessState) int64 {
+	return 0
+}
+
+func checkCredentialPrivilege(_ *credential) error {
+	return errors.ErrUnsupported
+}
+
+func applyExecHelperSpec(_ *execHelperSpec) error {
+	return errors.ErrUnsupported
+}
+
+func execve(_ string, _, _ []string) error {
+	return errors.ErrUnsupported
+}
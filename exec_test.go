@@ -0,0 +1,45 @@
+package rcebot
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestUseExecHelperPath(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("os.Getwd() = %v", err)
+	}
+
+	for _, c := range [...]struct {
+		name string
+		path string
+		dir  string
+		want string
+	}{
+		{"Absolute", "/usr/bin/tool", "/srv/app", "/usr/bin/tool"},
+		{"RelativeToDir", "./bin/tool", "/srv/app", "/srv/app/bin/tool"},
+		{"RelativeToRelativeDir", "bin/tool", "app", filepath.Join(wd, "app/bin/tool")},
+		{"RelativeWithoutDir", "./bin/tool", "", filepath.Join(wd, "bin/tool")},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			cmd := exec.Command(c.path, "arg")
+			cmd.Dir = c.dir
+			umask := 0o027
+			if err := useExecHelper(cmd, &execHelperSpec{Umask: &umask}); err != nil {
+				t.Fatalf("useExecHelper() = %v", err)
+			}
+			if len(cmd.Args) != 4 {
+				t.Fatalf("cmd.Args = %q, want 4 arguments", cmd.Args)
+			}
+			if got := cmd.Args[1]; got != c.want {
+				t.Errorf("command path = %q, want %q", got, c.want)
+			}
+			if got := cmd.Args[2:]; got[0] != c.path || got[1] != "arg" {
+				t.Errorf("command argv = %q, want [%q %q]", got, c.path, "arg")
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+//go:build unix
+
+package rcebot
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"syscall"
+)
+
+const (
+	execHelperSupported = true
+	credentialSupported = true
+)
+
+func init() {
+	signalNames["USR1"] = syscall.SIGUSR1
+	signalNames["USR2"] = syscall.SIGUSR2
+	signalNames["XCPU"] = syscall.SIGXCPU
+	signalNames["XFSZ"] = syscall.SIGXFSZ
+}
+
+// setSysProcAttr sets the platform-specific process attributes of the command.
+func setSysProcAttr(cmd *exec.Cmd, c *Command) {
+	// Start the command in its own process group, so that it can be stopped along with its descendants.
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+
+	if c.credential != nil {
+		cmd.SysProcAttr.Credential = &syscall.Credential{
+			Uid:         c.credential.uid,
+			Gid:         c.credential.gid,
+			Groups:      c.credential.groups,
+			NoSetGroups: c.credential.noSetGroups,
+		}
+	}
+
+	setSandboxSysProcAttr(cmd.SysProcAttr, c.sandbox)
+}
+
+// signalProcessGroup sends sig to the process group led by p.
+func signalProcessGroup(p *os.Process, sig syscall.Signal) error {
+	return syscall.Kill(-p.Pid, sig)
+}
+
+// processSignal returns the signal that killed the process, or 0 if it exited on its own.
+func processSignal(state *os.ProcessState) Signal {
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return Signal(ws.Signal())
+	}
+	return 0
+}
+
+// processMaxRSS returns the maximum resident set size of the process in bytes.
+func processMaxRSS(state *os.ProcessState) int64 {
+	if ru, ok := state.SysUsage().(*syscall.Rusage); ok {
+		return int64(ru.Maxrss) * maxRSSUnit()
+	}
+	return 0
+}
+
+// checkCredentialPrivilege returns an error if the bot lacks the privilege to run processes as cred.
+func checkCredentialPrivilege(cred *credential) error {
+	if cred.uid != uint32(os.Geteuid()) && !hasSetuidPrivilege() {
+		return fmt.Errorf("the bot lacks the privilege to run commands as user ID %d", cred.uid)
+	}
+
+	needSetgid := cred.gid != uint32(os.Getegid())
+	if !needSetgid && !cred.noSetGroups {
+		groups, err := os.Getgroups()
+		if err != nil {
+			return fmt.Errorf("failed to get supplementary groups: %w", err)
+		}
+		needSetgid = !slices.Equal(slices.Sorted(slices.Values(cred.groups)), sortedGroups(groups))
+	}
+
+	if needSetgid && !hasSetgidPrivilege() {
+		return fmt.Errorf("the bot lacks the privilege to run commands as group ID %d with supplementary groups %v", cred.gid, cred.groups)
+	}
+
+	// setgroups(2) requires privilege even if the groups are unchanged.
+	if !needSetgid {
+		cred.noSetGroups = true
+	}
+
+	return nil
+}
+
+func sortedGroups(groups []int) []uint32 {
+	s := make([]uint32, len(groups))
+	for i, g := range groups {
+		s[i] = uint32(g)
+	}
+	slices.Sort(s)
+	return s
+}
+
+// applyExecHelperSpec applies the spec to the current process.
+func applyExecHelperSpec(spec *execHelperSpec) error {
+	if spec.Umask != nil {
+		_ = syscall.Umask(*spec.Umask)
+	}
+	if err := setRlimits(spec.Rlimits); err != nil {
+		return err
+	}
+	return applySandbox(spec.Sandbox)
+}
+
+// execve replaces the current process with the program at path.
+func execve(path string, argv, envv []string) error {
+	if err := syscall.Exec(path, argv, envv); err != nil {
+		return fmt.Errorf("failed to execute %q: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+//go:build unix && !linux
+
+package rcebot
+
+import (
+	"os"
+	"runtime"
+)
+
+func hasSetuidPrivilege() bool {
+	return os.Geteuid() == 0
+}
+
+func hasSetgidPrivilege() bool {
+	return os.Geteuid() == 0
+}
+
+// maxRSSUnit returns the unit of [syscall.Rusage.Maxrss] in bytes.
+func maxRSSUnit() int64 {
+	// Darwin reports bytes, while the BSDs report kilobytes.
+	if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		return 1
+	}
+	return 1024
+}
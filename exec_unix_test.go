@@ -0,0 +1,70 @@
+//go:build unix
+
+package rcebot
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/database64128/cubic-rce-bot/jsoncfg"
+)
+
+func TestUserCommandsByIDCredential(t *testing.T) {
+	// uid32 is 1<<32, built at run time so that the constant does not overflow int on 32-bit targets.
+	uid32 := uint64(1) << 32
+
+	for _, c := range [...]struct {
+		name     string
+		commands []Command
+		wantErr  string
+		need64   bool
+	}{
+		{
+			name:     "NegativeRunAsUser",
+			commands: []Command{{Name: "id", RunAsUser: jsoncfg.IntOrStringFromInt(-1), RunAsGroup: jsoncfg.IntOrStringFromInt(0)}},
+			wantErr:  "user ID -1 is out of range [0, 4294967295)",
+		},
+		{
+			name:     "RunAsUserOverRange",
+			commands: []Command{{Name: "id", RunAsUser: jsoncfg.IntOrStringFromInt(int(uid32)), RunAsGroup: jsoncfg.IntOrStringFromInt(0)}},
+			wantErr:  "user ID 4294967296 is out of range [0, 4294967295)",
+			need64:   true,
+		},
+		{
+			name:     "RunAsUserReserved",
+			commands: []Command{{Name: "id", RunAsUser: jsoncfg.IntOrStringFromInt(int(uid32 - 1)), RunAsGroup: jsoncfg.IntOrStringFromInt(0)}},
+			wantErr:  "user ID 4294967295 is out of range [0, 4294967295)",
+			need64:   true,
+		},
+		{
+			name:     "NegativeRunAsGroup",
+			commands: []Command{{Name: "id", RunAsGroup: jsoncfg.IntOrStringFromInt(-1)}},
+			wantErr:  "group ID -1 is out of range [0, 4294967295)",
+		},
+		{
+			name:     "NegativeSupplementaryGroup",
+			commands: []Command{{Name: "id", SupplementaryGroups: []jsoncfg.IntOrString{jsoncfg.IntOrStringFromInt(-2)}}},
+			wantErr:  "group ID -2 is out of range [0, 4294967295)",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if c.need64 && strconv.IntSize < 64 {
+				t.Skip("int cannot hold the ID on this platform")
+			}
+			config := Config{
+				Users: []User{{ID: 1, Commands: c.commands}},
+			}
+			_, err := config.UserCommandsByID()
+			if c.wantErr == "" {
+				if err != nil {
+					t.Errorf("UserCommandsByID() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("UserCommandsByID() error = %v, want %q", err, c.wantErr)
+			}
+		})
+	}
+}
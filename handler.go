@@ -1,10 +1,10 @@
 package rcebot
 
 import (
+	"bytes"
 	"context"
 	"log/slog"
 	"os"
-	"os/exec"
 	"strconv"
 	"strings"
 	"sync"
@@ -26,11 +26,31 @@ var Commands = []models.BotCommand{
 	},
 	{
 		Command:     "exec",
-		Description: "Execute an authorized command at the specified index",
+		Description: "Execute an authorized command at the specified index with optional name=value parameters",
+	},
+	{
+		Command:     "run",
+		Description: "Execute an authorized command in the background, like /exec, and get its result when it exits",
 	},
 	{
 		Command:     "cancel",
-		Description: "Cancel a running command at the specified index",
+		Description: "Cancel a running job by ID, or all runs of the command at the specified index",
+	},
+	{
+		Command:     "schedules",
+		Description: "List scheduled commands with their next run times",
+	},
+	{
+		Command:     "jobs",
+		Description: "List your running and recent jobs",
+	},
+	{
+		Command:     "status",
+		Description: "Show the status of a running or recent job by ID",
+	},
+	{
+		Command:     "output",
+		Description: "Re-send the full output of a recent job by ID",
 	},
 }
 
@@ -39,6 +59,14 @@ You can only execute commands authorized for your account in the configuration\.
 
 \- To see the list of commands you can execute, use ` + "`/list`" + `\.
 \- To execute a command, use ` + "`/exec <index>`" + `\.
+\- To supply parameters to a command, use ` + "`/exec <index> name=value ...`" + `\.
+\- To pipe input to a command that reads it, reply to a message or document with ` + "`/exec <index>`" + `, or send it as the caption of a document\.
+\- Commands that take a file are given documents the same way\.
+\- To execute a command in the background, use ` + "`/run <index>`" + ` with the same arguments\. The bot replies with the job ID right away, and with the result when the command exits\.
+\- Interactive commands start a session, into which your subsequent messages in the chat are typed as input lines\.
+\- To cancel a running job, use ` + "`/cancel <job ID>`" + `, or ` + "`/cancel all <index>`" + ` to cancel all runs of a command\.
+\- To see the scheduled commands and when they run next, use ` + "`/schedules`" + `\.
+\- To see your running and recent jobs, use ` + "`/jobs`" + `, then ` + "`/status <job ID>`" + ` or ` + "`/output <job ID>`" + ` for the status or full output of a job\.
 `
 
 // handleStart handles the `/start` command.
@@ -60,10 +88,21 @@ type Handler struct {
 	botUsername      string
 	logger           *tslog.Logger
 	wg               sync.WaitGroup
+	jobs             jobManager
+	scheduler        jobScheduler
+	schedules        scheduleManager
+	baselines        outputBaselines
+	uploads          uploadsInUse
 	userCommandsByID atomic.Pointer[map[int64][]Command]
+	adminIDs         atomic.Pointer[map[int64]struct{}]
 	handleList       func(ctx context.Context, b *bot.Bot, message *models.Message, cmdArg string) error
 	handleExec       func(ctx context.Context, b *bot.Bot, message *models.Message, cmdArg string) error
+	handleRun        func(ctx context.Context, b *bot.Bot, message *models.Message, cmdArg string) error
 	handleCancel     func(ctx context.Context, b *bot.Bot, message *models.Message, cmdArg string) error
+	handleSchedules  func(ctx context.Context, b *bot.Bot, message *models.Message, cmdArg string) error
+	handleJobs       func(ctx context.Context, b *bot.Bot, message *models.Message, cmdArg string) error
+	handleStatus     func(ctx context.Context, b *bot.Bot, message *models.Message, cmdArg string) error
+	handleOutput     func(ctx context.Context, b *bot.Bot, message *models.Message, cmdArg string) error
 }
 
 // NewHandler returns a new handler for bot commands.
@@ -71,10 +110,18 @@ func NewHandler(botUsername string, logger *tslog.Logger) *Handler {
 	h := Handler{
 		botUsername: botUsername,
 		logger:      logger,
+		schedules: scheduleManager{
+			changed: make(chan struct{}, 1),
+		},
 	}
 	h.handleList = requireUserCommands(&h.userCommandsByID, handleList)
-	h.handleExec = requireUserCommands(&h.userCommandsByID, requireCommandIndex(newExecHandler(&h.wg)))
-	h.handleCancel = requireUserCommands(&h.userCommandsByID, requireCommandIndex(handleCancel))
+	h.handleExec = requireUserCommands(&h.userCommandsByID, requireCommandIndex(newExecHandler(&h.wg, &h.jobs, &h.scheduler, &h.baselines, &h.uploads, false, logger)))
+	h.handleRun = requireUserCommands(&h.userCommandsByID, requireCommandIndex(newExecHandler(&h.wg, &h.jobs, &h.scheduler, &h.baselines, &h.uploads, true, logger)))
+	h.handleCancel = requireJobViewer(&h.userCommandsByID, &h.adminIDs, newCancelHandler(&h.jobs, &h.userCommandsByID))
+	h.handleSchedules = requireUserCommands(&h.userCommandsByID, newSchedulesHandler(&h.schedules))
+	h.handleJobs = requireJobViewer(&h.userCommandsByID, &h.adminIDs, newJobsHandler(&h.jobs))
+	h.handleStatus = requireJobViewer(&h.userCommandsByID, &h.adminIDs, requireJobID(&h.jobs, "/status", handleStatus))
+	h.handleOutput = requireJobViewer(&h.userCommandsByID, &h.adminIDs, requireJobID(&h.jobs, "/output", handleOutput))
 	return &h
 }
 
@@ -88,19 +135,58 @@ func (h *Handler) SetBotUsername(username string) {
 	h.botUsername = username
 }
 
+// SetMaxConcurrentJobs sets the maximum number of concurrently running jobs across all users.
+// Zero means no limit.
+func (h *Handler) SetMaxConcurrentJobs(n int) {
+	h.scheduler.setLimit(n)
+}
+
+// SetJobHistorySize sets the number of finished jobs kept in the history of each user.
+// Zero means [DefaultJobHistorySize].
+func (h *Handler) SetJobHistorySize(n int) {
+	h.jobs.setHistorySize(n)
+}
+
+// ReplaceAdminIDs replaces the set of IDs of the users who can view the jobs of all users.
+func (h *Handler) ReplaceAdminIDs(m map[int64]struct{}) {
+	h.adminIDs.Store(&m)
+}
+
 // ReplaceUserCommandsByID replaces the user commands map.
 func (h *Handler) ReplaceUserCommandsByID(m map[int64][]Command) {
 	h.userCommandsByID.Store(&m)
 }
 
+// ReplaceSchedules replaces the scheduled commands.
+// The next run time of each schedule is computed afresh from the current time.
+func (h *Handler) ReplaceSchedules(schedules []Schedule) {
+	h.schedules.replace(schedules)
+}
+
 // Handle processes a bot command update.
 func (h *Handler) Handle(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.CallbackQuery != nil {
+		h.handleCallbackQuery(ctx, b, update.CallbackQuery)
+		return
+	}
+
 	if update.Message == nil || update.Message.From == nil {
 		return
 	}
 
 	message := update.Message
-	botCmd := ParseBotCommand(message.Text)
+
+	// Commands sent along with a document are in the caption.
+	text := message.Text
+	if text == "" {
+		text = message.Caption
+	}
+	botCmd := ParseBotCommand(text)
+
+	if botCmd.Name == "" {
+		h.forwardSessionInput(ctx, b, message)
+		return
+	}
 
 	// Ignore commands meant for other bots.
 	if botCmd.Username != "" && botCmd.Username != h.botUsername {
@@ -114,7 +200,7 @@ func (h *Handler) Handle(ctx context.Context, b *bot.Bot, update *models.Update)
 			slog.String("fromFirstName", message.From.FirstName),
 			slog.String("fromUsername", message.From.Username),
 			slog.Int64("chatID", message.Chat.ID),
-			slog.String("text", message.Text),
+			slog.String("text", text),
 		)
 	}
 
@@ -126,8 +212,18 @@ func (h *Handler) Handle(ctx context.Context, b *bot.Bot, update *models.Update)
 		err = h.handleList(ctx, b, message, botCmd.Argument)
 	case "exec":
 		err = h.handleExec(ctx, b, message, botCmd.Argument)
+	case "run":
+		err = h.handleRun(ctx, b, message, botCmd.Argument)
 	case "cancel":
 		err = h.handleCancel(ctx, b, message, botCmd.Argument)
+	case "schedules":
+		err = h.handleSchedules(ctx, b, message, botCmd.Argument)
+	case "jobs":
+		err = h.handleJobs(ctx, b, message, botCmd.Argument)
+	case "status":
+		err = h.handleStatus(ctx, b, message, botCmd.Argument)
+	case "output":
+		err = h.handleOutput(ctx, b, message, botCmd.Argument)
 	default:
 		return
 	}
@@ -138,7 +234,7 @@ func (h *Handler) Handle(ctx context.Context, b *bot.Bot, update *models.Update)
 			slog.String("fromFirstName", message.From.FirstName),
 			slog.String("fromUsername", message.From.Username),
 			slog.Int64("chatID", message.Chat.ID),
-			slog.String("text", message.Text),
+			slog.String("text", text),
 			tslog.Err(err),
 		)
 		return
@@ -150,10 +246,149 @@ func (h *Handler) Handle(ctx context.Context, b *bot.Bot, update *models.Update)
 		slog.String("fromFirstName", message.From.FirstName),
 		slog.String("fromUsername", message.From.Username),
 		slog.Int64("chatID", message.Chat.ID),
-		slog.String("text", message.Text),
+		slog.String("text", text),
+	)
+}
+
+// forwardSessionInput types the text of a plain-text message into the user's interactive session in the chat, if any.
+func (h *Handler) forwardSessionInput(ctx context.Context, b *bot.Bot, message *models.Message) {
+	if message.Text == "" {
+		return
+	}
+
+	j := h.jobs.session(message.From.ID, message.Chat.ID)
+	if j == nil {
+		return
+	}
+
+	var err error
+	if session := j.session.Load(); session != nil {
+		err = session.writeInput(message.Text)
+	} else {
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:          message.Chat.ID,
+			MessageThreadID: message.MessageThreadID,
+			Text:            "Session " + j.idString() + " is not running.",
+			ReplyParameters: &models.ReplyParameters{
+				MessageID: message.ID,
+			},
+		})
+	}
+	if err != nil {
+		h.logger.Warn("Failed to forward session input",
+			slog.Int("id", message.ID),
+			slog.Int64("fromID", message.From.ID),
+			slog.Int64("chatID", message.Chat.ID),
+			slog.Uint64("jobID", j.id),
+			tslog.Err(err),
+		)
+		return
+	}
+
+	h.logger.Debug("Forwarded session input",
+		slog.Int("id", message.ID),
+		slog.Int64("fromID", message.From.ID),
+		slog.Int64("chatID", message.Chat.ID),
+		slog.Uint64("jobID", j.id),
+	)
+}
+
+// handleCallbackQuery processes a callback query from an inline keyboard button.
+//
+// The callback data is a bot command, of which `/cancel <job ID>` and `/output <job ID>` are currently supported.
+func (h *Handler) handleCallbackQuery(ctx context.Context, b *bot.Bot, query *models.CallbackQuery) {
+	botCmd := ParseBotCommand(query.Data)
+
+	var text string
+	switch botCmd.Name {
+	case "cancel":
+		text = h.cancelByCallback(query.From.ID, botCmd.Argument)
+	case "output":
+		text = h.outputByCallback(ctx, b, query, botCmd.Argument)
+	default:
+		text = "Unknown action."
+	}
+
+	if _, err := b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+		CallbackQueryID: query.ID,
+		Text:            text,
+	}); err != nil {
+		h.logger.Warn("Failed to handle callback query",
+			slog.String("id", query.ID),
+			slog.Int64("fromID", query.From.ID),
+			slog.String("fromFirstName", query.From.FirstName),
+			slog.String("fromUsername", query.From.Username),
+			slog.String("data", query.Data),
+			tslog.Err(err),
+		)
+		return
+	}
+
+	h.logger.Info("Handled callback query",
+		slog.String("id", query.ID),
+		slog.Int64("fromID", query.From.ID),
+		slog.String("fromFirstName", query.From.FirstName),
+		slog.String("fromUsername", query.From.Username),
+		slog.String("data", query.Data),
 	)
 }
 
+// cancelByCallback cancels the job with the ID in cmdArg on behalf of the user, if the user owns it or is an admin,
+// and returns the text to answer the callback query with.
+func (h *Handler) cancelByCallback(userID int64, cmdArg string) string {
+	id, err := strconv.ParseUint(cmdArg, 10, 64)
+	if err != nil {
+		return "Invalid job ID."
+	}
+
+	j := h.jobs.get(userID, id, h.isAdmin(userID))
+	if j == nil {
+		return "The job is not running."
+	}
+	j.cancel()
+	return "Stopping job " + j.idString() + "."
+}
+
+// isAdmin returns whether the user can view and cancel the jobs of all users.
+func (h *Handler) isAdmin(userID int64) bool {
+	adminIDs := h.adminIDs.Load()
+	if adminIDs == nil {
+		return false
+	}
+	_, admin := (*adminIDs)[userID]
+	return admin
+}
+
+// outputByCallback replies to the message of the callback query with the full output of the job
+// with the ID in cmdArg, if the user can view it, and returns the text to answer the callback query with.
+func (h *Handler) outputByCallback(ctx context.Context, b *bot.Bot, query *models.CallbackQuery, cmdArg string) string {
+	id, err := strconv.ParseUint(cmdArg, 10, 64)
+	if err != nil {
+		return "Invalid job ID."
+	}
+
+	message := query.Message.Message
+	if message == nil {
+		return "The message is too old."
+	}
+
+	j, finished := h.jobs.find(query.From.ID, id, h.isAdmin(query.From.ID))
+	if j == nil {
+		return "The job is no longer in your history."
+	}
+
+	if err := sendJobOutput(ctx, b, message, j, finished); err != nil {
+		h.logger.Warn("Failed to send job output",
+			slog.String("id", query.ID),
+			slog.Int64("fromID", query.From.ID),
+			slog.Uint64("jobID", id),
+			tslog.Err(err),
+		)
+		return "Failed to send the output."
+	}
+	return ""
+}
+
 // requireUserCommands is a middleware that adds the user's list of authorized commands to the arguments passed to
 // the next handler. It short-circuits the command handler if the user is not authorized to execute any commands.
 func requireUserCommands(
@@ -185,13 +420,64 @@ func handleList(ctx context.Context, b *bot.Bot, message *models.Message, _ stri
 		command := &commands[i]
 		sb.WriteString("\\[")
 		sb.WriteString(strconv.Itoa(i))
-		sb.WriteString("\\] `")
-		writeQuotedArg(&sb, command.Name)
-		for i := range command.Args {
-			sb.WriteByte(' ')
-			writeQuotedArg(&sb, command.Args[i])
+		sb.WriteString("\\] ")
+		writeCommandLine(&sb, command.Name, command.Args)
+		sb.WriteByte('\n')
+		if command.Description != "" {
+			sb.WriteString("    ")
+			sb.WriteString(EscapeMarkdownV2Plaintext(command.Description))
+			sb.WriteByte('\n')
+		}
+		if command.isPipeline() {
+			sb.WriteString("    pipeline: ")
+			sb.WriteString(EscapeMarkdownV2Plaintext(command.describePipeline()))
+			sb.WriteByte('\n')
+		}
+		if command.Script != "" {
+			sb.WriteString("    script: ")
+			sb.WriteString(EscapeMarkdownV2Plaintext(command.describeScript()))
+			sb.WriteByte('\n')
+		}
+		for j := range command.Params {
+			param := &command.Params[j]
+			sb.WriteString("    `")
+			sb.WriteString(param.Name)
+			sb.WriteString("`: ")
+			sb.WriteString(EscapeMarkdownV2Plaintext(param.Describe()))
+			sb.WriteByte('\n')
+		}
+		if len(command.Artifacts) > 0 {
+			sb.WriteString("    artifacts: ")
+			sb.WriteString(EscapeMarkdownV2Plaintext(strings.Join(command.Artifacts, ", ")))
+			sb.WriteByte('\n')
+		}
+		if command.UploadDir != "" {
+			sb.WriteString("    upload: ")
+			sb.WriteString(EscapeMarkdownV2Plaintext(command.describeUpload()))
+			sb.WriteByte('\n')
+		}
+		if command.Diff {
+			sb.WriteString("    shows changes since the previous run\n")
+		}
+		if command.Retry != nil {
+			sb.WriteString("    retry: ")
+			sb.WriteString(EscapeMarkdownV2Plaintext(command.Retry.describe()))
+			sb.WriteByte('\n')
+		}
+		if command.Detach {
+			sb.WriteString("    detached: ")
+			sb.WriteString(EscapeMarkdownV2Plaintext(command.describeNotify()))
+			sb.WriteByte('\n')
+		} else if command.Notify != NotifyPolicyAlways {
+			sb.WriteString("    with `/run`: ")
+			sb.WriteString(EscapeMarkdownV2Plaintext(command.describeNotify()))
+			sb.WriteByte('\n')
+		}
+		if command.Stdin != StdinModeNone {
+			sb.WriteString("    stdin: ")
+			sb.WriteString(EscapeMarkdownV2Plaintext(command.Stdin.Describe()))
+			sb.WriteByte('\n')
 		}
-		sb.WriteString("`\n")
 	}
 
 	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
@@ -206,6 +492,17 @@ func handleList(ctx context.Context, b *bot.Bot, message *models.Message, _ stri
 	return err
 }
 
+// writeCommandLine writes the command name and arguments to sb as MarkdownV2 inline code.
+func writeCommandLine(sb *strings.Builder, name string, args []string) {
+	sb.WriteByte('`')
+	writeQuotedArg(sb, name)
+	for _, arg := range args {
+		sb.WriteByte(' ')
+		writeQuotedArg(sb, arg)
+	}
+	sb.WriteByte('`')
+}
+
 func writeQuotedArg(sb *strings.Builder, arg string) {
 	needQuotes := strings.IndexByte(arg, ' ') != -1
 	if needQuotes {
@@ -217,13 +514,15 @@ func writeQuotedArg(sb *strings.Builder, arg string) {
 	}
 }
 
-// requireCommandIndex is a middleware that parses the bot command argument as a command index and adds it to
-// the arguments passed to the next handler. It short-circuits the command handler if the index is invalid.
+// requireCommandIndex is a middleware that parses the first field of the bot command argument as a command index
+// and adds it to the arguments passed to the next handler, along with the rest of the argument.
+// It short-circuits the command handler if the index is invalid.
 func requireCommandIndex(
-	next func(ctx context.Context, b *bot.Bot, message *models.Message, commands []Command, index int) error,
+	next func(ctx context.Context, b *bot.Bot, message *models.Message, commands []Command, index int, rest string) error,
 ) func(ctx context.Context, b *bot.Bot, message *models.Message, cmdArg string, commands []Command) error {
 	return func(ctx context.Context, b *bot.Bot, message *models.Message, cmdArg string, commands []Command) error {
-		index, err := strconv.Atoi(cmdArg)
+		indexStr, rest, _ := strings.Cut(cmdArg, " ")
+		index, err := strconv.Atoi(indexStr)
 		if err != nil || index < 0 {
 			_, err := b.SendMessage(ctx, &bot.SendMessageParams{
 				ChatID:          message.Chat.ID,
@@ -249,27 +548,35 @@ func requireCommandIndex(
 			return err
 		}
 
-		return next(ctx, b, message, commands, index)
+		return next(ctx, b, message, commands, index, strings.TrimSpace(rest))
 	}
 }
 
-// newExecHandler returns a new handler that handles the `/exec` command.
+// newExecHandler returns a new handler that handles the `/exec` command, or the `/run` command if detach is true.
+//
+// Detached executions, and executions of commands with [Command.Detach], are replied to with the job ID
+// right away, and run in the background without holding up the handler.
 func newExecHandler(
 	wg *sync.WaitGroup,
-) func(ctx context.Context, b *bot.Bot, message *models.Message, commands []Command, index int) error {
-	return func(ctx context.Context, b *bot.Bot, message *models.Message, commands []Command, index int) error {
+	jobs *jobManager,
+	scheduler *jobScheduler,
+	baselines *outputBaselines,
+	uploads *uploadsInUse,
+	detach bool,
+	logger *tslog.Logger,
+) func(ctx context.Context, b *bot.Bot, message *models.Message, commands []Command, index int, paramArg string) error {
+	return func(ctx context.Context, b *bot.Bot, message *models.Message, commands []Command, index int, paramArg string) error {
 		wg.Add(1)
 		defer wg.Done()
 
 		command := &commands[index]
-		execCtx, cancel := context.WithTimeout(ctx, command.ExecTimeout.Value())
-		defer cancel()
+		detach := detach || command.Detach
 
-		if !command.cancel.CompareAndSwap(nil, &cancel) {
+		if detach && command.Interactive {
 			_, err := b.SendMessage(ctx, &bot.SendMessageParams{
 				ChatID:          message.Chat.ID,
 				MessageThreadID: message.MessageThreadID,
-				Text:            "The command is already running\\. Use `/cancel " + strconv.Itoa(index) + "` to cancel it\\.",
+				Text:            "Interactive commands cannot run in the background\\. Use `/exec " + strconv.Itoa(index) + "` to start a session\\.",
 				ParseMode:       models.ParseModeMarkdown,
 				ReplyParameters: &models.ReplyParameters{
 					MessageID: message.ID,
@@ -277,59 +584,303 @@ func newExecHandler(
 			})
 			return err
 		}
-		defer command.cancel.Store(nil)
 
-		cmd := exec.CommandContext(execCtx, command.Name, command.Args...)
-		cmd.Stdout = &command.outputBuffer
-		cmd.Stderr = &command.outputBuffer
-		cmd.Cancel = func() error {
-			return cmd.Process.Signal(os.Interrupt)
+		params, err := resolveCommandParams(command, paramArg)
+		if err != nil {
+			_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID:          message.Chat.ID,
+				MessageThreadID: message.MessageThreadID,
+				Text:            "Invalid parameters: " + err.Error(),
+				ReplyParameters: &models.ReplyParameters{
+					MessageID: message.ID,
+				},
+			})
+			return err
 		}
-		cmd.WaitDelay = command.ExitTimeout.Value()
 
-		err := cmd.Run()
-		output := command.outputBuffer.Bytes()
-		command.outputBuffer.Reset()
+		stdin, err := command.readStdin(ctx, b, message)
+		if err != nil {
+			_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID:          message.Chat.ID,
+				MessageThreadID: message.MessageThreadID,
+				Text:            "Invalid input: " + err.Error(),
+				ReplyParameters: &models.ReplyParameters{
+					MessageID: message.ID,
+				},
+			})
+			return err
+		}
 
-		resp := command.responseBuilder.Build(output, err)
-		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		if !command.acquire() {
+			_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID:          message.Chat.ID,
+				MessageThreadID: message.MessageThreadID,
+				Text:            "The command is already running the maximum number of times\\. Use `/cancel all " + strconv.Itoa(index) + "` to cancel all runs\\.",
+				ParseMode:       models.ParseModeMarkdown,
+				ReplyParameters: &models.ReplyParameters{
+					MessageID: message.ID,
+				},
+			})
+			return err
+		}
+
+		uploadPath, err := command.saveUpload(ctx, b, message, uploads)
+		if err != nil {
+			command.release()
+			_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID:          message.Chat.ID,
+				MessageThreadID: message.MessageThreadID,
+				Text:            "Invalid upload: " + err.Error(),
+				ReplyParameters: &models.ReplyParameters{
+					MessageID: message.ID,
+				},
+			})
+			return err
+		}
+		if uploadPath != "" {
+			params[UploadPlaceholder] = uploadPath
+		}
+		args := command.expandArgs(params)
+
+		j := jobs.start(ctx, message.From.ID, message.Chat.ID, command, index, args, stdin)
+		done := func() {
+			jobs.remove(j)
+			if uploadPath != "" {
+				uploads.remove(uploadPath)
+				if command.UploadRetention == 0 {
+					_ = os.Remove(uploadPath)
+				}
+			}
+			command.release()
+		}
+
+		if !detach {
+			defer done()
+			return j.execute(ctx, b, message, scheduler, baselines, false, logger)
+		}
+
+		if _, err = b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID:          message.Chat.ID,
 			MessageThreadID: message.MessageThreadID,
-			Text:            resp,
-			ParseMode:       models.ParseModeMarkdown,
+			Text:            "Job " + j.idString() + " is running in the background, with the " + command.describeNotify() + ". Use /status " + j.idString() + " to check on it.",
 			ReplyParameters: &models.ReplyParameters{
 				MessageID: message.ID,
 			},
+			ReplyMarkup: newCancelButtonMarkup(j.id),
+		}); err != nil {
+			done()
+			return err
+		}
+
+		wg.Go(func() {
+			defer done()
+			if err := j.execute(ctx, b, message, scheduler, baselines, true, logger); err != nil {
+				logger.Warn("Failed to execute detached job",
+					slog.Int("id", message.ID),
+					slog.Int64("fromID", message.From.ID),
+					slog.Int64("chatID", message.Chat.ID),
+					slog.Uint64("jobID", j.id),
+					tslog.Err(err),
+				)
+			}
 		})
-		return err
+		return nil
 	}
 }
 
-// handleCancel handles the `/cancel` command.
-func handleCancel(ctx context.Context, b *bot.Bot, message *models.Message, commands []Command, index int) error {
-	command := &commands[index]
-	cancel := command.cancel.Load()
-	if cancel == nil {
-		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+// sendCommandResponse sends the response messages, followed by the optional document, in reply to message.
+// If message has no ID, they are sent to its chat and thread without replying.
+// If streamer is not nil, the first message replaces its progress message.
+// If replyMarkup is not nil, it is attached to the last message, unless that message replaces a progress message.
+func sendCommandResponse(
+	ctx context.Context,
+	b *bot.Bot,
+	message *models.Message,
+	streamer *outputStreamer,
+	messages []string,
+	documentName string,
+	document []byte,
+	replyMarkup models.ReplyMarkup,
+) error {
+	for i, text := range messages {
+		if i == 0 && streamer != nil {
+			if err := streamer.Finish(ctx, text); err != nil {
+				return err
+			}
+			continue
+		}
+
+		params := bot.SendMessageParams{
 			ChatID:          message.Chat.ID,
 			MessageThreadID: message.MessageThreadID,
-			Text:            "The command is not running\\. Use `/exec " + strconv.Itoa(index) + "` to execute it\\.",
+			Text:            text,
 			ParseMode:       models.ParseModeMarkdown,
+			ReplyParameters: replyParameters(message),
+		}
+		if i == len(messages)-1 {
+			params.ReplyMarkup = replyMarkup
+		}
+		if _, err := b.SendMessage(ctx, &params); err != nil {
+			return err
+		}
+	}
+
+	if document != nil {
+		if _, err := b.SendDocument(ctx, &bot.SendDocumentParams{
+			ChatID:          message.Chat.ID,
+			MessageThreadID: message.MessageThreadID,
+			Document: &models.InputFileUpload{
+				Filename: documentName,
+				Data:     bytes.NewReader(document),
+			},
+			ReplyParameters: replyParameters(message),
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// replyParameters returns the parameters to reply to message,
+// or nil if message is a placeholder for a chat without a message to reply to.
+func replyParameters(message *models.Message) *models.ReplyParameters {
+	if message.ID == 0 {
+		return nil
+	}
+	return &models.ReplyParameters{
+		MessageID: message.ID,
+	}
+}
+
+// newCancelButtonMarkup returns an inline keyboard with a button that cancels the job with the specified ID.
+func newCancelButtonMarkup(id uint64) *models.InlineKeyboardMarkup {
+	return newButtonMarkup("Cancel", "/cancel "+strconv.FormatUint(id, 10))
+}
+
+// newFullOutputButtonMarkup returns an inline keyboard with a button that sends the full output of the job with the specified ID.
+func newFullOutputButtonMarkup(id uint64) *models.InlineKeyboardMarkup {
+	return newButtonMarkup("Full output", "/output "+strconv.FormatUint(id, 10))
+}
+
+// newButtonMarkup returns an inline keyboard with a single button that sends the callback data.
+func newButtonMarkup(text, data string) *models.InlineKeyboardMarkup {
+	return &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{
+			{
+				{
+					Text:         text,
+					CallbackData: data,
+				},
+			},
+		},
+	}
+}
+
+// resolveCommandParams parses the parameter values in paramArg and resolves the values of all parameters.
+func resolveCommandParams(command *Command, paramArg string) (map[string]string, error) {
+	values, err := ParseParamValues(paramArg)
+	if err != nil {
+		return nil, err
+	}
+	return command.resolveParams(values)
+}
+
+// newCancelHandler returns a new handler that handles the `/cancel` command.
+//
+// `/cancel <job ID>` cancels a single job of the user, or of any user for admins,
+// and `/cancel all <index>` cancels all the user's runs of the command at index.
+func newCancelHandler(
+	jobs *jobManager,
+	userCommandsByID *atomic.Pointer[map[int64][]Command],
+) func(ctx context.Context, b *bot.Bot, message *models.Message, cmdArg string, admin bool) error {
+	cancelAll := requireUserCommands(userCommandsByID, requireCommandIndex(func(ctx context.Context, b *bot.Bot, message *models.Message, commands []Command, index int, _ string) error {
+		runningJobs := jobs.byCommand(message.From.ID, index, commands[index].Name)
+		if len(runningJobs) == 0 {
+			_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID:          message.Chat.ID,
+				MessageThreadID: message.MessageThreadID,
+				Text:            "The command is not running\\. Use `/exec " + strconv.Itoa(index) + "` to execute it\\.",
+				ParseMode:       models.ParseModeMarkdown,
+				ReplyParameters: &models.ReplyParameters{
+					MessageID: message.ID,
+				},
+			})
+			return err
+		}
+
+		for _, j := range runningJobs {
+			j.cancel()
+		}
+
+		var sb strings.Builder
+		for _, j := range runningJobs {
+			if err := j.wait(ctx); err != nil {
+				return err
+			}
+			sb.WriteString("Job ")
+			sb.WriteString(j.idString())
+			sb.WriteString(" has been canceled and ")
+			sb.WriteString(j.stopResult.String())
+			sb.WriteString(".\n")
+		}
+
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:          message.Chat.ID,
+			MessageThreadID: message.MessageThreadID,
+			Text:            sb.String(),
 			ReplyParameters: &models.ReplyParameters{
 				MessageID: message.ID,
 			},
 		})
 		return err
-	}
-	(*cancel)()
+	}))
 
-	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
-		ChatID:          message.Chat.ID,
-		MessageThreadID: message.MessageThreadID,
-		Text:            "The command has been canceled. You may need to wait up to " + command.ExitTimeout.Value().String() + " for it to be killed.",
-		ReplyParameters: &models.ReplyParameters{
-			MessageID: message.ID,
-		},
-	})
-	return err
+	return func(ctx context.Context, b *bot.Bot, message *models.Message, cmdArg string, admin bool) error {
+		if fields := strings.Fields(cmdArg); len(fields) > 0 && fields[0] == "all" {
+			return cancelAll(ctx, b, message, strings.Join(fields[1:], " "))
+		}
+
+		id, err := strconv.ParseUint(cmdArg, 10, 64)
+		if err != nil {
+			_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID:          message.Chat.ID,
+				MessageThreadID: message.MessageThreadID,
+				Text:            "Usage: `/cancel <job ID>` or `/cancel all <index>`\\.",
+				ParseMode:       models.ParseModeMarkdown,
+				ReplyParameters: &models.ReplyParameters{
+					MessageID: message.ID,
+				},
+			})
+			return err
+		}
+
+		j := jobs.get(message.From.ID, id, admin)
+		if j == nil {
+			_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID:          message.Chat.ID,
+				MessageThreadID: message.MessageThreadID,
+				Text:            "No running job with ID " + cmdArg + ".",
+				ReplyParameters: &models.ReplyParameters{
+					MessageID: message.ID,
+				},
+			})
+			return err
+		}
+		j.cancel()
+
+		if err := j.wait(ctx); err != nil {
+			return err
+		}
+
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:          message.Chat.ID,
+			MessageThreadID: message.MessageThreadID,
+			Text:            "Job " + j.idString() + " has been canceled and " + j.stopResult.String() + ".",
+			ReplyParameters: &models.ReplyParameters{
+				MessageID: message.ID,
+			},
+		})
+		return err
+	}
 }
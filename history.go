@@ -0,0 +1,273 @@
+package rcebot
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// jobTimeLayout is the layout of the start and end times of jobs in `/jobs` and `/status`.
+const jobTimeLayout = "2006-01-02 15:04:05 MST"
+
+// requireJobViewer is a middleware that adds whether the user is an admin to the arguments passed to the next handler.
+// It short-circuits the command handler if the user is neither authorized to execute any commands nor an admin.
+func requireJobViewer(
+	userCommandsByID *atomic.Pointer[map[int64][]Command],
+	adminIDs *atomic.Pointer[map[int64]struct{}],
+	next func(ctx context.Context, b *bot.Bot, message *models.Message, cmdArg string, admin bool) error,
+) func(ctx context.Context, b *bot.Bot, message *models.Message, cmdArg string) error {
+	return func(ctx context.Context, b *bot.Bot, message *models.Message, cmdArg string) error {
+		var admin bool
+		if adminIDs := adminIDs.Load(); adminIDs != nil {
+			_, admin = (*adminIDs)[message.From.ID]
+		}
+		if !admin && len((*userCommandsByID.Load())[message.From.ID]) == 0 {
+			_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID:          message.Chat.ID,
+				MessageThreadID: message.MessageThreadID,
+				Text:            "You are not authorized to execute any commands.",
+				ReplyParameters: &models.ReplyParameters{
+					MessageID: message.ID,
+				},
+			})
+			return err
+		}
+		return next(ctx, b, message, cmdArg, admin)
+	}
+}
+
+// newJobsHandler returns a new handler that handles the `/jobs` command.
+//
+// It lists the user's running jobs and finished jobs in the history, or those of all users for admins.
+func newJobsHandler(
+	jobs *jobManager,
+) func(ctx context.Context, b *bot.Bot, message *models.Message, cmdArg string, admin bool) error {
+	return func(ctx context.Context, b *bot.Bot, message *models.Message, _ string, admin bool) error {
+		running, finished := jobs.list(message.From.ID, admin)
+
+		var sb strings.Builder
+		if len(running) == 0 && len(finished) == 0 {
+			sb.WriteString("No running or recent jobs\\.")
+		}
+		if len(running) > 0 {
+			sb.WriteString("*Running*\n")
+			// Leave room for the finished jobs' header, and for the lines counting the jobs that do not fit.
+			writeJobLines(&sb, running, false, admin, MaxMessageLength-64)
+		}
+		if len(finished) > 0 {
+			if len(running) > 0 {
+				sb.WriteByte('\n')
+			}
+			sb.WriteString("*Recent*\n")
+			// Show the most recent jobs first, and leave out the oldest ones that do not fit.
+			slices.Reverse(finished)
+			writeJobLines(&sb, finished, true, admin, MaxMessageLength-32)
+		}
+
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:          message.Chat.ID,
+			MessageThreadID: message.MessageThreadID,
+			Text:            sb.String(),
+			ParseMode:       models.ParseModeMarkdown,
+			ReplyParameters: &models.ReplyParameters{
+				MessageID: message.ID,
+			},
+		})
+		return err
+	}
+}
+
+// writeJobLines writes the lines describing jobs to sb, as long as sb fits in budget,
+// followed by a line counting the jobs that do not fit, if any.
+func writeJobLines(sb *strings.Builder, jobs []*job, finished, withUser bool, budget int) {
+	for i, j := range jobs {
+		var line strings.Builder
+		writeJobLine(&line, j, finished, withUser)
+		if MessageLength(sb.String())+MessageLength(line.String()) > budget {
+			sb.WriteString("… and ")
+			sb.WriteString(strconv.Itoa(len(jobs) - i))
+			sb.WriteString(" more\n")
+			return
+		}
+		sb.WriteString(line.String())
+	}
+}
+
+// writeJobLine writes a MarkdownV2 line describing the job to sb.
+// If withUser is true, the ID of the user who owns the job is included.
+func writeJobLine(sb *strings.Builder, j *job, finished, withUser bool) {
+	switch {
+	case !finished && !j.started.Load():
+		sb.WriteString("⏳ ")
+	case !finished:
+		sb.WriteString("▶️ ")
+	case j.success:
+		sb.WriteString("✅ ")
+	default:
+		sb.WriteString("❌ ")
+	}
+	sb.WriteString("*\\#")
+	sb.WriteString(j.idString())
+	sb.WriteString("* ")
+	writeCommandLine(sb, j.command.Name, j.args)
+
+	var status string
+	switch {
+	case finished:
+		status = j.summary + ", ended " + j.endTime.Format(jobTimeLayout)
+	case j.started.Load():
+		status = "running, requested " + j.startTime.Format(jobTimeLayout)
+	default:
+		status = "queued, requested " + j.startTime.Format(jobTimeLayout)
+	}
+	if withUser {
+		status += ", " + jobOwner(j)
+	}
+	sb.WriteString("\n    ")
+	sb.WriteString(EscapeMarkdownV2Plaintext(status))
+	sb.WriteByte('\n')
+}
+
+// jobOwner returns a short description of who requested the job.
+func jobOwner(j *job) string {
+	switch {
+	case j.userID == 0:
+		return "scheduled"
+	case j.commandIndex < 0:
+		return "scheduled for user " + strconv.FormatInt(j.userID, 10)
+	default:
+		return "user " + strconv.FormatInt(j.userID, 10)
+	}
+}
+
+// requireJobID is a middleware that parses the bot command argument as a job ID, looks up the job,
+// and adds it to the arguments passed to the next handler, along with whether it has finished.
+// It short-circuits the command handler if the ID is invalid or the job is not found.
+func requireJobID(
+	jobs *jobManager,
+	usage string,
+	next func(ctx context.Context, b *bot.Bot, message *models.Message, j *job, finished, admin bool) error,
+) func(ctx context.Context, b *bot.Bot, message *models.Message, cmdArg string, admin bool) error {
+	return func(ctx context.Context, b *bot.Bot, message *models.Message, cmdArg string, admin bool) error {
+		id, err := strconv.ParseUint(strings.TrimPrefix(cmdArg, "#"), 10, 64)
+		if err != nil {
+			_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID:          message.Chat.ID,
+				MessageThreadID: message.MessageThreadID,
+				Text:            "Usage: `" + usage + " <job ID>`\\. Use `/jobs` to see your recent jobs\\.",
+				ParseMode:       models.ParseModeMarkdown,
+				ReplyParameters: &models.ReplyParameters{
+					MessageID: message.ID,
+				},
+			})
+			return err
+		}
+
+		j, finished := jobs.find(message.From.ID, id, admin)
+		if j == nil {
+			_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID:          message.Chat.ID,
+				MessageThreadID: message.MessageThreadID,
+				Text:            "No running or recent job with ID " + strconv.FormatUint(id, 10) + ".",
+				ReplyParameters: &models.ReplyParameters{
+					MessageID: message.ID,
+				},
+			})
+			return err
+		}
+
+		return next(ctx, b, message, j, finished, admin)
+	}
+}
+
+// handleStatus handles the `/status` command.
+func handleStatus(ctx context.Context, b *bot.Bot, message *models.Message, j *job, finished, admin bool) error {
+	var sb strings.Builder
+	sb.WriteString("Job ")
+	sb.WriteString(j.idString())
+	sb.WriteString(": ")
+	writeCommandLine(&sb, j.command.Name, j.args)
+	sb.WriteByte('\n')
+
+	var status strings.Builder
+	if admin {
+		status.WriteString("Requested by: ")
+		status.WriteString(jobOwner(j))
+		status.WriteByte('\n')
+	}
+	status.WriteString("Requested: ")
+	status.WriteString(j.startTime.Format(jobTimeLayout))
+	status.WriteByte('\n')
+	switch {
+	case finished:
+		status.WriteString("Ended: ")
+		status.WriteString(j.endTime.Format(jobTimeLayout))
+		status.WriteByte('\n')
+		// The footer reports the status in more detail.
+		if j.footer == "" {
+			status.WriteString("Status: ")
+			status.WriteString(j.summary)
+			status.WriteByte('\n')
+		}
+	case j.started.Load():
+		status.WriteString("Status: running\n")
+	default:
+		status.WriteString("Status: queued\n")
+	}
+	sb.WriteString(EscapeMarkdownV2Plaintext(status.String()))
+
+	if finished && j.footer != "" {
+		sb.WriteByte('\n')
+		sb.WriteString(j.footer)
+	}
+
+	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:          message.Chat.ID,
+		MessageThreadID: message.MessageThreadID,
+		Text:            sb.String(),
+		ParseMode:       models.ParseModeMarkdown,
+		ReplyParameters: &models.ReplyParameters{
+			MessageID: message.ID,
+		},
+	})
+	return err
+}
+
+// handleOutput handles the `/output` command.
+//
+// It re-sends the recorded output of a finished job as the job's original response,
+// following the overflow policy of its command.
+func handleOutput(ctx context.Context, b *bot.Bot, message *models.Message, j *job, finished, _ bool) error {
+	return sendJobOutput(ctx, b, message, j, finished)
+}
+
+// sendJobOutput replies to message with the full recorded output of the job, if it has finished.
+func sendJobOutput(ctx context.Context, b *bot.Bot, message *models.Message, j *job, finished bool) error {
+	if !finished || (len(j.sections) == 0 && j.footer == "") {
+		text := "Job " + j.idString() + " is still running."
+		if finished {
+			text = "Job " + j.idString() + " has no output: " + j.summary + "."
+		}
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:          message.Chat.ID,
+			MessageThreadID: message.MessageThreadID,
+			Text:            text,
+			ReplyParameters: &models.ReplyParameters{
+				MessageID: message.ID,
+			},
+		})
+		return err
+	}
+
+	var rb CommandOutputResponseBuilder
+	messages, documentName, document, err := j.buildResponse(&rb, j.sections, j.footer)
+	if err != nil {
+		return err
+	}
+	return sendCommandResponse(ctx, b, message, nil, messages, documentName, document, nil)
+}
@@ -0,0 +1,257 @@
+package rcebot
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestConfigAdminIDs(t *testing.T) {
+	config := Config{
+		Users: []User{
+			{ID: 1, Admin: true},
+			{ID: 2},
+			{ID: 3, Admin: true},
+		},
+	}
+	got := slices.Sorted(maps.Keys(config.AdminIDs()))
+	if want := []int64{1, 3}; !slices.Equal(got, want) {
+		t.Errorf("AdminIDs() = %v, want %v", got, want)
+	}
+}
+
+func TestConfigValidateJobHistorySize(t *testing.T) {
+	for _, c := range [...]struct {
+		name      string
+		size      int
+		expectErr bool
+	}{
+		{"Default", 0, false},
+		{"Positive", 50, false},
+		{"Negative", -1, true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			err := Config{JobHistorySize: c.size}.Validate()
+			if (err != nil) != c.expectErr {
+				t.Errorf("Validate() error = %v, expectErr %v", err, c.expectErr)
+			}
+		})
+	}
+}
+
+func TestJobManagerRemove(t *testing.T) {
+	var jobs jobManager
+	command := Command{Name: "true"}
+
+	unrecorded := jobs.start(t.Context(), 1, 2, &command, 0, nil, nil)
+	recorded := jobs.start(t.Context(), 1, 2, &command, 0, nil, nil)
+	recorded.record(nil, "", "exited with code 0", true)
+
+	for _, j := range [...]*job{unrecorded, recorded} {
+		jobs.remove(j)
+		if j.ctx.Err() == nil {
+			t.Errorf("job %d is not canceled after removal", j.id)
+		}
+		if jobs.get(1, j.id, false) != nil {
+			t.Errorf("job %d is still running after removal", j.id)
+		}
+	}
+
+	// A job removed without a recorded result did not run.
+	if unrecorded.summary != "did not run" || unrecorded.success || unrecorded.endTime.IsZero() {
+		t.Errorf("unrecorded job summary = %q, success = %v, end time = %v, want a job that did not run",
+			unrecorded.summary, unrecorded.success, unrecorded.endTime)
+	}
+	if recorded.summary != "exited with code 0" || !recorded.success {
+		t.Errorf("recorded job summary = %q, success = %v, want the recorded result", recorded.summary, recorded.success)
+	}
+
+	running, finished := jobs.list(1, false)
+	if len(running) != 0 {
+		t.Errorf("%d jobs are running, want 0", len(running))
+	}
+	if want := []*job{unrecorded, recorded}; !slices.Equal(finished, want) {
+		t.Errorf("history has %d jobs, want both removed jobs", len(finished))
+	}
+}
+
+func TestJobManagerTrimHistory(t *testing.T) {
+	var jobs jobManager
+	jobs.setHistorySize(3)
+	command := Command{Name: "true"}
+
+	run := func(userID int64) uint64 {
+		j := jobs.start(t.Context(), userID, 2, &command, 0, nil, nil)
+		jobs.remove(j)
+		return j.id
+	}
+	var ids []uint64
+	for range 5 {
+		ids = append(ids, run(1))
+	}
+	otherID := run(2)
+
+	historyIDs := func(userID int64) []uint64 {
+		_, finished := jobs.list(userID, false)
+		var ids []uint64
+		for _, j := range finished {
+			ids = append(ids, j.id)
+		}
+		return ids
+	}
+
+	// The oldest jobs are dropped from each user's own history.
+	if got, want := historyIDs(1), ids[2:]; !slices.Equal(got, want) {
+		t.Errorf("history = %v, want %v", got, want)
+	}
+	if got, want := historyIDs(2), []uint64{otherID}; !slices.Equal(got, want) {
+		t.Errorf("other user's history = %v, want %v", got, want)
+	}
+
+	// Shrinking the history size trims the existing histories.
+	jobs.setHistorySize(2)
+	if got, want := historyIDs(1), ids[3:]; !slices.Equal(got, want) {
+		t.Errorf("after shrinking, history = %v, want %v", got, want)
+	}
+	if got, want := historyIDs(2), []uint64{otherID}; !slices.Equal(got, want) {
+		t.Errorf("after shrinking, other user's history = %v, want %v", got, want)
+	}
+}
+
+func TestJobManagerFind(t *testing.T) {
+	var jobs jobManager
+	command := Command{Name: "true"}
+
+	done := jobs.start(t.Context(), 1, 2, &command, 0, nil, nil)
+	jobs.remove(done)
+	running := jobs.start(t.Context(), 1, 2, &command, 0, nil, nil)
+	defer jobs.remove(running)
+
+	for _, c := range [...]struct {
+		name         string
+		userID       int64
+		id           uint64
+		all          bool
+		want         *job
+		wantFinished bool
+	}{
+		{"OwnRunning", 1, running.id, false, running, false},
+		{"OwnFinished", 1, done.id, false, done, true},
+		{"OtherUserRunning", 3, running.id, false, nil, false},
+		{"OtherUserFinished", 3, done.id, false, nil, false},
+		{"AdminRunning", 3, running.id, true, running, false},
+		{"AdminFinished", 3, done.id, true, done, true},
+		{"Unknown", 1, running.id + 1, true, nil, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			j, finished := jobs.find(c.userID, c.id, c.all)
+			if j != c.want || finished != c.wantFinished {
+				t.Errorf("find(%d, %d, %v) = %p, %v, want %p, %v", c.userID, c.id, c.all, j, finished, c.want, c.wantFinished)
+			}
+		})
+	}
+}
+
+func TestJobsHandlerFitsInMessage(t *testing.T) {
+	b, api := newFakeBotAPI(t, func(req fakeBotAPIRequest) fakeBotAPIResponse {
+		return fakeBotAPIResponse{Result: models.Message{ID: 100}}
+	})
+
+	var jobs jobManager
+	command := Command{Name: "echo"}
+	args := []string{strings.Repeat("a", 100)}
+	for range 10 {
+		j := jobs.start(t.Context(), 1, 2, &command, 0, args, nil)
+		j.record(nil, "", "exited with code 0", true)
+		jobs.remove(j)
+	}
+	for range 100 {
+		j := jobs.start(t.Context(), 1, 2, &command, 0, args, nil)
+		defer jobs.remove(j)
+	}
+
+	message := &models.Message{ID: 1, Chat: models.Chat{ID: 2}, From: &models.User{ID: 1}}
+	if err := newJobsHandler(&jobs)(t.Context(), b, message, "", false); err != nil {
+		t.Fatalf("handle() = %v", err)
+	}
+
+	sends := api.Requests("sendMessage")
+	if len(sends) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sends))
+	}
+	text := sends[0].Form.Get("text")
+	if n := MessageLength(text); n > MaxMessageLength {
+		t.Errorf("message length = %d, want at most %d", n, MaxMessageLength)
+	}
+
+	running, recent, ok := strings.Cut(text, "\n\n*Recent*\n")
+	if !ok {
+		t.Fatalf("message %q has no recent jobs", text)
+	}
+	listed := strings.Count(running, "⏳")
+	if listed == 0 || listed == 100 {
+		t.Fatalf("listed %d of 100 running jobs, want some of them", listed)
+	}
+	if want := "… and " + strconv.Itoa(100-listed) + " more"; !strings.HasSuffix(running, want) {
+		t.Errorf("running jobs end with %q, want %q", running[max(0, len(running)-40):], want)
+	}
+	if want := "… and 10 more\n"; recent != want {
+		t.Errorf("recent jobs = %q, want %q", recent, want)
+	}
+}
+
+func TestHandleOutputOverflow(t *testing.T) {
+	output := strings.Repeat("0123456789abcdef\n", 500)
+
+	for _, c := range [...]struct {
+		name          string
+		policy        OverflowPolicy
+		wantMessages  int
+		wantDocuments int
+	}{
+		{"Truncate", OverflowPolicyTruncate, 1, 0},
+		{"Split", OverflowPolicySplit, 3, 0},
+		{"Document", OverflowPolicyDocument, 1, 1},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			b, api := newFakeBotAPI(t, func(req fakeBotAPIRequest) fakeBotAPIResponse {
+				return fakeBotAPIResponse{Result: models.Message{ID: 100}}
+			})
+
+			var jobs jobManager
+			command := Command{Name: "dump", Overflow: c.policy}
+			j := jobs.start(t.Context(), 1, 2, &command, 0, nil, nil)
+			j.record([]OutputSection{{Output: []byte(output)}}, "footer", "exited with code 0", true)
+			jobs.remove(j)
+
+			handle := requireJobID(&jobs, "/output", handleOutput)
+			message := &models.Message{ID: 1, Chat: models.Chat{ID: 2}, From: &models.User{ID: 1}}
+			if err := handle(t.Context(), b, message, j.idString(), false); err != nil {
+				t.Fatalf("handle() = %v", err)
+			}
+
+			sends := api.Requests("sendMessage")
+			if len(sends) != c.wantMessages {
+				t.Errorf("sent %d messages, want %d", len(sends), c.wantMessages)
+			}
+			for i, send := range sends {
+				if n := MessageLength(send.Form.Get("text")); n > MaxMessageLength {
+					t.Errorf("message %d length = %d, want at most %d", i, n, MaxMessageLength)
+				}
+			}
+			documents := api.Requests("sendDocument")
+			if len(documents) != c.wantDocuments {
+				t.Fatalf("sent %d documents, want %d", len(documents), c.wantDocuments)
+			}
+			if c.wantDocuments > 0 {
+				if got := string(documents[0].Files["document"]); got != output {
+					t.Errorf("document has %d bytes, want the %d bytes of the full output", len(got), len(output))
+				}
+			}
+		})
+	}
+}
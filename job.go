@@ -0,0 +1,674 @@
+package rcebot
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/database64128/cubic-rce-bot/tslog"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// job is a single execution of a command.
+type job struct {
+	id           uint64
+	userID       int64
+	chatID       int64
+	command      *Command
+	commandIndex int
+	args         []string
+	stdin        []byte
+	startTime    time.Time
+
+	ctx        context.Context
+	cancel     context.CancelFunc
+	done       chan struct{}
+	finishOnce sync.Once
+
+	// stopResult is only valid after done is closed.
+	stopResult StopResult
+
+	// session is the interactive session while the command is running on a pseudo-terminal.
+	session atomic.Pointer[ptySession]
+
+	// output is the command's standard output, or both streams in [OutputModeMerged].
+	output *OutputBuffer
+
+	// stderr is the command's standard error, or nil in [OutputModeMerged].
+	stderr *OutputBuffer
+
+	// started is set when the job leaves the queue and starts running.
+	started atomic.Bool
+
+	// runStartTime is when the job started running. It is only valid after started is set.
+	runStartTime time.Time
+
+	// The following fields record how the job ended, for its response and the job history.
+	// They are set by the owner of the job before it is removed from the running jobs, and are immutable afterwards.
+	endTime  time.Time
+	summary  string
+	success  bool
+	sections []OutputSection
+	footer   string
+
+	// attempts is the results of the attempts to run the job's command, including the current one.
+	// It only has more than one result when the command is retried.
+	attempts []CommandResult
+
+	// artifacts is the set of files collected from the command's scratch directory after it exits.
+	// The owner of the job must close it after uploading the artifacts.
+	artifacts artifactSet
+
+	// baseline is the output of the previous run of the same command line by the same user,
+	// for commands with [Command.Diff]. It is set along with the result, and is nil on the first run.
+	baseline *outputBaseline
+
+	responseBuilder CommandOutputResponseBuilder
+}
+
+// idString returns the job ID as a string.
+func (j *job) idString() string {
+	return strconv.FormatUint(j.id, 10)
+}
+
+// finish records the stop result and marks the job as done.
+// Subsequent calls have no effect.
+func (j *job) finish(result StopResult) {
+	j.finishOnce.Do(func() {
+		j.stopResult = result
+		close(j.done)
+	})
+}
+
+// wait waits for the job to finish or ctx to be done, whichever happens first.
+func (j *job) wait(ctx context.Context) error {
+	select {
+	case <-j.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// execute waits for the scheduler to allow the job to start, runs the job's command,
+// logs its result, and replies to message with the result.
+//
+// If detached is true, the job runs in the background, and the result is only sent
+// if the command's notify policy calls for it.
+func (j *job) execute(
+	ctx context.Context,
+	b *bot.Bot,
+	message *models.Message,
+	scheduler *jobScheduler,
+	baselines *outputBaselines,
+	detached bool,
+	logger *tslog.Logger,
+) error {
+	defer j.finish(StopResult{})
+
+	var queuedMessageID int
+	if err := scheduler.acquire(j.ctx, j.command.priority, func(position int) {
+		text := "Job " + j.idString() + " is queued at position " + strconv.Itoa(position) + "."
+		if queuedMessageID != 0 {
+			_, _ = b.EditMessageText(ctx, &bot.EditMessageTextParams{
+				ChatID:      message.Chat.ID,
+				MessageID:   queuedMessageID,
+				Text:        text,
+				ReplyMarkup: newCancelButtonMarkup(j.id),
+			})
+			return
+		}
+		sent, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:          message.Chat.ID,
+			MessageThreadID: message.MessageThreadID,
+			Text:            text,
+			ReplyParameters: &models.ReplyParameters{
+				MessageID: message.ID,
+			},
+			ReplyMarkup: newCancelButtonMarkup(j.id),
+		})
+		if err == nil {
+			queuedMessageID = sent.ID
+		}
+	}); err != nil {
+		// Being canceled while queued is not a failure. Tell the user instead of returning the error.
+		j.finish(StopResult{Unstarted: true})
+		j.record(nil, "", "canceled before it started", false)
+		text := "Job " + j.idString() + " was canceled before it started."
+		if queuedMessageID != 0 {
+			_, err = b.EditMessageText(ctx, &bot.EditMessageTextParams{
+				ChatID:    message.Chat.ID,
+				MessageID: queuedMessageID,
+				Text:      text,
+			})
+		} else {
+			_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID:          message.Chat.ID,
+				MessageThreadID: message.MessageThreadID,
+				Text:            text,
+				ReplyParameters: &models.ReplyParameters{
+					MessageID: message.ID,
+				},
+			})
+		}
+		return err
+	}
+	defer scheduler.release()
+	j.markStarted()
+
+	if queuedMessageID != 0 {
+		if _, err := b.EditMessageText(ctx, &bot.EditMessageTextParams{
+			ChatID:    message.Chat.ID,
+			MessageID: queuedMessageID,
+			Text:      "Job " + j.idString() + " has started.",
+		}); err != nil {
+			logger.Warn("Failed to update queued message",
+				slog.Uint64("jobID", j.id),
+				slog.String("command", j.command.Name),
+				tslog.Err(err),
+			)
+		}
+	}
+
+	defer j.artifacts.close()
+
+	var (
+		result   *CommandResult
+		streamer *outputStreamer
+	)
+	if j.command.isPipeline() {
+		j.runPipeline(ctx, logger)
+	} else {
+		var (
+			r   CommandResult
+			err error
+		)
+		r, streamer, err = j.runWithRetries(ctx, b, message, logger)
+		if err != nil {
+			j.recordError(err)
+			return err
+		}
+		j.recordResult(&r)
+		result = &r
+	}
+	j.recordBaseline(baselines)
+
+	// The artifacts of runs whose result is not sent are discarded along with the scratch directory.
+	if detached && !j.notifies() {
+		return nil
+	}
+
+	if err := j.sendResponse(ctx, b, message, streamer, "", result, message.From, logger); err != nil {
+		return err
+	}
+	return sendArtifacts(ctx, b, message, &j.artifacts)
+}
+
+// run runs the job's command and logs its result. The caller must have acquired a slot from the scheduler.
+//
+// If message is not nil, and the command streams its output or is interactive, a progress message is sent
+// in reply to message, and returned as a streamer for the caller to replace with the final response.
+// The job is finished when the command exits, unless it is going to be retried.
+func (j *job) run(ctx context.Context, b *bot.Bot, message *models.Message, logger *tslog.Logger) (CommandResult, *outputStreamer, error) {
+	// stopCtx is canceled with a cause when the bot stops the command on its own accord.
+	stopCtx, stop := context.WithCancelCause(j.ctx)
+	defer stop(nil)
+	runCtx, cancel := stopCtx, context.CancelFunc(func() {})
+	if timeout := j.command.ExecTimeout.Value(); timeout > 0 {
+		runCtx, cancel = context.WithTimeout(stopCtx, timeout)
+	}
+	defer cancel()
+
+	command := j.command
+
+	var scratchDir string
+	if len(command.Artifacts) > 0 {
+		var removeScratch func()
+		var err error
+		scratchDir, removeScratch, err = command.newScratchDir()
+		if err != nil {
+			return CommandResult{}, nil, err
+		}
+		j.artifacts.cleanups = append(j.artifacts.cleanups, removeScratch)
+	}
+
+	cmd, cleanup, err := command.newCmd(j.args, scratchDir)
+	if err != nil {
+		return CommandResult{}, nil, err
+	}
+	defer cleanup()
+	if j.stdin != nil {
+		cmd.Stdin = bytes.NewReader(j.stdin)
+	}
+
+	var limiter *outputLimiter
+	if maxOutputSize := command.MaxOutputSize; maxOutputSize > 0 {
+		limiter = &outputLimiter{
+			limit: maxOutputSize,
+			exceeded: func() {
+				stop(fmt.Errorf("%w of %d bytes", errOutputLimitExceeded, maxOutputSize))
+			},
+		}
+	}
+	stdout := command.wrapOutput(j.output, limiter)
+	cmd.Stdout = stdout
+	if j.stderr != nil {
+		cmd.Stderr = command.wrapOutput(j.stderr, limiter)
+	} else {
+		// Sharing the writer makes both streams share a single pipe, which preserves their order.
+		cmd.Stderr = stdout
+	}
+
+	var session *ptySession
+	if command.Interactive {
+		session, err = newPTYSession(cmd, command, stdout)
+		if err != nil {
+			return CommandResult{}, nil, fmt.Errorf("failed to allocate pty: %w", err)
+		}
+	}
+
+	var (
+		streamer   *outputStreamer
+		streamDone chan struct{}
+		streamWg   sync.WaitGroup
+	)
+	if message != nil && (command.Stream || command.Interactive) {
+		prefix := "Job " + j.idString() + ": "
+		var suffix string
+		if session != nil {
+			prefix = "Session " + j.idString() + ": "
+			suffix = "\nSend messages in this chat to type them into the session\\."
+		}
+		var sb strings.Builder
+		writeCommandLine(&sb, command.Name, j.args)
+		header := streamHeader(prefix, sb.String(), suffix)
+		streamer, err = newOutputStreamer(ctx, b, message, header, j.output, newCancelButtonMarkup(j.id))
+		if err != nil {
+			if session != nil {
+				session.close(0)
+			}
+			return CommandResult{}, nil, err
+		}
+		streamDone = make(chan struct{})
+		streamWg.Go(func() {
+			streamer.Run(ctx, command.StreamInterval.Value(), streamDone)
+		})
+	}
+
+	if session != nil {
+		j.session.Store(session)
+		go session.watchIdle(runCtx, command.IdleTimeout.Value(), stop)
+	}
+
+	startTime := time.Now()
+	stopResult, err := command.run(runCtx, cmd)
+	duration := time.Since(startTime)
+
+	if session != nil {
+		j.session.Store(nil)
+		session.close(cmd.WaitDelay)
+	}
+
+	result := newCommandResult(cmd.ProcessState, duration, stopResult, err)
+	result.Limit = command.limits.limitCause(&result)
+	if stopResult.Step > 0 {
+		reason := stopResult.String()
+		if cause := context.Cause(stopCtx); errors.Is(cause, errSessionIdle) || errors.Is(cause, errOutputLimitExceeded) {
+			reason = cause.Error() + ", " + reason
+		}
+		if result.Err == nil {
+			result.Err = errors.New(reason)
+		} else {
+			result.Err = fmt.Errorf("%w; %s", result.Err, reason)
+		}
+	}
+	if !j.willRetry(&result) {
+		j.finish(stopResult)
+	}
+
+	if streamer != nil {
+		close(streamDone)
+		streamWg.Wait()
+	}
+
+	if scratchDir != "" {
+		command.collectArtifacts(scratchDir, &j.artifacts)
+	}
+
+	logger.Info("Command exited", slices.Concat([]slog.Attr{
+		slog.Uint64("jobID", j.id),
+		slog.Int64("userID", j.userID),
+		slog.String("command", command.Name),
+		slog.Any("args", j.args),
+	}, result.LogAttrs())...)
+
+	return result, streamer, nil
+}
+
+// record records the sections of the job's output, the MarkdownV2 footer of its response,
+// and a one-line plain-text summary of how it ended.
+func (j *job) record(sections []OutputSection, footer, summary string, success bool) {
+	j.sections = sections
+	j.footer = footer
+	j.summary = summary
+	j.success = success
+	j.endTime = time.Now()
+}
+
+// recordResult records the output and result of the job's command, along with the status of each attempt.
+func (j *job) recordResult(result *CommandResult) {
+	summary := result.Status()
+	if result.Err != nil {
+		summary += " (" + result.Err.Error() + ")"
+	}
+	if len(j.attempts) > 1 {
+		summary += " after " + strconv.Itoa(len(j.attempts)) + " attempts"
+	}
+	j.record(j.outputSections(!result.Success()), attemptsFooter(j.attempts)+result.Footer(), summary, result.Success())
+}
+
+// recordError records the error that prevented the job's command from running.
+func (j *job) recordError(err error) {
+	j.record(nil, EscapeMarkdownV2Plaintext(err.Error()), "failed: "+err.Error(), false)
+}
+
+// responseContent returns the output sections and MarkdownV2 footer of the response to the job,
+// and the reply markup of the response's last message, if any.
+//
+// For commands with [Command.Diff], the response shows the diff against the output of the previous run,
+// with a button to show the full output.
+func (j *job) responseContent() (sections []OutputSection, footer string, replyMarkup models.ReplyMarkup) {
+	if !j.command.Diff {
+		return j.sections, j.footer, nil
+	}
+	sections, footer, isDiff := j.diffResponse()
+	if isDiff {
+		replyMarkup = newFullOutputButtonMarkup(j.id)
+	}
+	return sections, footer, replyMarkup
+}
+
+// sendResponse sends the response to the job in reply to message, along with the output document
+// if the overflow policy calls for one. If header is not empty, it is prepended to the response.
+//
+// If the command has a response template, and result is not nil, the response is built from the template
+// with the result and the requesting user, who is nil for scheduled commands.
+// Otherwise, or if the template fails, or Telegram rejects the response built from it,
+// for example because of invalid MarkdownV2, the default response is sent instead.
+func (j *job) sendResponse(
+	ctx context.Context,
+	b *bot.Bot,
+	message *models.Message,
+	streamer *outputStreamer,
+	header string,
+	result *CommandResult,
+	user *models.User,
+	logger *tslog.Logger,
+) error {
+	if messages, documentName, document, ok := j.buildTemplateResponse(result, user, logger); ok {
+		err := sendCommandResponse(ctx, b, message, streamer, prependHeader(header, messages), "", nil, nil)
+		if err == nil {
+			return sendCommandResponse(ctx, b, message, nil, nil, documentName, document, nil)
+		}
+		if !errors.Is(err, bot.ErrorBadRequest) {
+			return err
+		}
+		logger.Warn("Failed to send response from template, sending default response",
+			slog.Uint64("jobID", j.id),
+			slog.String("command", j.command.Name),
+			tslog.Err(err),
+		)
+	}
+
+	sections, footer, replyMarkup := j.responseContent()
+	messages, documentName, document, err := j.buildResponse(&j.responseBuilder, sections, footer)
+	if err != nil {
+		return err
+	}
+	return sendCommandResponse(ctx, b, message, streamer, prependHeader(header, messages), documentName, document, replyMarkup)
+}
+
+// prependHeader returns messages with header prepended to the first message,
+// or sent as a message of its own if they do not fit in one message together.
+func prependHeader(header string, messages []string) []string {
+	if header == "" {
+		return messages
+	}
+	if MessageLength(header)+MessageLength(messages[0]) <= MaxMessageLength {
+		return append([]string{header + messages[0]}, messages[1:]...)
+	}
+	return append([]string{header}, messages...)
+}
+
+// buildResponse builds the response messages for sections and footer with rb,
+// along with the output document if the overflow policy calls for one.
+func (j *job) buildResponse(rb *CommandOutputResponseBuilder, sections []OutputSection, footer string) (messages []string, documentName string, document []byte, err error) {
+	messages, attach := rb.buildSectionMessages(sections, footer, j.command.Overflow)
+	if attach {
+		documentName, document, err = outputDocument(sections, j.command.CompressDocument)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("failed to create output document: %w", err)
+		}
+	}
+	return messages, documentName, document, nil
+}
+
+// outputSections returns the sections of the job's output to render according to the command's output mode.
+func (j *job) outputSections(failed bool) []OutputSection {
+	if j.stderr == nil {
+		return []OutputSection{{Output: j.output.Bytes()}}
+	}
+
+	stdout := OutputSection{Label: "stdout", Output: j.output.Bytes()}
+	stderr := OutputSection{Label: "stderr", Output: j.stderr.Bytes()}
+	switch {
+	case len(stderr.Output) == 0:
+		stdout.Label = ""
+		return []OutputSection{stdout}
+	case j.command.OutputMode == OutputModeStdout && !failed:
+		stdout.Label = ""
+		return []OutputSection{stdout}
+	default:
+		return []OutputSection{stdout, stderr}
+	}
+}
+
+// newJob returns a new unregistered job for the user to run the command at index with args and stdin.
+// The job's context is derived from ctx, and is canceled when the job is canceled.
+func newJob(ctx context.Context, userID, chatID int64, command *Command, index int, args []string, stdin []byte) *job {
+	ctx, cancel := context.WithCancel(ctx)
+	j := &job{
+		userID:       userID,
+		chatID:       chatID,
+		command:      command,
+		commandIndex: index,
+		args:         args,
+		stdin:        stdin,
+		startTime:    time.Now(),
+		ctx:          ctx,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+	}
+	j.resetOutput()
+	return j
+}
+
+// resetOutput replaces the job's output buffers with empty ones.
+func (j *job) resetOutput() {
+	j.output = NewOutputBuffer(j.command.OutputHeadSize, j.command.OutputTailSize)
+	if j.command.OutputMode != OutputModeMerged {
+		j.stderr = NewOutputBuffer(j.command.OutputHeadSize, j.command.OutputTailSize)
+	}
+}
+
+// jobManager keeps track of running jobs, and of the recently finished jobs of each user.
+type jobManager struct {
+	mu          sync.Mutex
+	nextID      uint64
+	jobs        map[uint64]*job
+	history     map[int64][]*job
+	historySize int
+}
+
+// start creates and registers a new job for the user to run the command at index with args and stdin.
+// The index is -1 for commands not in the user's list, such as scheduled commands.
+// The job's context is derived from ctx, and is canceled when the job is canceled.
+//
+// The caller must call [jobManager.remove] after the job finishes.
+func (m *jobManager) start(ctx context.Context, userID, chatID int64, command *Command, index int, args []string, stdin []byte) *job {
+	j := newJob(ctx, userID, chatID, command, index, args, stdin)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.jobs == nil {
+		m.jobs = make(map[uint64]*job)
+	}
+	m.nextID++
+	j.id = m.nextID
+	m.jobs[j.id] = j
+	return j
+}
+
+// remove unregisters the job, releases its resources, and adds it to the history of its user.
+func (m *jobManager) remove(j *job) {
+	j.cancel()
+	if j.endTime.IsZero() {
+		j.record(nil, "", "did not run", false)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.jobs, j.id)
+
+	if m.history == nil {
+		m.history = make(map[int64][]*job)
+	}
+	m.history[j.userID] = m.trimHistory(append(m.history[j.userID], j))
+}
+
+// setHistorySize sets the number of finished jobs kept in the history of each user,
+// and trims the existing histories to the new size. Zero means [DefaultJobHistorySize].
+func (m *jobManager) setHistorySize(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.historySize = n
+	for userID, history := range m.history {
+		m.history[userID] = m.trimHistory(history)
+	}
+}
+
+// trimHistory removes the oldest jobs from history to fit the history size.
+// The caller must hold the lock.
+func (m *jobManager) trimHistory(history []*job) []*job {
+	historySize := m.historySize
+	if historySize == 0 {
+		historySize = DefaultJobHistorySize
+	}
+	if len(history) > historySize {
+		history = slices.Delete(history, 0, len(history)-historySize)
+	}
+	return history
+}
+
+// list returns the running and finished jobs of the user, or of all users if all is true,
+// ordered by job ID.
+func (m *jobManager) list(userID int64, all bool) (running, finished []*job) {
+	m.mu.Lock()
+	for _, j := range m.jobs {
+		if all || j.userID == userID {
+			running = append(running, j)
+		}
+	}
+	if all {
+		for _, history := range m.history {
+			finished = append(finished, history...)
+		}
+	} else {
+		finished = slices.Clone(m.history[userID])
+	}
+	m.mu.Unlock()
+
+	compareID := func(a, b *job) int {
+		return cmp.Compare(a.id, b.id)
+	}
+	slices.SortFunc(running, compareID)
+	slices.SortFunc(finished, compareID)
+	return running, finished
+}
+
+// find returns the running or finished job with the given ID owned by the user, or by any user if all is true.
+// It returns nil if the job is neither running nor in the history.
+//
+// Only the fields of a running job that are set at creation may be accessed.
+func (m *jobManager) find(userID int64, id uint64, all bool) (j *job, finished bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if j := m.jobs[id]; j != nil && (all || j.userID == userID) {
+		return j, false
+	}
+	for historyUserID, history := range m.history {
+		if !all && historyUserID != userID {
+			continue
+		}
+		for _, j := range history {
+			if j.id == id {
+				return j, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// get returns the running job with the given ID owned by the user, or by any user if all is true,
+// or nil if not found.
+func (m *jobManager) get(userID int64, id uint64, all bool) *job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if j := m.jobs[id]; j != nil && (all || j.userID == userID) {
+		return j
+	}
+	return nil
+}
+
+// session returns the most recent running interactive job of the user in the chat, or nil if not found.
+func (m *jobManager) session(userID, chatID int64) *job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var latest *job
+	for _, j := range m.jobs {
+		if j.userID == userID && j.chatID == chatID && j.command.Interactive && (latest == nil || j.id > latest.id) {
+			latest = j
+		}
+	}
+	return latest
+}
+
+// byCommand returns the running jobs of the user's command at index with the given name, ordered by job ID.
+//
+// Jobs are matched by index and name rather than by command, so that jobs started before a config reload
+// are still found, as long as their command keeps its place in the user's list.
+func (m *jobManager) byCommand(userID int64, index int, name string) []*job {
+	m.mu.Lock()
+	var jobs []*job
+	for _, j := range m.jobs {
+		if j.userID == userID && j.commandIndex == index && j.command.Name == name {
+			jobs = append(jobs, j)
+		}
+	}
+	m.mu.Unlock()
+
+	slices.SortFunc(jobs, func(a, b *job) int {
+		return cmp.Compare(a.id, b.id)
+	})
+	return jobs
+}
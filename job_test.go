@@ -0,0 +1,193 @@
+package rcebot
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestCancelHandlerAll(t *testing.T) {
+	b, api := newFakeBotAPI(t, func(req fakeBotAPIRequest) fakeBotAPIResponse {
+		if req.Method == "sendMessage" {
+			return fakeBotAPIResponse{Result: models.Message{ID: 100}}
+		}
+		return fakeBotAPIResponse{ErrorCode: http.StatusNotFound, Description: "Not Found"}
+	})
+
+	ctx := t.Context()
+	var jobs jobManager
+	commands := []Command{{Name: "sleep"}, {Name: "true"}}
+	start := func(userID int64, index int) *job {
+		j := jobs.start(ctx, userID, 2, &commands[index], index, nil, nil)
+		go func() {
+			<-j.ctx.Done()
+			j.finish(StopResult{Unstarted: true})
+		}()
+		return j
+	}
+	target := start(1, 0)
+	otherUser := start(3, 0)
+	otherCommand := start(1, 1)
+
+	// Reloading the config replaces the commands, but the running jobs keep pointing to the old ones.
+	reloaded := []Command{{Name: "sleep"}, {Name: "true"}}
+	var userCommandsByID atomic.Pointer[map[int64][]Command]
+	userCommandsByID.Store(&map[int64][]Command{1: reloaded})
+
+	handle := newCancelHandler(&jobs, &userCommandsByID)
+	message := &models.Message{ID: 1, Chat: models.Chat{ID: 2}, From: &models.User{ID: 1}}
+	lastText := func() string {
+		sends := api.Requests("sendMessage")
+		if len(sends) == 0 {
+			return ""
+		}
+		return sends[len(sends)-1].Form.Get("text")
+	}
+
+	for _, cmdArg := range [...]string{"allX", "all5", "alls 0"} {
+		if err := handle(ctx, b, message, cmdArg, false); err != nil {
+			t.Fatalf("handle(%q) = %v", cmdArg, err)
+		}
+		if text := lastText(); !strings.HasPrefix(text, "Usage:") {
+			t.Errorf("handle(%q) replied %q, want usage", cmdArg, text)
+		}
+	}
+
+	if err := handle(ctx, b, message, "all  0", false); err != nil {
+		t.Fatalf("handle(%q) = %v", "all  0", err)
+	}
+	if text, want := lastText(), "Job "+target.idString()+" has been canceled"; !strings.HasPrefix(text, want) {
+		t.Errorf("handle(%q) replied %q, want prefix %q", "all  0", text, want)
+	}
+	if target.ctx.Err() == nil {
+		t.Error("the job of the command was not canceled")
+	}
+	if otherUser.ctx.Err() != nil {
+		t.Error("the job of another user was canceled")
+	}
+	if otherCommand.ctx.Err() != nil {
+		t.Error("the job of another command was canceled")
+	}
+
+	// A different command that took the place of the old one does not match its jobs.
+	if got := jobs.byCommand(3, 0, "renamed"); len(got) != 0 {
+		t.Errorf("jobs.byCommand() found %d jobs of a renamed command, want 0", len(got))
+	}
+}
+
+func TestCancelHandlerAdmin(t *testing.T) {
+	b, api := newFakeBotAPI(t, func(req fakeBotAPIRequest) fakeBotAPIResponse {
+		if req.Method == "sendMessage" {
+			return fakeBotAPIResponse{Result: models.Message{ID: 100}}
+		}
+		return fakeBotAPIResponse{ErrorCode: http.StatusNotFound, Description: "Not Found"}
+	})
+
+	ctx := t.Context()
+	var jobs jobManager
+	command := Command{Name: "sleep"}
+	start := func(userID int64) *job {
+		j := jobs.start(ctx, userID, 2, &command, 0, nil, nil)
+		go func() {
+			<-j.ctx.Done()
+			j.finish(StopResult{Unstarted: true})
+		}()
+		return j
+	}
+	otherUser := start(3)
+	scheduled := start(0)
+
+	var userCommandsByID atomic.Pointer[map[int64][]Command]
+	userCommandsByID.Store(&map[int64][]Command{1: {{Name: "sleep"}}})
+	handle := newCancelHandler(&jobs, &userCommandsByID)
+	message := &models.Message{ID: 1, Chat: models.Chat{ID: 2}, From: &models.User{ID: 1}}
+	lastText := func() string {
+		sends := api.Requests("sendMessage")
+		if len(sends) == 0 {
+			return ""
+		}
+		return sends[len(sends)-1].Form.Get("text")
+	}
+
+	if err := handle(ctx, b, message, otherUser.idString(), false); err != nil {
+		t.Fatalf("handle(%q) = %v", otherUser.idString(), err)
+	}
+	if text, want := lastText(), "No running job with ID"; !strings.HasPrefix(text, want) {
+		t.Errorf("handle(%q) replied %q, want prefix %q", otherUser.idString(), text, want)
+	}
+	if otherUser.ctx.Err() != nil {
+		t.Error("a non-admin canceled the job of another user")
+	}
+
+	for _, j := range [...]*job{otherUser, scheduled} {
+		if err := handle(ctx, b, message, j.idString(), true); err != nil {
+			t.Fatalf("handle(%q) = %v", j.idString(), err)
+		}
+		if text, want := lastText(), "Job "+j.idString()+" has been canceled"; !strings.HasPrefix(text, want) {
+			t.Errorf("handle(%q) replied %q, want prefix %q", j.idString(), text, want)
+		}
+		if j.ctx.Err() == nil {
+			t.Errorf("an admin did not cancel the job of %s", jobOwner(j))
+		}
+	}
+}
+
+func TestJobExecuteCanceledWhileQueued(t *testing.T) {
+	b, api := newFakeBotAPI(t, func(req fakeBotAPIRequest) fakeBotAPIResponse {
+		switch req.Method {
+		case "sendMessage", "editMessageText":
+			return fakeBotAPIResponse{Result: models.Message{ID: 100}}
+		default:
+			return fakeBotAPIResponse{ErrorCode: http.StatusNotFound, Description: "Not Found"}
+		}
+	})
+
+	ctx := t.Context()
+	var scheduler jobScheduler
+	scheduler.setLimit(1)
+	if err := scheduler.acquire(ctx, 0, func(int) {}); err != nil {
+		t.Fatalf("scheduler.acquire() = %v", err)
+	}
+
+	var jobs jobManager
+	command := Command{Name: "true"}
+	j := jobs.start(ctx, 1, 2, &command, 0, nil, nil)
+	message := &models.Message{ID: 1, Chat: models.Chat{ID: 2}, From: &models.User{ID: 1}}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- j.execute(ctx, b, message, &scheduler, nil, false, nil)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(api.Requests("sendMessage")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	sends := api.Requests("sendMessage")
+	if len(sends) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sends))
+	}
+	if got, want := sends[0].Form.Get("text"), "Job 1 is queued at position 1."; got != want {
+		t.Errorf("queued message = %q, want %q", got, want)
+	}
+
+	j.cancel()
+	if err := <-errCh; err != nil {
+		t.Fatalf("j.execute() = %v, want nil", err)
+	}
+	if !j.stopResult.Unstarted {
+		t.Error("j.stopResult.Unstarted = false, want true")
+	}
+
+	edits := api.Requests("editMessageText")
+	if len(edits) != 1 {
+		t.Fatalf("made %d edits, want 1", len(edits))
+	}
+	if got, want := edits[0].Form.Get("text"), "Job 1 was canceled before it started."; got != want {
+		t.Errorf("edited message = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build linux && (mips64 || mips64le)
+
+package rcebot
+
+// Landlock system call numbers in the n64 ABI.
+const (
+	sysLandlockCreateRuleset = 5444
+	sysLandlockAddRule       = 5445
+	sysLandlockRestrictSelf  = 5446
+)
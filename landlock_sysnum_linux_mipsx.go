@@ -0,0 +1,10 @@
+//go:build linux && (mips || mipsle)
+
+package rcebot
+
+// Landlock system call numbers in the o32 ABI.
+const (
+	sysLandlockCreateRuleset = 4444
+	sysLandlockAddRule       = 4445
+	sysLandlockRestrictSelf  = 4446
+)
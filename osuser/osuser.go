@@ -0,0 +1,89 @@
+// Package osuser resolves user and group IDs from configuration values.
+package osuser
+
+import (
+	"errors"
+	"fmt"
+	"os/user"
+	"strconv"
+
+	"github.com/database64128/cubic-rce-bot/jsoncfg"
+)
+
+// LookupUser returns the user specified by v, which can be an integer user ID or a string username.
+//
+// If v is an integer user ID without a corresponding user account, it returns a nil user and a nil error.
+// It panics if v is invalid.
+func LookupUser(v jsoncfg.IntOrString) (*user.User, error) {
+	switch v.Kind() {
+	case jsoncfg.IntOrStringKindInt:
+		uid := strconv.Itoa(v.Int())
+		u, err := user.LookupId(uid)
+		if err != nil {
+			if _, ok := errors.AsType[user.UnknownUserIdError](err); ok {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("failed to lookup user ID %s: %w", uid, err)
+		}
+		return u, nil
+
+	case jsoncfg.IntOrStringKindString:
+		username := v.String()
+		u, err := user.Lookup(username)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lookup user %q: %w", username, err)
+		}
+		return u, nil
+
+	default:
+		panic("osuser: invalid user")
+	}
+}
+
+// LookupUID returns the user ID specified by v, which can be an integer user ID or a string username.
+// If v is invalid, it returns -1.
+func LookupUID(v jsoncfg.IntOrString) (int, error) {
+	switch v.Kind() {
+	case jsoncfg.IntOrStringKindInt:
+		return v.Int(), nil
+
+	case jsoncfg.IntOrStringKindString:
+		u, err := LookupUser(v)
+		if err != nil {
+			return 0, err
+		}
+		return ParseID(u.Uid)
+
+	default:
+		return -1, nil
+	}
+}
+
+// LookupGID returns the group ID specified by v, which can be an integer group ID or a string group name.
+// If v is invalid, it returns -1.
+func LookupGID(v jsoncfg.IntOrString) (int, error) {
+	switch v.Kind() {
+	case jsoncfg.IntOrStringKindInt:
+		return v.Int(), nil
+
+	case jsoncfg.IntOrStringKindString:
+		groupName := v.String()
+		group, err := user.LookupGroup(groupName)
+		if err != nil {
+			return 0, fmt.Errorf("failed to lookup group %q: %w", groupName, err)
+		}
+		return ParseID(group.Gid)
+
+	default:
+		return -1, nil
+	}
+}
+
+// ParseID parses a user or group ID string as returned by [os/user].
+func ParseID(s string) (int, error) {
+	id, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("failed to convert ID %q to int: %w", s, err)
+	}
+	return id, nil
+}
@@ -0,0 +1,334 @@
+package rcebot
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxMessageLength is the maximum length of a Telegram text message in UTF-16 code units.
+const MaxMessageLength = 4096
+
+// OverflowPolicy is the policy for handling command output that does not fit in a single message.
+type OverflowPolicy string
+
+const (
+	// OverflowPolicyTruncate keeps the head and the tail of the output and replaces the middle with a marker.
+	OverflowPolicyTruncate OverflowPolicy = "truncate"
+
+	// OverflowPolicySplit splits the output across multiple messages, each with its own code block.
+	OverflowPolicySplit OverflowPolicy = "split"
+
+	// OverflowPolicyDocument uploads the full output as a document, along with a short summary message.
+	OverflowPolicyDocument OverflowPolicy = "document"
+)
+
+// IsValid returns whether the policy is a known policy.
+func (p OverflowPolicy) IsValid() bool {
+	switch p {
+	case OverflowPolicyTruncate, OverflowPolicySplit, OverflowPolicyDocument:
+		return true
+	default:
+		return false
+	}
+}
+
+// MessageLength returns the length of s in UTF-16 code units, which is how Telegram measures message length.
+//
+// Invalid UTF-8 bytes are counted as one code unit each, as they are replaced with U+FFFD when encoded as JSON.
+func MessageLength(s string) int {
+	var n int
+	for _, r := range s {
+		n += utf16RuneLen(r)
+	}
+	return n
+}
+
+func utf16RuneLen(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// codeBlockRuneLen returns the length of r in UTF-16 code units after escaping for use in a MarkdownV2 code block.
+func codeBlockRuneLen(r rune) int {
+	switch r {
+	case '`', '\\':
+		return 2
+	default:
+		return utf16RuneLen(r)
+	}
+}
+
+// codeBlockPrefixLen returns the length in bytes of the longest prefix of b,
+// ending on a rune boundary, that fits in budget UTF-16 code units after escaping.
+func codeBlockPrefixLen(b []byte, budget int) int {
+	var i int
+	for i < len(b) {
+		r, size := utf8.DecodeRune(b[i:])
+		budget -= codeBlockRuneLen(r)
+		if budget < 0 {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+// codeBlockSuffixLen returns the length in bytes of the longest suffix of b,
+// starting on a rune boundary, that fits in budget UTF-16 code units after escaping.
+func codeBlockSuffixLen(b []byte, budget int) int {
+	i := len(b)
+	for i > 0 {
+		r, size := utf8.DecodeLastRune(b[:i])
+		budget -= codeBlockRuneLen(r)
+		if budget < 0 {
+			break
+		}
+		i -= size
+	}
+	return len(b) - i
+}
+
+// codeBlockOverhead is the length of the code block delimiters and the trailing line feed added by
+// [CommandOutputResponseBuilder.Build].
+const codeBlockOverhead = len("```\n") + len("\n") + len("```\n")
+
+// truncationMarkerMaxLength is the maximum length of the marker inserted in place of omitted output.
+const truncationMarkerMaxLength = len("[... 18446744073709551615 bytes omitted ...]\n")
+
+// OutputSection is a section of command output, such as standard output or standard error.
+type OutputSection struct {
+	// Label is the optional label shown above the section's code block.
+	Label string
+
+	// Output is the section's output.
+	Output []byte
+}
+
+// sectionLabel returns the MarkdownV2 line shown above a section's code block, or an empty string if it has no label.
+func sectionLabel(label string) string {
+	if label == "" {
+		return ""
+	}
+	return "*" + EscapeMarkdownV2Plaintext(label) + ":*\n"
+}
+
+// codeBlockLength returns the length of b in UTF-16 code units after escaping for use in a MarkdownV2 code block.
+func codeBlockLength(b []byte) int {
+	var n int
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		n += codeBlockRuneLen(r)
+		b = b[size:]
+	}
+	return n
+}
+
+// buildSectionMessages builds the response messages for the given output sections, each fitting in [MaxMessageLength],
+// applying policy if the output does not fit in a single message. Each section is rendered in its own code block,
+// preceded by its label, and the response ends with the MarkdownV2 text errText.
+//
+// When attach is true, the caller should upload the full output as a document alongside the messages.
+// Unlike [CommandOutputResponseBuilder.Build], the returned strings remain valid indefinitely.
+func (rb *CommandOutputResponseBuilder) buildSectionMessages(sections []OutputSection, errText string, policy OverflowPolicy) (messages []string, attach bool) {
+	resp := rb.buildSections(sections, errText)
+	if MessageLength(resp) <= MaxMessageLength {
+		return []string{resp}, false
+	}
+
+	switch policy {
+	case OverflowPolicySplit:
+		errText = truncateMarkdownV2Plaintext(errText, MaxMessageLength)
+		errLength := MessageLength(errText)
+		for _, section := range sections {
+			label := sectionLabel(section.Label)
+			budget := MaxMessageLength - codeBlockOverhead - MessageLength(label)
+			output := section.Output
+			for {
+				n := codeBlockPrefixLen(output, budget)
+				if n < len(output) {
+					// Prefer splitting at a line boundary.
+					if i := bytes.LastIndexByte(output[:n], '\n'); i > 0 {
+						n = i + 1
+					}
+				}
+				messages = append(messages, strings.Clone(label+rb.Build(output[:n], nil)))
+				output = output[n:]
+				if len(output) == 0 {
+					break
+				}
+				label = ""
+				budget = MaxMessageLength - codeBlockOverhead
+			}
+		}
+		if errText != "" {
+			last := &messages[len(messages)-1]
+			if MessageLength(*last)+errLength <= MaxMessageLength {
+				*last += errText
+			} else {
+				messages = append(messages, errText)
+			}
+		}
+		return messages, false
+
+	case OverflowPolicyDocument:
+		var size int
+		for _, section := range sections {
+			size += len(section.Output)
+		}
+		var sb strings.Builder
+		sb.WriteString("Output is too long \\(")
+		sb.WriteString(strconv.Itoa(size))
+		sb.WriteString(" bytes\\) and has been sent as a document\\.\n")
+		sb.WriteString(truncateMarkdownV2Plaintext(errText, MaxMessageLength-MessageLength(sb.String())))
+		return []string{sb.String()}, true
+
+	default:
+		// Leave every section room for at least its truncation marker.
+		budget := MaxMessageLength
+		for _, section := range sections {
+			budget -= MessageLength(sectionLabel(section.Label)) + codeBlockOverhead
+		}
+		reserve := 0
+		for _, section := range sections {
+			reserve += min(codeBlockLength(section.Output), truncationMarkerMaxLength)
+		}
+		errText = truncateMarkdownV2Plaintext(errText, budget-reserve)
+		budget -= MessageLength(errText)
+
+		// Share the budget fairly, letting sections that fit in their share pass their leftover on to the rest.
+		order := make([]int, len(sections))
+		lengths := make([]int, len(sections))
+		for i, section := range sections {
+			order[i] = i
+			lengths[i] = codeBlockLength(section.Output)
+		}
+		slices.SortStableFunc(order, func(a, b int) int {
+			return cmp.Compare(lengths[a], lengths[b])
+		})
+
+		truncated := slices.Clone(sections)
+		for n, i := range order {
+			share := max(budget/(len(order)-n), 0)
+			if lengths[i] <= share {
+				budget -= lengths[i]
+				continue
+			}
+			truncated[i].Output = truncateOutput(sections[i].Output, share)
+			budget -= share
+		}
+		return []string{rb.buildSections(truncated, errText)}, false
+	}
+}
+
+// buildSections builds a response with each section in its own code block, followed by errText.
+// The returned string remains valid indefinitely.
+func (rb *CommandOutputResponseBuilder) buildSections(sections []OutputSection, errText string) string {
+	var sb strings.Builder
+	for _, section := range sections {
+		sb.WriteString(sectionLabel(section.Label))
+		sb.WriteString(rb.Build(section.Output, nil))
+	}
+	sb.WriteString(errText)
+	return sb.String()
+}
+
+// truncateMarkdownV2Plaintext returns the longest prefix of the escaped MarkdownV2 plaintext s,
+// followed by an ellipsis, that fits in budget UTF-16 code units. Escape sequences are never split.
+// If s already fits, it is returned unchanged.
+func truncateMarkdownV2Plaintext(s string, budget int) string {
+	if MessageLength(s) <= budget {
+		return s
+	}
+	if budget < 1 {
+		return ""
+	}
+	budget-- // for the ellipsis
+	var (
+		n       int
+		escaped bool
+	)
+	for i, r := range s {
+		if escaped {
+			escaped = false
+			continue
+		}
+		length := utf16RuneLen(r)
+		if r == '\\' {
+			escaped = true
+			length++
+		}
+		if n+length > budget {
+			return s[:i] + "…"
+		}
+		n += length
+	}
+	return s
+}
+
+// truncateOutput keeps the head and the tail of output that fit in budget UTF-16 code units after escaping,
+// and replaces the middle with a marker.
+func truncateOutput(output []byte, budget int) []byte {
+	budget = max(budget-truncationMarkerMaxLength, 0)
+	head := output[:codeBlockPrefixLen(output, budget/2)]
+	tail := output[len(output)-codeBlockSuffixLen(output, budget-budget/2):]
+
+	// Prefer cutting at line boundaries.
+	if i := bytes.LastIndexByte(head, '\n'); i > 0 {
+		head = head[:i+1]
+	}
+	if i := bytes.IndexByte(tail, '\n'); i != -1 && i < len(tail)-1 {
+		tail = tail[i+1:]
+	}
+	if len(head)+len(tail) > len(output) {
+		tail = output[len(head):]
+	}
+
+	truncated := make([]byte, 0, len(head)+1+truncationMarkerMaxLength+len(tail))
+	truncated = append(truncated, head...)
+	if len(head) > 0 && head[len(head)-1] != '\n' {
+		truncated = append(truncated, '\n')
+	}
+	truncated = fmt.Appendf(truncated, "[... %d bytes omitted ...]\n", len(output)-len(head)-len(tail))
+	return append(truncated, tail...)
+}
+
+// outputDocument returns the file name and contents of the document for the given output sections.
+// Labelled sections are preceded by a header line. If compress is true, the contents are gzip-compressed.
+func outputDocument(sections []OutputSection, compress bool) (string, []byte, error) {
+	var output []byte
+	if len(sections) == 1 && sections[0].Label == "" {
+		output = sections[0].Output
+	} else {
+		for _, section := range sections {
+			if len(output) > 0 && output[len(output)-1] != '\n' {
+				output = append(output, '\n')
+			}
+			if section.Label != "" {
+				output = append(output, "==> "+section.Label+" <==\n"...)
+			}
+			output = append(output, section.Output...)
+		}
+	}
+
+	if !compress {
+		return "output.txt", output, nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(output); err != nil {
+		return "", nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return "", nil, err
+	}
+	return "output.txt.gz", buf.Bytes(), nil
+}
@@ -0,0 +1,225 @@
+package rcebot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMessageLength(t *testing.T) {
+	for _, c := range [...]struct {
+		input string
+		want  int
+	}{
+		{"", 0},
+		{"hello", 5},
+		{"你好", 2},
+		{"😀", 2},
+		{"a😀b", 4},
+		{"\xff", 1},
+	} {
+		if got := MessageLength(c.input); got != c.want {
+			t.Errorf("MessageLength(%q) = %d, want %d", c.input, got, c.want)
+		}
+	}
+}
+
+func TestCommandOutputResponseBuilderBuildSectionMessagesUnlabeled(t *testing.T) {
+	rb := CommandOutputResponseBuilder{}
+
+	var lines strings.Builder
+	for lines.Len() < 3*MaxMessageLength {
+		lines.WriteString("`😀` line \\ of output\n")
+	}
+
+	for _, c := range [...]struct {
+		name         string
+		output       string
+		policy       OverflowPolicy
+		wantMessages int
+		wantAttach   bool
+	}{
+		{
+			name:         "Fits",
+			output:       "hello\n",
+			policy:       OverflowPolicySplit,
+			wantMessages: 1,
+		},
+		{
+			name:         "Truncate",
+			output:       lines.String(),
+			policy:       OverflowPolicyTruncate,
+			wantMessages: 1,
+		},
+		{
+			name:         "TruncateSingleLine",
+			output:       strings.Repeat("😀", 3*MaxMessageLength),
+			policy:       OverflowPolicyTruncate,
+			wantMessages: 1,
+		},
+		{
+			name:         "DefaultTruncate",
+			output:       lines.String(),
+			wantMessages: 1,
+		},
+		{
+			name:         "Split",
+			output:       lines.String(),
+			policy:       OverflowPolicySplit,
+			wantMessages: 4,
+		},
+		{
+			name:         "Document",
+			output:       lines.String(),
+			policy:       OverflowPolicyDocument,
+			wantMessages: 1,
+			wantAttach:   true,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			messages, attach := rb.buildSectionMessages([]OutputSection{{Output: []byte(c.output)}}, "exit status 1", c.policy)
+			if len(messages) != c.wantMessages {
+				t.Errorf("len(messages) = %d, want %d", len(messages), c.wantMessages)
+			}
+			if attach != c.wantAttach {
+				t.Errorf("attach = %v, want %v", attach, c.wantAttach)
+			}
+			for i, message := range messages {
+				if n := MessageLength(message); n > MaxMessageLength {
+					t.Errorf("MessageLength(messages[%d]) = %d, want <= %d", i, n, MaxMessageLength)
+				}
+				if strings.Count(message, "```")%2 != 0 {
+					t.Errorf("messages[%d] has unbalanced code block delimiters", i)
+				}
+			}
+			if last := messages[len(messages)-1]; !strings.HasSuffix(last, "exit status 1") {
+				t.Errorf("last message = %q, want error suffix", last)
+			}
+		})
+	}
+}
+
+func TestCommandOutputResponseBuilderBuildSectionMessages(t *testing.T) {
+	rb := CommandOutputResponseBuilder{}
+	long := strings.Repeat("line of output\n", MaxMessageLength/8)
+
+	for _, c := range [...]struct {
+		name         string
+		stdout       string
+		stderr       string
+		policy       OverflowPolicy
+		wantMessages int
+		wantAttach   bool
+	}{
+		{
+			name:         "Fits",
+			stdout:       "result\n",
+			stderr:       "warning\n",
+			wantMessages: 1,
+		},
+		{
+			name:         "TruncateLongStdout",
+			stdout:       long,
+			stderr:       "warning\n",
+			wantMessages: 1,
+		},
+		{
+			name:         "TruncateBoth",
+			stdout:       long,
+			stderr:       long,
+			wantMessages: 1,
+		},
+		{
+			name:         "Split",
+			stdout:       long,
+			stderr:       "warning\n",
+			policy:       OverflowPolicySplit,
+			wantMessages: 3,
+		},
+		{
+			name:         "Document",
+			stdout:       long,
+			stderr:       long,
+			policy:       OverflowPolicyDocument,
+			wantMessages: 1,
+			wantAttach:   true,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			sections := []OutputSection{
+				{Label: "stdout", Output: []byte(c.stdout)},
+				{Label: "stderr", Output: []byte(c.stderr)},
+			}
+			messages, attach := rb.buildSectionMessages(sections, "exit status 1", c.policy)
+			if len(messages) != c.wantMessages {
+				t.Errorf("len(messages) = %d, want %d", len(messages), c.wantMessages)
+			}
+			if attach != c.wantAttach {
+				t.Errorf("attach = %v, want %v", attach, c.wantAttach)
+			}
+			for i, message := range messages {
+				if n := MessageLength(message); n > MaxMessageLength {
+					t.Errorf("MessageLength(messages[%d]) = %d, want <= %d", i, n, MaxMessageLength)
+				}
+				if strings.Count(message, "```")%2 != 0 {
+					t.Errorf("messages[%d] has unbalanced code block delimiters", i)
+				}
+			}
+			if !attach {
+				all := strings.Join(messages, "")
+				for _, label := range [...]string{"*stdout:*\n", "*stderr:*\n"} {
+					if strings.Count(all, label) != 1 {
+						t.Errorf("messages contain %q %d times, want 1", label, strings.Count(all, label))
+					}
+				}
+				if !strings.Contains(all, "warning") && c.stderr == "warning\n" {
+					t.Error("messages do not contain the short stderr section")
+				}
+			}
+			if last := messages[len(messages)-1]; !strings.HasSuffix(last, "exit status 1") {
+				t.Errorf("last message = %q, want error suffix", last)
+			}
+		})
+	}
+}
+
+func TestTruncateMarkdownV2Plaintext(t *testing.T) {
+	for _, c := range [...]struct {
+		input  string
+		budget int
+		want   string
+	}{
+		{"exit status 1", 100, "exit status 1"},
+		{"exit status 1", 5, "exit…"},
+		{"a\\.b", 2, "a…"},
+		{"a\\.bc", 4, "a\\.…"},
+		{"😀😀", 3, "😀…"},
+		{"abc", 0, ""},
+	} {
+		if got := truncateMarkdownV2Plaintext(c.input, c.budget); got != c.want {
+			t.Errorf("truncateMarkdownV2Plaintext(%q, %d) = %q, want %q", c.input, c.budget, got, c.want)
+		}
+	}
+}
+
+func TestCommandOutputResponseBuilderBuildSectionMessagesLongErrText(t *testing.T) {
+	rb := CommandOutputResponseBuilder{}
+	errText := EscapeMarkdownV2Plaintext(strings.Repeat("stat /very/long/path: no such file or directory. ", MaxMessageLength/16))
+	sections := []OutputSection{
+		{Label: "stdout", Output: []byte(strings.Repeat("line of output\n", 64))},
+		{Label: "stderr", Output: []byte("warning\n")},
+	}
+
+	for _, policy := range [...]OverflowPolicy{OverflowPolicyTruncate, OverflowPolicySplit, OverflowPolicyDocument} {
+		t.Run(string(policy), func(t *testing.T) {
+			messages, _ := rb.buildSectionMessages(sections, errText, policy)
+			for i, message := range messages {
+				if n := MessageLength(message); n > MaxMessageLength {
+					t.Errorf("MessageLength(messages[%d]) = %d, want <= %d", i, n, MaxMessageLength)
+				}
+			}
+			if last := messages[len(messages)-1]; !strings.HasSuffix(last, "…") {
+				t.Errorf("last message does not end with the truncated error text: %q", last[max(len(last)-64, 0):])
+			}
+		})
+	}
+}
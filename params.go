@@ -0,0 +1,328 @@
+package rcebot
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/database64128/cubic-rce-bot/jsoncfg"
+)
+
+// CommandParamType is the type of a command parameter.
+type CommandParamType string
+
+const (
+	// CommandParamTypeString is a string parameter, optionally constrained by a regular expression.
+	CommandParamTypeString CommandParamType = "string"
+
+	// CommandParamTypeInt is an integer parameter, optionally constrained by a range.
+	CommandParamTypeInt CommandParamType = "int"
+
+	// CommandParamTypeEnum is a parameter whose value must be one of a fixed set of strings.
+	CommandParamTypeEnum CommandParamType = "enum"
+)
+
+// CommandParam is a named parameter of a command.
+//
+// Parameter values are supplied by the user as "name=value" pairs after the command index,
+// and are substituted into the command arguments where "${name}" appears.
+type CommandParam struct {
+	// Name is the parameter name.
+	//
+	// It must consist of ASCII letters, digits and underscores, and must not start with a digit.
+	Name string `json:"name"`
+
+	// Type is the parameter type.
+	//
+	// If empty, [CommandParamTypeString] is used.
+	Type CommandParamType `json:"type,omitzero"`
+
+	// Values is the list of allowed values for an enum parameter.
+	Values []string `json:"values,omitzero"`
+
+	// Min is the optional inclusive lower bound of an int parameter.
+	Min *int64 `json:"min,omitzero"`
+
+	// Max is the optional inclusive upper bound of an int parameter.
+	Max *int64 `json:"max,omitzero"`
+
+	// Pattern is the optional regular expression a string parameter must match in full.
+	//
+	// Without a pattern, a string parameter accepts any value that is not empty and does not start with "-",
+	// so that it cannot be taken for an option by the command.
+	Pattern string `json:"pattern,omitzero"`
+
+	// Default is the optional default value.
+	// It can be an integer or a string.
+	//
+	// Parameters without a default value must be supplied by the user.
+	Default jsoncfg.IntOrString `json:"default,omitzero"`
+
+	patternRegexp *regexp.Regexp
+	defaultValue  string
+}
+
+// isParamName returns whether s is a valid parameter name.
+func isParamName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// init validates the parameter and initializes its internal state.
+func (p *CommandParam) init() error {
+	if !isParamName(p.Name) {
+		return fmt.Errorf("invalid parameter name %q", p.Name)
+	}
+
+	switch p.Type {
+	case "":
+		p.Type = CommandParamTypeString
+	case CommandParamTypeString, CommandParamTypeInt, CommandParamTypeEnum:
+	default:
+		return fmt.Errorf("parameter %q: unknown type %q", p.Name, p.Type)
+	}
+
+	if len(p.Values) > 0 && p.Type != CommandParamTypeEnum {
+		return fmt.Errorf("parameter %q: values are only allowed for enum parameters", p.Name)
+	}
+	if (p.Min != nil || p.Max != nil) && p.Type != CommandParamTypeInt {
+		return fmt.Errorf("parameter %q: min and max are only allowed for int parameters", p.Name)
+	}
+	if p.Pattern != "" && p.Type != CommandParamTypeString {
+		return fmt.Errorf("parameter %q: pattern is only allowed for string parameters", p.Name)
+	}
+
+	switch p.Type {
+	case CommandParamTypeEnum:
+		if len(p.Values) == 0 {
+			return fmt.Errorf("parameter %q: enum parameters must have at least one value", p.Name)
+		}
+	case CommandParamTypeInt:
+		if p.Min != nil && p.Max != nil && *p.Min > *p.Max {
+			return fmt.Errorf("parameter %q: min %d is greater than max %d", p.Name, *p.Min, *p.Max)
+		}
+	case CommandParamTypeString:
+		if p.Pattern != "" {
+			re, err := regexp.Compile(`^(?:` + p.Pattern + `)$`)
+			if err != nil {
+				return fmt.Errorf("parameter %q: invalid pattern: %w", p.Name, err)
+			}
+			p.patternRegexp = re
+		}
+	}
+
+	switch p.Default.Kind() {
+	case jsoncfg.IntOrStringKindInt:
+		p.defaultValue = strconv.Itoa(p.Default.Int())
+	case jsoncfg.IntOrStringKindString:
+		p.defaultValue = p.Default.String()
+	}
+	if p.Default.IsValid() {
+		value, err := p.resolve(p.defaultValue)
+		if err != nil {
+			return fmt.Errorf("invalid default value: %w", err)
+		}
+		p.defaultValue = value
+	}
+
+	return nil
+}
+
+// resolve validates value, and returns the value to substitute into the command arguments.
+// Integers are substituted in canonical form, so that "+5" and "007" become "5" and "7".
+func (p *CommandParam) resolve(value string) (string, error) {
+	switch p.Type {
+	case CommandParamTypeInt:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("parameter %q: %q is not an integer", p.Name, value)
+		}
+		if p.Min != nil && n < *p.Min {
+			return "", fmt.Errorf("parameter %q: %d is less than the minimum %d", p.Name, n, *p.Min)
+		}
+		if p.Max != nil && n > *p.Max {
+			return "", fmt.Errorf("parameter %q: %d is greater than the maximum %d", p.Name, n, *p.Max)
+		}
+		return strconv.FormatInt(n, 10), nil
+
+	case CommandParamTypeEnum:
+		if !slices.Contains(p.Values, value) {
+			return "", fmt.Errorf("parameter %q: %q is not one of %s", p.Name, value, strings.Join(p.Values, ", "))
+		}
+
+	default:
+		switch {
+		case p.patternRegexp != nil:
+			if !p.patternRegexp.MatchString(value) {
+				return "", fmt.Errorf("parameter %q: %q does not match pattern %q", p.Name, value, p.Pattern)
+			}
+		case value == "":
+			return "", fmt.Errorf("parameter %q: empty value is only allowed with a pattern", p.Name)
+		case value[0] == '-':
+			return "", fmt.Errorf("parameter %q: %q starts with \"-\", which is only allowed with a pattern", p.Name, value)
+		}
+	}
+
+	return value, nil
+}
+
+// Describe returns a short human-readable description of the parameter's constraints.
+func (p *CommandParam) Describe() string {
+	var sb strings.Builder
+	sb.WriteString(string(p.Type))
+
+	switch p.Type {
+	case CommandParamTypeInt:
+		if p.Min != nil || p.Max != nil {
+			sb.WriteString(" [")
+			if p.Min != nil {
+				sb.WriteString(strconv.FormatInt(*p.Min, 10))
+			}
+			sb.WriteString(", ")
+			if p.Max != nil {
+				sb.WriteString(strconv.FormatInt(*p.Max, 10))
+			}
+			sb.WriteByte(']')
+		}
+	case CommandParamTypeEnum:
+		sb.WriteString(" (")
+		sb.WriteString(strings.Join(p.Values, "|"))
+		sb.WriteByte(')')
+	case CommandParamTypeString:
+		if p.Pattern != "" {
+			sb.WriteString(" /")
+			sb.WriteString(p.Pattern)
+			sb.WriteByte('/')
+		}
+	}
+
+	if p.Default.IsValid() {
+		sb.WriteString(", default ")
+		sb.WriteString(strconv.Quote(p.defaultValue))
+	} else {
+		sb.WriteString(", required")
+	}
+
+	return sb.String()
+}
+
+// forEachArgPlaceholder calls fn for each "${name}" placeholder in arg.
+func forEachArgPlaceholder(arg string, fn func(name string) error) error {
+	for {
+		start := strings.Index(arg, "${")
+		if start == -1 {
+			return nil
+		}
+		arg = arg[start+2:]
+		end := strings.IndexByte(arg, '}')
+		if end == -1 {
+			return errors.New("unterminated placeholder")
+		}
+		if err := fn(arg[:end]); err != nil {
+			return err
+		}
+		arg = arg[end+1:]
+	}
+}
+
+// expandArg replaces each "${name}" placeholder in arg with values[name].
+// Placeholders must have been validated by [forEachArgPlaceholder].
+func expandArg(arg string, values map[string]string) string {
+	if !strings.Contains(arg, "${") {
+		return arg
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(arg))
+	for {
+		start := strings.Index(arg, "${")
+		if start == -1 {
+			sb.WriteString(arg)
+			return sb.String()
+		}
+		sb.WriteString(arg[:start])
+		arg = arg[start+2:]
+		end := strings.IndexByte(arg, '}')
+		sb.WriteString(values[arg[:end]])
+		arg = arg[end+1:]
+	}
+}
+
+// SplitArguments splits s into space-separated fields.
+// A field may be enclosed in single or double quotes to include spaces.
+func SplitArguments(s string) ([]string, error) {
+	var (
+		fields  []string
+		sb      strings.Builder
+		inField bool
+		quote   rune
+	)
+
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+				continue
+			}
+			sb.WriteRune(r)
+
+		case r == '\'' || r == '"':
+			quote = r
+			inField = true
+
+		case r == ' ' || r == '\t' || r == '\n':
+			if inField {
+				fields = append(fields, sb.String())
+				sb.Reset()
+				inField = false
+			}
+
+		default:
+			sb.WriteRune(r)
+			inField = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inField {
+		fields = append(fields, sb.String())
+	}
+	return fields, nil
+}
+
+// ParseParamValues parses "name=value" fields in s into a map of parameter values.
+func ParseParamValues(s string) (map[string]string, error) {
+	fields, err := SplitArguments(s)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(fields))
+	for _, field := range fields {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected name=value, got %q", field)
+		}
+		if _, ok := values[name]; ok {
+			return nil, fmt.Errorf("parameter %q specified more than once", name)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
@@ -0,0 +1,313 @@
+package rcebot
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/database64128/cubic-rce-bot/jsoncfg"
+)
+
+func TestSplitArguments(t *testing.T) {
+	for _, c := range [...]struct {
+		name      string
+		input     string
+		want      []string
+		expectErr bool
+	}{
+		{
+			name:  "Empty",
+			input: "",
+		},
+		{
+			name:  "Simple",
+			input: "a=1  b=2",
+			want:  []string{"a=1", "b=2"},
+		},
+		{
+			name:  "DoubleQuoted",
+			input: `a="hello world" b=2`,
+			want:  []string{"a=hello world", "b=2"},
+		},
+		{
+			name:  "SingleQuoted",
+			input: `'a=it"s'`,
+			want:  []string{`a=it"s`},
+		},
+		{
+			name:  "EmptyQuoted",
+			input: `a=""`,
+			want:  []string{"a="},
+		},
+		{
+			name:      "Unterminated",
+			input:     `a="hello`,
+			expectErr: true,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := SplitArguments(c.input)
+			if (err != nil) != c.expectErr {
+				t.Fatalf("SplitArguments(%q) error = %v, expectErr %v", c.input, err, c.expectErr)
+			}
+			if !slices.Equal(got, c.want) {
+				t.Errorf("SplitArguments(%q) = %q, want %q", c.input, got, c.want)
+			}
+		})
+	}
+}
+
+func TestCommandResolveArgs(t *testing.T) {
+	minLines, maxLines := int64(1), int64(1000)
+	cfg := Config{
+		Users: []User{
+			{
+				ID: 1,
+				Commands: []Command{
+					{
+						Name: "journalctl",
+						Args: []string{"-u", "${unit}.service", "-n", "${lines}", "-g", "${grep}"},
+						Params: []CommandParam{
+							{
+								Name:   "unit",
+								Type:   CommandParamTypeEnum,
+								Values: []string{"nginx", "sshd"},
+							},
+							{
+								Name:    "lines",
+								Type:    CommandParamTypeInt,
+								Min:     &minLines,
+								Max:     &maxLines,
+								Default: jsoncfg.IntOrStringFromInt(100),
+							},
+							{
+								Name:    "grep",
+								Pattern: "[a-z]*",
+								Default: jsoncfg.IntOrStringFromString(""),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	userCommandsByID, err := cfg.UserCommandsByID()
+	if err != nil {
+		t.Fatalf("cfg.UserCommandsByID() = %v", err)
+	}
+	command := &userCommandsByID[1][0]
+
+	for _, c := range [...]struct {
+		name      string
+		input     string
+		want      []string
+		expectErr bool
+	}{
+		{
+			name:  "Defaults",
+			input: "unit=nginx",
+			want:  []string{"-u", "nginx.service", "-n", "100", "-g", ""},
+		},
+		{
+			name:  "All",
+			input: "unit=sshd lines=5 grep=fail",
+			want:  []string{"-u", "sshd.service", "-n", "5", "-g", "fail"},
+		},
+		{
+			name:  "IntSign",
+			input: "unit=sshd lines=+5",
+			want:  []string{"-u", "sshd.service", "-n", "5", "-g", ""},
+		},
+		{
+			name:  "IntLeadingZeros",
+			input: "unit=sshd lines=007",
+			want:  []string{"-u", "sshd.service", "-n", "7", "-g", ""},
+		},
+		{
+			name:      "IntLeadingSpace",
+			input:     "unit=sshd 'lines= 5'",
+			expectErr: true,
+		},
+		{
+			name:      "MissingRequired",
+			input:     "lines=5",
+			expectErr: true,
+		},
+		{
+			name:      "BadEnum",
+			input:     "unit=cron",
+			expectErr: true,
+		},
+		{
+			name:      "OutOfRange",
+			input:     "unit=nginx lines=1001",
+			expectErr: true,
+		},
+		{
+			name:      "NotInt",
+			input:     "unit=nginx lines=ten",
+			expectErr: true,
+		},
+		{
+			name:      "PatternMismatch",
+			input:     "unit=nginx grep=Fail;rm",
+			expectErr: true,
+		},
+		{
+			name:      "Unknown",
+			input:     "unit=nginx user=root",
+			expectErr: true,
+		},
+		{
+			name:      "Duplicate",
+			input:     "unit=nginx unit=sshd",
+			expectErr: true,
+		},
+		{
+			name:      "NotPair",
+			input:     "nginx",
+			expectErr: true,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			values, err := ParseParamValues(c.input)
+			if err == nil {
+				var args []string
+				args, err = command.ResolveArgs(values)
+				if !slices.Equal(args, c.want) {
+					t.Errorf("ResolveArgs(%q) = %q, want %q", c.input, args, c.want)
+				}
+			}
+			if (err != nil) != c.expectErr {
+				t.Errorf("ResolveArgs(%q) error = %v, expectErr %v", c.input, err, c.expectErr)
+			}
+		})
+	}
+}
+
+func TestCommandParamResolveString(t *testing.T) {
+	for _, c := range [...]struct {
+		name    string
+		param   CommandParam
+		value   string
+		wantErr string
+	}{
+		{
+			name:  "Plain",
+			param: CommandParam{Name: "host"},
+			value: "example.com",
+		},
+		{
+			name:    "Empty",
+			param:   CommandParam{Name: "host"},
+			value:   "",
+			wantErr: `parameter "host": empty value is only allowed with a pattern`,
+		},
+		{
+			name:    "Option",
+			param:   CommandParam{Name: "host"},
+			value:   "--output=/etc/x",
+			wantErr: `parameter "host": "--output=/etc/x" starts with "-", which is only allowed with a pattern`,
+		},
+		{
+			name:  "OptionInside",
+			param: CommandParam{Name: "host"},
+			value: "a--b",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			value, err := c.param.resolve(c.value)
+			if c.wantErr == "" {
+				if err != nil {
+					t.Errorf("resolve(%q) = %v", c.value, err)
+				}
+				if value != c.value {
+					t.Errorf("resolve(%q) = %q, want %q", c.value, value, c.value)
+				}
+				return
+			}
+			if err == nil || err.Error() != c.wantErr {
+				t.Errorf("resolve(%q) error = %v, want %q", c.value, err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestCommandParamsValidation(t *testing.T) {
+	for _, c := range [...]struct {
+		name     string
+		commands []Command
+	}{
+		{
+			name: "UnknownPlaceholder",
+			commands: []Command{{
+				Name: "echo",
+				Args: []string{"${missing}"},
+			}},
+		},
+		{
+			name: "UnterminatedPlaceholder",
+			commands: []Command{{
+				Name:   "echo",
+				Args:   []string{"${a"},
+				Params: []CommandParam{{Name: "a"}},
+			}},
+		},
+		{
+			name: "DuplicateParam",
+			commands: []Command{{
+				Name:   "echo",
+				Params: []CommandParam{{Name: "a"}, {Name: "a"}},
+			}},
+		},
+		{
+			name: "InvalidName",
+			commands: []Command{{
+				Name:   "echo",
+				Params: []CommandParam{{Name: "1a"}},
+			}},
+		},
+		{
+			name: "EmptyEnum",
+			commands: []Command{{
+				Name:   "echo",
+				Params: []CommandParam{{Name: "a", Type: CommandParamTypeEnum}},
+			}},
+		},
+		{
+			name: "InvalidDefault",
+			commands: []Command{{
+				Name: "echo",
+				Params: []CommandParam{{
+					Name:    "a",
+					Type:    CommandParamTypeEnum,
+					Values:  []string{"x"},
+					Default: jsoncfg.IntOrStringFromString("y"),
+				}},
+			}},
+		},
+		{
+			name: "EmptyDefaultWithoutPattern",
+			commands: []Command{{
+				Name:   "echo",
+				Params: []CommandParam{{Name: "a", Default: jsoncfg.IntOrStringFromString("")}},
+			}},
+		},
+		{
+			name: "InvalidPattern",
+			commands: []Command{{
+				Name:   "echo",
+				Params: []CommandParam{{Name: "a", Pattern: "("}},
+			}},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := Config{
+				Users: []User{{ID: 1, Commands: c.commands}},
+			}
+			if _, err := cfg.UserCommandsByID(); err == nil {
+				t.Error("cfg.UserCommandsByID() = nil, want error")
+			}
+		})
+	}
+}
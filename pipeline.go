@@ -0,0 +1,301 @@
+package rcebot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/database64128/cubic-rce-bot/tslog"
+)
+
+// PipelineStep is a step of a pipeline, which runs another command of the same user.
+type PipelineStep struct {
+	// Command is the index of the command to run in the user's list of commands.
+	// The command cannot be a pipeline, be interactive, or read stdin from messages.
+	Command int `json:"command"`
+
+	// Params is the optional map of parameter values to execute the command with.
+	// Parameters not in the map take their default values.
+	Params map[string]string `json:"params,omitzero"`
+
+	// ContinueOnError makes the pipeline continue with the next step when this step fails.
+	// It cannot be set on the guard step.
+	ContinueOnError bool `json:"continueOnError,omitzero"`
+}
+
+// pipelineStep is a resolved [PipelineStep].
+type pipelineStep struct {
+	label           string
+	index           int
+	command         *Command
+	args            []string
+	continueOnError bool
+}
+
+// isPipeline returns whether the command is a pipeline of other commands.
+func (c *Command) isPipeline() bool {
+	return len(c.Steps) > 0 || c.Guard != nil
+}
+
+// validatePipeline returns an error if the pipeline command sets options that only apply to single commands.
+func (c *Command) validatePipeline() error {
+	if len(c.Steps) == 0 {
+		return errors.New("pipeline has a guard but no steps")
+	}
+	if c.Guard != nil && c.Guard.ContinueOnError {
+		return errors.New("the guard step of a pipeline cannot continue on error")
+	}
+
+	switch {
+	case len(c.Args) > 0, c.Script != "", len(c.Params) > 0, len(c.Artifacts) > 0, c.UploadDir != "", c.Retry != nil:
+		return errors.New("pipelines cannot have arguments, scripts, parameters, artifacts, uploads, or retries of their own")
+	case c.Stdin != "" && c.Stdin != StdinModeNone:
+		return errors.New("pipelines cannot read stdin from messages or documents")
+	case c.Interactive, c.Stream:
+		return errors.New("pipelines cannot be interactive or stream their output")
+	}
+	return nil
+}
+
+// initPipeline resolves the steps of the pipeline command against the user's commands.
+// It must be called after all of the user's commands have been initialized.
+func (c *Command) initPipeline(commands []Command) error {
+	c.pipeline = nil
+	if !c.isPipeline() {
+		return nil
+	}
+
+	resolve := func(step *PipelineStep, label string) (pipelineStep, error) {
+		if step.Command < 0 || step.Command >= len(commands) {
+			return pipelineStep{}, fmt.Errorf("%s: command index %d out of range", label, step.Command)
+		}
+		command := &commands[step.Command]
+		switch {
+		case command == c, command.isPipeline():
+			return pipelineStep{}, fmt.Errorf("%s: command %d is a pipeline", label, step.Command)
+		case command.Interactive:
+			return pipelineStep{}, fmt.Errorf("%s: command %d is interactive", label, step.Command)
+		case command.Stdin != StdinModeNone:
+			return pipelineStep{}, fmt.Errorf("%s: command %d reads stdin from messages or documents", label, step.Command)
+		case command.UploadDir != "":
+			return pipelineStep{}, fmt.Errorf("%s: command %d takes uploads", label, step.Command)
+		}
+		args, err := command.ResolveArgs(step.Params)
+		if err != nil {
+			return pipelineStep{}, fmt.Errorf("%s: %w", label, err)
+		}
+		return pipelineStep{
+			label:           label + ": " + command.Name,
+			index:           step.Command,
+			command:         command,
+			args:            args,
+			continueOnError: step.ContinueOnError,
+		}, nil
+	}
+
+	pipeline := make([]pipelineStep, 0, len(c.Steps)+1)
+	if c.Guard != nil {
+		step, err := resolve(c.Guard, "guard")
+		if err != nil {
+			return err
+		}
+		pipeline = append(pipeline, step)
+	}
+	for i := range c.Steps {
+		step, err := resolve(&c.Steps[i], "step "+strconv.Itoa(i+1))
+		if err != nil {
+			return err
+		}
+		pipeline = append(pipeline, step)
+	}
+	c.pipeline = pipeline
+	return nil
+}
+
+// describePipeline returns a short human-readable description of the pipeline's steps.
+func (c *Command) describePipeline() string {
+	var sb strings.Builder
+	if c.Guard != nil {
+		sb.WriteString("if [")
+		sb.WriteString(strconv.Itoa(c.Guard.Command))
+		sb.WriteString("] succeeds: ")
+	}
+	for i := range c.Steps {
+		if i > 0 {
+			sb.WriteString(" → ")
+		}
+		sb.WriteByte('[')
+		sb.WriteString(strconv.Itoa(c.Steps[i].Command))
+		sb.WriteByte(']')
+		if c.Steps[i].ContinueOnError {
+			sb.WriteByte('?')
+		}
+	}
+	return sb.String()
+}
+
+// pipelineStepOutcome is the outcome of a step in a pipeline run.
+type pipelineStepOutcome struct {
+	step     *pipelineStep
+	skipped  bool
+	result   CommandResult
+	attempts int
+	sections []OutputSection
+}
+
+// errStepAlreadyRunning is the error of a step whose command is already running the maximum number of times.
+var errStepAlreadyRunning = errors.New("the command is already running the maximum number of times")
+
+// runPipeline runs the steps of the job's pipeline command in order, and records their combined output and status.
+// The caller must have acquired a slot from the scheduler. The job is finished when the last step exits.
+//
+// The remaining steps are skipped when the guard step fails, a step fails without continuing on error,
+// or the job is canceled, which also stops the current step.
+func (j *job) runPipeline(ctx context.Context, logger *tslog.Logger) {
+	pipeline := j.command.pipeline
+	outcomes := make([]pipelineStepOutcome, len(pipeline))
+	var (
+		stopResult StopResult
+		failed     bool
+	)
+
+	for i := range pipeline {
+		step := &pipeline[i]
+		outcome := &outcomes[i]
+		outcome.step = step
+
+		if failed || j.ctx.Err() != nil {
+			outcome.skipped = true
+			continue
+		}
+
+		outcome.result, outcome.attempts, outcome.sections = j.runPipelineStep(ctx, step, logger)
+		if outcome.result.Stop.Step > 0 {
+			stopResult = outcome.result.Stop
+		}
+		if !outcome.result.Success() && !step.continueOnError {
+			failed = true
+		}
+	}
+
+	j.finish(stopResult)
+
+	canceled := j.ctx.Err() != nil
+	success := !failed && !canceled
+	logger.Info("Pipeline finished",
+		slog.Uint64("jobID", j.id),
+		slog.Int64("userID", j.userID),
+		slog.String("command", j.command.Name),
+		slog.Bool("success", success),
+	)
+
+	var sections []OutputSection
+	for i := range outcomes {
+		sections = append(sections, outcomes[i].sections...)
+	}
+
+	footer, summary := pipelineFooter(outcomes, canceled)
+	j.record(sections, footer, summary, success)
+}
+
+// runPipelineStep runs a step of the job's pipeline as a job of its own with the same ID,
+// and returns its result, the number of attempts, and its labelled output sections.
+func (j *job) runPipelineStep(ctx context.Context, step *pipelineStep, logger *tslog.Logger) (CommandResult, int, []OutputSection) {
+	if !step.command.acquire() {
+		return CommandResult{Err: errStepAlreadyRunning}, 0, nil
+	}
+	defer step.command.release()
+
+	sj := newJob(j.ctx, j.userID, j.chatID, step.command, step.index, step.args, nil)
+	sj.id = j.id
+	defer sj.cancel()
+
+	result, _, err := sj.runWithRetries(ctx, nil, nil, logger)
+	j.artifacts.add(&sj.artifacts)
+	if err != nil {
+		return CommandResult{Err: err}, len(sj.attempts), nil
+	}
+
+	sections := sj.outputSections(!result.Success())
+	labelled := sections[:0]
+	for _, section := range sections {
+		if len(section.Output) == 0 {
+			continue
+		}
+		if section.Label == "" {
+			section.Label = step.label
+		} else {
+			section.Label = step.label + " " + section.Label
+		}
+		labelled = append(labelled, section)
+	}
+	return result, len(sj.attempts), labelled
+}
+
+// pipelineFooter returns the MarkdownV2 footer of a pipeline run, with a status line for each step,
+// and a plain-text summary of the run.
+func pipelineFooter(outcomes []pipelineStepOutcome, canceled bool) (footer, summary string) {
+	var (
+		sb     strings.Builder
+		failed bool
+	)
+	for i := range outcomes {
+		outcome := &outcomes[i]
+		result := &outcome.result
+		switch {
+		case outcome.skipped:
+			sb.WriteString("⏭ ")
+		case result.Success():
+			sb.WriteString("✅ ")
+		case outcome.step.continueOnError:
+			sb.WriteString("⚠️ ")
+		default:
+			sb.WriteString("❌ ")
+			failed = true
+		}
+
+		var line strings.Builder
+		line.WriteString(outcome.step.label)
+		line.WriteString(": ")
+		switch {
+		case outcome.skipped:
+			line.WriteString("skipped")
+		case !result.Started:
+			line.WriteString(result.Err.Error())
+		default:
+			line.WriteString(result.Status())
+			if result.Err != nil {
+				line.WriteString(" (")
+				line.WriteString(result.Err.Error())
+				line.WriteByte(')')
+			}
+			line.WriteString(", ")
+			line.WriteString(result.Duration.Round(time.Millisecond).String())
+			if outcome.attempts > 1 {
+				line.WriteString(", ")
+				line.WriteString(strconv.Itoa(outcome.attempts))
+				line.WriteString(" attempts")
+			}
+		}
+		sb.WriteString(EscapeMarkdownV2Plaintext(line.String()))
+		sb.WriteByte('\n')
+	}
+
+	switch {
+	case canceled:
+		summary = "pipeline canceled"
+		sb.WriteString("❌ ")
+	case failed:
+		summary = "pipeline failed"
+		sb.WriteString("❌ ")
+	default:
+		summary = "pipeline succeeded"
+		sb.WriteString("✅ ")
+	}
+	sb.WriteString(summary)
+	return sb.String(), summary
+}
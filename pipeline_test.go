@@ -0,0 +1,320 @@
+package rcebot
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/database64128/cubic-rce-bot/tslog"
+)
+
+func TestUserCommandsByIDPipeline(t *testing.T) {
+	for _, c := range [...]struct {
+		name     string
+		commands []Command
+		wantErr  string
+	}{
+		{
+			name: "Valid",
+			commands: []Command{
+				{Name: "true"},
+				{Name: "echo", Args: []string{"${msg}"}, Params: []CommandParam{{Name: "msg", Type: CommandParamTypeString}}},
+				{
+					Name:  "deploy",
+					Guard: &PipelineStep{Command: 0},
+					Steps: []PipelineStep{
+						{Command: 1, Params: map[string]string{"msg": "hello"}, ContinueOnError: true},
+						{Command: 0},
+					},
+				},
+			},
+		},
+		{
+			name: "IndexOutOfRange",
+			commands: []Command{
+				{Name: "deploy", Steps: []PipelineStep{{Command: 1}}},
+			},
+			wantErr: "step 1: command index 1 out of range",
+		},
+		{
+			name: "Self",
+			commands: []Command{
+				{Name: "deploy", Steps: []PipelineStep{{Command: 0}}},
+			},
+			wantErr: "step 1: command 0 is a pipeline",
+		},
+		{
+			name: "Nested",
+			commands: []Command{
+				{Name: "true"},
+				{Name: "inner", Steps: []PipelineStep{{Command: 0}}},
+				{Name: "outer", Steps: []PipelineStep{{Command: 1}}},
+			},
+			wantErr: "step 1: command 1 is a pipeline",
+		},
+		{
+			name: "GuardOnly",
+			commands: []Command{
+				{Name: "true"},
+				{Name: "deploy", Guard: &PipelineStep{Command: 0}},
+			},
+			wantErr: "pipeline has a guard but no steps",
+		},
+		{
+			name: "GuardContinueOnError",
+			commands: []Command{
+				{Name: "true"},
+				{Name: "deploy", Guard: &PipelineStep{Command: 0, ContinueOnError: true}, Steps: []PipelineStep{{Command: 0}}},
+			},
+			wantErr: "the guard step of a pipeline cannot continue on error",
+		},
+		{
+			name: "PipelineArgs",
+			commands: []Command{
+				{Name: "true"},
+				{Name: "deploy", Args: []string{"now"}, Steps: []PipelineStep{{Command: 0}}},
+			},
+			wantErr: "pipelines cannot have arguments",
+		},
+		{
+			name: "StepStdin",
+			commands: []Command{
+				{Name: "cat", Stdin: StdinModeMessage},
+				{Name: "deploy", Steps: []PipelineStep{{Command: 0}}},
+			},
+			wantErr: "step 1: command 0 reads stdin from messages or documents",
+		},
+		{
+			name: "StepMissingParam",
+			commands: []Command{
+				{Name: "echo", Args: []string{"${msg}"}, Params: []CommandParam{{Name: "msg", Type: CommandParamTypeString}}},
+				{Name: "deploy", Steps: []PipelineStep{{Command: 0}}},
+			},
+			wantErr: `step 1: missing required parameter "msg"`,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			config := Config{
+				Users: []User{{ID: 1, Commands: c.commands}},
+			}
+			_, err := config.UserCommandsByID()
+			if c.wantErr == "" {
+				if err != nil {
+					t.Errorf("UserCommandsByID() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("UserCommandsByID() error = %v, want %q", err, c.wantErr)
+			}
+		})
+	}
+}
+
+// newTestPipelineCommands returns initialized commands of a user, with the following pipelines:
+//
+//   - [4] runs [0] and [2] if [1] succeeds
+//   - [5] runs [1], continuing on error, and [2]
+//   - [6] runs [1] and [0]
+//   - [7] runs [3] and [0]
+func newTestPipelineCommands(t *testing.T) []Command {
+	t.Helper()
+	config := Config{
+		Users: []User{{ID: 1, Commands: []Command{
+			{Name: "true"},
+			{Name: "false"},
+			{Name: "echo", Args: []string{"${msg}"}, Params: []CommandParam{{Name: "msg", Type: CommandParamTypeString}}},
+			{Name: "sleep", Args: []string{"10"}},
+			{
+				Name:  "guarded",
+				Guard: &PipelineStep{Command: 1},
+				Steps: []PipelineStep{{Command: 0}, {Command: 2, Params: map[string]string{"msg": "guarded"}}},
+			},
+			{
+				Name:  "continue",
+				Steps: []PipelineStep{{Command: 1, ContinueOnError: true}, {Command: 2, Params: map[string]string{"msg": "continued"}}},
+			},
+			{
+				Name:  "stop",
+				Steps: []PipelineStep{{Command: 1}, {Command: 0}},
+			},
+			{
+				Name:  "cancel",
+				Steps: []PipelineStep{{Command: 3}, {Command: 0}},
+			},
+		}}},
+	}
+	userCommandsByID, err := config.UserCommandsByID()
+	if err != nil {
+		t.Fatalf("config.UserCommandsByID() = %v", err)
+	}
+	return userCommandsByID[1]
+}
+
+func TestJobRunPipeline(t *testing.T) {
+	commands := newTestPipelineCommands(t)
+	logger := tslog.Config{}.NewLogger(io.Discard)
+
+	for _, c := range [...]struct {
+		name        string
+		index       int
+		wantSuccess bool
+		wantSummary string
+		wantFooter  []string
+		wantOutput  string
+	}{
+		{
+			name:        "GuardFailure",
+			index:       4,
+			wantSummary: "pipeline failed",
+			wantFooter: []string{
+				"❌ guard: false: exited with code 1",
+				"⏭ step 1: true: skipped",
+				"⏭ step 2: echo: skipped",
+				"❌ pipeline failed",
+			},
+		},
+		{
+			name:        "ContinueOnError",
+			index:       5,
+			wantSuccess: true,
+			wantSummary: "pipeline succeeded",
+			wantFooter: []string{
+				"⚠️ step 1: false: exited with code 1",
+				"✅ step 2: echo: exited with code 0",
+				"✅ pipeline succeeded",
+			},
+			wantOutput: "continued\n",
+		},
+		{
+			name:        "StepFailure",
+			index:       6,
+			wantSummary: "pipeline failed",
+			wantFooter: []string{
+				"❌ step 1: false: exited with code 1",
+				"⏭ step 2: true: skipped",
+				"❌ pipeline failed",
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			j := newJob(t.Context(), 1, 2, &commands[c.index], c.index, nil, nil)
+			j.runPipeline(t.Context(), logger)
+
+			if j.success != c.wantSuccess {
+				t.Errorf("j.success = %v, want %v", j.success, c.wantSuccess)
+			}
+			if j.summary != c.wantSummary {
+				t.Errorf("j.summary = %q, want %q", j.summary, c.wantSummary)
+			}
+			lines := strings.Split(j.footer, "\n")
+			if len(lines) != len(c.wantFooter) {
+				t.Fatalf("j.footer = %q, want %d lines", j.footer, len(c.wantFooter))
+			}
+			for i, want := range c.wantFooter {
+				if !strings.HasPrefix(lines[i], EscapeMarkdownV2Plaintext(want)) {
+					t.Errorf("footer line %d = %q, want prefix %q", i, lines[i], EscapeMarkdownV2Plaintext(want))
+				}
+			}
+
+			var output strings.Builder
+			for _, section := range j.sections {
+				output.Write(section.Output)
+			}
+			if got := output.String(); got != c.wantOutput {
+				t.Errorf("output = %q, want %q", got, c.wantOutput)
+			}
+			if c.wantOutput != "" && j.sections[0].Label != "step 2: echo" {
+				t.Errorf("output label = %q, want %q", j.sections[0].Label, "step 2: echo")
+			}
+		})
+	}
+}
+
+func TestJobRunPipelineCancel(t *testing.T) {
+	commands := newTestPipelineCommands(t)
+	logger := tslog.Config{}.NewLogger(io.Discard)
+
+	j := newJob(t.Context(), 1, 2, &commands[7], 7, nil, nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		j.runPipeline(t.Context(), logger)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for commands[3].running.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Give the step time to start its process.
+	time.Sleep(100 * time.Millisecond)
+	j.cancel()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the pipeline did not stop after being canceled")
+	}
+
+	if j.success {
+		t.Error("j.success = true, want false")
+	}
+	if j.summary != "pipeline canceled" {
+		t.Errorf("j.summary = %q, want %q", j.summary, "pipeline canceled")
+	}
+	if j.stopResult.Step == 0 {
+		t.Error("j.stopResult.Step = 0, want the running step to be stopped")
+	}
+	lines := strings.Split(j.footer, "\n")
+	if len(lines) != 3 {
+		t.Fatalf("j.footer = %q, want 3 lines", j.footer)
+	}
+	if !strings.HasPrefix(lines[0], "❌ step 1: sleep: killed by SIGINT") {
+		t.Errorf("footer line 0 = %q, want the step to be stopped", lines[0])
+	}
+	if want := EscapeMarkdownV2Plaintext("⏭ step 2: true: skipped"); lines[1] != want {
+		t.Errorf("footer line 1 = %q, want %q", lines[1], want)
+	}
+	if want := "❌ pipeline canceled"; lines[2] != want {
+		t.Errorf("footer line 2 = %q, want %q", lines[2], want)
+	}
+}
+
+func TestPipelineFooter(t *testing.T) {
+	steps := []pipelineStep{
+		{label: "guard: test"},
+		{label: "step 1: make", continueOnError: true},
+		{label: "step 2: deploy"},
+		{label: "step 3: notify"},
+	}
+	outcomes := []pipelineStepOutcome{
+		{step: &steps[0], result: CommandResult{Started: true, Duration: 250 * time.Millisecond}, attempts: 1},
+		{step: &steps[1], result: CommandResult{Started: true, ExitCode: 2, Duration: 1500 * time.Millisecond}, attempts: 3},
+		{step: &steps[2], result: CommandResult{Err: errStepAlreadyRunning}},
+		{step: &steps[3], skipped: true},
+	}
+
+	footer, summary := pipelineFooter(outcomes, false)
+	const wantFooter = "✅ guard: test: exited with code 0, 250ms\n" +
+		"⚠️ step 1: make: exited with code 2, 1\\.5s, 3 attempts\n" +
+		"❌ step 2: deploy: the command is already running the maximum number of times\n" +
+		"⏭ step 3: notify: skipped\n" +
+		"❌ pipeline failed"
+	if footer != wantFooter {
+		t.Errorf("pipelineFooter() footer = %q, want %q", footer, wantFooter)
+	}
+	if summary != "pipeline failed" {
+		t.Errorf("pipelineFooter() summary = %q, want %q", summary, "pipeline failed")
+	}
+
+	footer, summary = pipelineFooter(outcomes[:2], false)
+	if !strings.HasSuffix(footer, "\n✅ pipeline succeeded") || summary != "pipeline succeeded" {
+		t.Errorf("pipelineFooter() = %q, %q, want success", footer, summary)
+	}
+
+	footer, summary = pipelineFooter(outcomes[:1], true)
+	if !strings.HasSuffix(footer, "\n❌ pipeline canceled") || summary != "pipeline canceled" {
+		t.Errorf("pipelineFooter() = %q, %q, want canceled", footer, summary)
+	}
+}
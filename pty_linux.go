@@ -0,0 +1,86 @@
+package rcebot
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+const ptySupported = true
+
+// winsize is struct winsize from <asm-generic/termios.h>.
+type winsize struct {
+	row    uint16
+	col    uint16
+	xpixel uint16
+	ypixel uint16
+}
+
+// openPTY allocates a pseudo-terminal of rows by cols characters,
+// and returns its master and slave ends.
+func openPTY(rows, cols uint16) (master, tty *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		unlock int32
+		n      uint32
+	)
+	if err = ioctlPointer(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to unlock pty: %w", err)
+	}
+	if err = ioctlPointer(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to get pty number: %w", err)
+	}
+
+	tty, err = os.OpenFile("/dev/pts/"+strconv.FormatUint(uint64(n), 10), os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+
+	ws := winsize{row: rows, col: cols}
+	if err = ioctlPointer(tty, syscall.TIOCSWINSZ, unsafe.Pointer(&ws)); err != nil {
+		tty.Close()
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to set pty window size: %w", err)
+	}
+
+	return master, tty, nil
+}
+
+// ioctlPointer performs an ioctl on f with a pointer argument.
+func ioctlPointer(f *os.File, req uint, arg unsafe.Pointer) error {
+	rawConn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var errno syscall.Errno
+	if err := rawConn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(req), uintptr(arg))
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return os.NewSyscallError("ioctl", errno)
+	}
+	return nil
+}
+
+// setPTYSysProcAttr makes the command start in a new session with tty as its controlling terminal.
+// tty must be the command's standard input.
+func setPTYSysProcAttr(cmd *exec.Cmd) {
+	// A session leader is already the leader of its own process group.
+	cmd.SysProcAttr.Setpgid = false
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package rcebot
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+const ptySupported = false
+
+func openPTY(_, _ uint16) (master, tty *os.File, err error) {
+	return nil, nil, errors.ErrUnsupported
+}
+
+func setPTYSysProcAttr(_ *exec.Cmd) {}
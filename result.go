@@ -0,0 +1,172 @@
+package rcebot
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/database64128/cubic-rce-bot/tslog"
+)
+
+// CommandResult describes how an execution of a command ended.
+type CommandResult struct {
+	// Started is whether the command was started.
+	// If false, only [CommandResult.Err] is meaningful.
+	Started bool
+
+	// ExitCode is the exit code of the command, or -1 if it was killed by a signal.
+	ExitCode int
+
+	// Signal is the signal that killed the command, or 0 if it exited on its own.
+	Signal Signal
+
+	// Duration is the wall time from starting the command to its exit.
+	Duration time.Duration
+
+	// UserTime is the user CPU time of the command.
+	UserTime time.Duration
+
+	// SystemTime is the system CPU time of the command.
+	SystemTime time.Duration
+
+	// MaxRSS is the maximum resident set size of the command in bytes, or 0 if unavailable.
+	MaxRSS int64
+
+	// Stop describes whether and how the bot stopped the command.
+	Stop StopResult
+
+	// Limit describes the resource limit that most likely caused the command to die, if any.
+	Limit string
+
+	// Err is the error that prevented the command from starting or from completing normally,
+	// such as the reason the bot stopped it. A non-zero exit code alone is not an error here.
+	Err error
+}
+
+// newCommandResult returns the result of a command that exited with state after running for duration.
+// state is nil if the command was not started. err is the error returned by [Command.run].
+func newCommandResult(state *os.ProcessState, duration time.Duration, stop StopResult, err error) CommandResult {
+	if state == nil {
+		return CommandResult{Stop: stop, Err: err}
+	}
+
+	// The exit status is reported separately.
+	if _, ok := errors.AsType[*exec.ExitError](err); ok {
+		err = nil
+	}
+
+	return CommandResult{
+		Started:    true,
+		ExitCode:   state.ExitCode(),
+		Signal:     processSignal(state),
+		Duration:   duration,
+		UserTime:   state.UserTime(),
+		SystemTime: state.SystemTime(),
+		MaxRSS:     processMaxRSS(state),
+		Stop:       stop,
+		Err:        err,
+	}
+}
+
+// Success returns whether the command was started, exited with code 0, and no error occurred.
+func (r *CommandResult) Success() bool {
+	return r.Started && r.ExitCode == 0 && r.Err == nil
+}
+
+// Status returns a short human-readable description of how the command exited.
+func (r *CommandResult) Status() string {
+	switch {
+	case !r.Started:
+		return "failed to start"
+	case r.Signal != 0 && r.Limit != "":
+		return "killed by " + r.Signal.String() + ": " + r.Limit
+	case r.Signal != 0:
+		return "killed by " + r.Signal.String()
+	default:
+		return "exited with code " + strconv.Itoa(r.ExitCode)
+	}
+}
+
+// Usage returns a short human-readable summary of the wall time and resource usage of the command.
+func (r *CommandResult) Usage() string {
+	var sb strings.Builder
+	sb.WriteString(r.Duration.Round(time.Millisecond).String())
+	sb.WriteString(" wall, ")
+	sb.WriteString(r.UserTime.Round(time.Millisecond).String())
+	sb.WriteString(" user, ")
+	sb.WriteString(r.SystemTime.Round(time.Millisecond).String())
+	sb.WriteString(" sys")
+	if r.MaxRSS > 0 {
+		sb.WriteString(", ")
+		sb.WriteString(formatByteSize(r.MaxRSS))
+		sb.WriteString(" max RSS")
+	}
+	return sb.String()
+}
+
+// Footer returns the MarkdownV2 footer appended to the response, with the error, if any,
+// followed by the exit status and resource usage if the command was started.
+func (r *CommandResult) Footer() string {
+	var sb strings.Builder
+	if r.Err != nil {
+		sb.WriteString(EscapeMarkdownV2Plaintext(r.Err.Error()))
+		sb.WriteByte('\n')
+	}
+	if r.Started {
+		if r.Success() {
+			sb.WriteString("✅ ")
+		} else {
+			sb.WriteString("❌ ")
+		}
+		sb.WriteString(EscapeMarkdownV2Plaintext(r.Status()))
+		sb.WriteString("\n⏱ ")
+		sb.WriteString(EscapeMarkdownV2Plaintext(r.Usage()))
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// LogAttrs returns the result as structured log attributes.
+func (r *CommandResult) LogAttrs() []slog.Attr {
+	attrs := make([]slog.Attr, 0, 8)
+	if r.Started {
+		attrs = append(attrs,
+			slog.Int("exitCode", r.ExitCode),
+			slog.Duration("duration", r.Duration),
+			slog.Duration("userTime", r.UserTime),
+			slog.Duration("systemTime", r.SystemTime),
+			slog.Int64("maxRSS", r.MaxRSS),
+		)
+		if r.Signal != 0 {
+			attrs = append(attrs, slog.String("signal", r.Signal.String()))
+		}
+		if r.Limit != "" {
+			attrs = append(attrs, slog.String("limit", r.Limit))
+		}
+	}
+	if r.Stop.Step > 0 {
+		attrs = append(attrs, slog.String("stop", r.Stop.String()))
+	}
+	if r.Err != nil {
+		attrs = append(attrs, tslog.Err(r.Err))
+	}
+	return attrs
+}
+
+// formatByteSize formats n bytes in the largest binary unit that keeps the value at least 1.
+func formatByteSize(n int64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	value := float64(n)
+	i := -1
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + " " + units[i:i+1] + "iB"
+}
@@ -0,0 +1,64 @@
+package rcebot_test
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	rcebot "github.com/database64128/cubic-rce-bot"
+)
+
+func TestCommandResultFooter(t *testing.T) {
+	for _, c := range [...]struct {
+		name   string
+		result rcebot.CommandResult
+		want   string
+	}{
+		{
+			name: "Success",
+			result: rcebot.CommandResult{
+				Started:    true,
+				Duration:   1234567 * time.Microsecond,
+				UserTime:   10 * time.Millisecond,
+				SystemTime: 2 * time.Millisecond,
+				MaxRSS:     3 << 20,
+			},
+			want: "✅ exited with code 0\n⏱ 1\\.235s wall, 10ms user, 2ms sys, 3\\.0 MiB max RSS",
+		},
+		{
+			name: "ExitCode",
+			result: rcebot.CommandResult{
+				Started:  true,
+				ExitCode: 2,
+				Duration: time.Second,
+			},
+			want: "❌ exited with code 2\n⏱ 1s wall, 0s user, 0s sys",
+		},
+		{
+			name: "Signaled",
+			result: rcebot.CommandResult{
+				Started:  true,
+				ExitCode: -1,
+				Signal:   rcebot.Signal(syscall.SIGKILL),
+				Duration: time.Second,
+				MaxRSS:   512,
+				Err:      errors.New("stopped by SIGKILL at step 2 of 2"),
+			},
+			want: "stopped by SIGKILL at step 2 of 2\n❌ killed by SIGKILL\n⏱ 1s wall, 0s user, 0s sys, 512 B max RSS",
+		},
+		{
+			name: "NotStarted",
+			result: rcebot.CommandResult{
+				Err: errors.New("exec: \"foo\": executable file not found in $PATH"),
+			},
+			want: "exec: \"foo\": executable file not found in $PATH",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.result.Footer(); got != c.want {
+				t.Errorf("Footer() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package rcebot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/database64128/cubic-rce-bot/jsoncfg"
+	"github.com/database64128/cubic-rce-bot/tslog"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	// DefaultRetryBackoff is the default delay before the first retry of a failed command.
+	DefaultRetryBackoff = 5 * time.Second
+
+	// DefaultMaxRetryBackoff is the default maximum delay between retries of a failed command.
+	DefaultMaxRetryBackoff = 5 * time.Minute
+)
+
+// RetryPolicy is the policy for retrying failed executions of a command.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first. It must be at least 2.
+	MaxAttempts int `json:"maxAttempts"`
+
+	// Backoff is the delay before the first retry, which doubles after each retry up to [RetryPolicy.MaxBackoff].
+	// Each delay is shortened by a random jitter of up to half its length.
+	//
+	// If zero, [DefaultRetryBackoff] is used.
+	Backoff jsoncfg.Duration `json:"backoff,omitzero"`
+
+	// MaxBackoff is the maximum delay between retries.
+	//
+	// If zero, [DefaultMaxRetryBackoff] is used.
+	MaxBackoff jsoncfg.Duration `json:"maxBackoff,omitzero"`
+
+	// ExitCodes is the optional list of exit codes that are retried.
+	//
+	// If empty, all failures are retried, including timeouts and commands killed by signals.
+	// Canceled executions are never retried.
+	ExitCodes []int `json:"exitCodes,omitzero"`
+}
+
+// init validates the retry policy and fills in the defaults.
+func (p *RetryPolicy) init() error {
+	if p.MaxAttempts < 2 {
+		return fmt.Errorf("max attempts %d is less than 2", p.MaxAttempts)
+	}
+	if p.Backoff < 0 || p.MaxBackoff < 0 {
+		return errors.New("retry backoff must not be negative")
+	}
+	if p.Backoff == 0 {
+		p.Backoff = jsoncfg.Duration(DefaultRetryBackoff)
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = jsoncfg.Duration(DefaultMaxRetryBackoff)
+	}
+	if p.Backoff > p.MaxBackoff {
+		return fmt.Errorf("retry backoff %s is greater than the max backoff %s", p.Backoff.Value(), p.MaxBackoff.Value())
+	}
+	return nil
+}
+
+// retryable returns whether the failed result may be retried.
+func (p *RetryPolicy) retryable(result *CommandResult) bool {
+	if result.Success() {
+		return false
+	}
+	if len(p.ExitCodes) == 0 {
+		return true
+	}
+	return result.Started && result.Signal == 0 && slices.Contains(p.ExitCodes, result.ExitCode)
+}
+
+// delay returns the delay before the retry that follows the given number of attempts, with jitter applied.
+func (p *RetryPolicy) delay(attempts int) time.Duration {
+	d, maxBackoff := p.Backoff.Value(), p.MaxBackoff.Value()
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, maxBackoff)
+	return d - rand.N(d/2+1)
+}
+
+// describe returns a short human-readable description of the retry policy.
+func (p *RetryPolicy) describe() string {
+	var sb strings.Builder
+	sb.WriteString("up to ")
+	sb.WriteString(strconv.Itoa(p.MaxAttempts))
+	sb.WriteString(" attempts")
+	if len(p.ExitCodes) > 0 {
+		sb.WriteString(" on exit codes ")
+		for i, code := range p.ExitCodes {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(strconv.Itoa(code))
+		}
+	}
+	sb.WriteString(", backoff from ")
+	sb.WriteString(p.Backoff.Value().String())
+	sb.WriteString(" to ")
+	sb.WriteString(p.MaxBackoff.Value().String())
+	return sb.String()
+}
+
+// initRetry validates the retry policy of the command.
+func (c *Command) initRetry() error {
+	if c.Retry == nil {
+		return nil
+	}
+	if c.Interactive || c.Stream {
+		return errors.New("interactive and streaming commands cannot be retried")
+	}
+	if err := c.Retry.init(); err != nil {
+		return fmt.Errorf("retry: %w", err)
+	}
+	return nil
+}
+
+// errRetriesCanceled is the error of a failed attempt whose retry was canceled.
+var errRetriesCanceled = errors.New("canceled before the next attempt")
+
+// willRetry returns whether the job's command will be retried after the attempt with the failed result.
+func (j *job) willRetry(result *CommandResult) bool {
+	retry := j.command.Retry
+	return retry != nil && len(j.attempts) < retry.MaxAttempts && j.ctx.Err() == nil && retry.retryable(result)
+}
+
+// runWithRetries is like [job.run], but retries the command according to its retry policy,
+// and records the result of each attempt in the job. Only the output of the last attempt is kept.
+// Canceling the job stops the current attempt, or the wait for the next one.
+func (j *job) runWithRetries(ctx context.Context, b *bot.Bot, message *models.Message, logger *tslog.Logger) (CommandResult, *outputStreamer, error) {
+	for {
+		j.attempts = append(j.attempts, CommandResult{})
+		result, streamer, err := j.run(ctx, b, message, logger)
+		if err != nil {
+			j.attempts = j.attempts[:len(j.attempts)-1]
+			j.finish(StopResult{})
+			return CommandResult{}, nil, err
+		}
+		j.attempts[len(j.attempts)-1] = result
+
+		if !j.willRetry(&result) {
+			j.finish(result.Stop)
+			return result, streamer, nil
+		}
+
+		delay := j.command.Retry.delay(len(j.attempts))
+		logger.Info("Retrying command",
+			slog.Uint64("jobID", j.id),
+			slog.Int64("userID", j.userID),
+			slog.String("command", j.command.Name),
+			slog.Int("attempt", len(j.attempts)+1),
+			slog.Duration("delay", delay),
+		)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-j.ctx.Done():
+			timer.Stop()
+			j.finish(result.Stop)
+			if result.Err == nil {
+				result.Err = errRetriesCanceled
+			} else {
+				result.Err = fmt.Errorf("%w; %w", result.Err, errRetriesCanceled)
+			}
+			j.attempts[len(j.attempts)-1] = result
+			return result, streamer, nil
+		}
+
+		// Each attempt starts with empty output and a fresh scratch directory.
+		j.resetOutput()
+		j.artifacts.close()
+	}
+}
+
+// attemptsFooter returns the MarkdownV2 lines listing the status of each attempt before the last one,
+// whose status is reported by the footer of the result, or an empty string if the command was not retried.
+func attemptsFooter(attempts []CommandResult) string {
+	if len(attempts) < 2 {
+		return ""
+	}
+	total := strconv.Itoa(len(attempts))
+	var sb strings.Builder
+	for i := range attempts[:len(attempts)-1] {
+		result := &attempts[i]
+		var line strings.Builder
+		line.WriteString("attempt ")
+		line.WriteString(strconv.Itoa(i + 1))
+		line.WriteByte('/')
+		line.WriteString(total)
+		line.WriteString(": ")
+		if !result.Started {
+			line.WriteString(result.Err.Error())
+		} else {
+			line.WriteString(result.Status())
+			if result.Err != nil {
+				line.WriteString(" (")
+				line.WriteString(result.Err.Error())
+				line.WriteByte(')')
+			}
+			line.WriteString(", ")
+			line.WriteString(result.Duration.Round(time.Millisecond).String())
+		}
+		sb.WriteString("🔁 ")
+		sb.WriteString(EscapeMarkdownV2Plaintext(line.String()))
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
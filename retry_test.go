@@ -0,0 +1,197 @@
+package rcebot
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/database64128/cubic-rce-bot/jsoncfg"
+)
+
+func TestUserCommandsByIDRetry(t *testing.T) {
+	for _, c := range [...]struct {
+		name     string
+		commands []Command
+		wantErr  string
+	}{
+		{
+			name:     "Defaults",
+			commands: []Command{{Name: "certbot", Retry: &RetryPolicy{MaxAttempts: 3}}},
+		},
+		{
+			name: "ExitCodes",
+			commands: []Command{{Name: "certbot", Retry: &RetryPolicy{
+				MaxAttempts: 5,
+				Backoff:     jsoncfg.Duration(time.Second),
+				MaxBackoff:  jsoncfg.Duration(time.Minute),
+				ExitCodes:   []int{1, 75},
+			}}},
+		},
+		{
+			name:     "SingleAttempt",
+			commands: []Command{{Name: "certbot", Retry: &RetryPolicy{MaxAttempts: 1}}},
+			wantErr:  "retry: max attempts 1 is less than 2",
+		},
+		{
+			name:     "NegativeBackoff",
+			commands: []Command{{Name: "certbot", Retry: &RetryPolicy{MaxAttempts: 3, Backoff: jsoncfg.Duration(-time.Second)}}},
+			wantErr:  "retry: retry backoff must not be negative",
+		},
+		{
+			name:     "BackoffOverMax",
+			commands: []Command{{Name: "certbot", Retry: &RetryPolicy{MaxAttempts: 3, Backoff: jsoncfg.Duration(time.Hour)}}},
+			wantErr:  "retry: retry backoff 1h0m0s is greater than the max backoff 5m0s",
+		},
+		{
+			name:     "Stream",
+			commands: []Command{{Name: "certbot", Stream: true, Retry: &RetryPolicy{MaxAttempts: 3}}},
+			wantErr:  "interactive and streaming commands cannot be retried",
+		},
+		{
+			name: "Pipeline",
+			commands: []Command{
+				{Name: "certbot"},
+				{Name: "renew", Steps: []PipelineStep{{Command: 0}}, Retry: &RetryPolicy{MaxAttempts: 3}},
+			},
+			wantErr: "pipelines cannot have arguments, scripts, parameters, artifacts, uploads, or retries of their own",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			config := Config{
+				Users: []User{{ID: 1, Commands: c.commands}},
+			}
+			_, err := config.UserCommandsByID()
+			if c.wantErr == "" {
+				if err != nil {
+					t.Errorf("UserCommandsByID() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("UserCommandsByID() error = %v, want %q", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{
+		MaxAttempts: 10,
+		Backoff:     jsoncfg.Duration(time.Second),
+		MaxBackoff:  jsoncfg.Duration(10 * time.Second),
+	}
+	if err := p.init(); err != nil {
+		t.Fatalf("p.init() = %v", err)
+	}
+
+	for _, c := range [...]struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{6, 10 * time.Second},
+		{100, 10 * time.Second},
+	} {
+		// Each delay is shortened by a random jitter of up to half its length.
+		lower := c.want - c.want/2
+		minDelay, maxDelay := c.want, time.Duration(0)
+		for range 1000 {
+			d := p.delay(c.attempts)
+			if d < lower || d > c.want {
+				t.Fatalf("p.delay(%d) = %s, want within [%s, %s]", c.attempts, d, lower, c.want)
+			}
+			minDelay, maxDelay = min(minDelay, d), max(maxDelay, d)
+		}
+		if minDelay == maxDelay {
+			t.Errorf("p.delay(%d) = %s every time, want jitter", c.attempts, minDelay)
+		}
+	}
+}
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	var (
+		success  = CommandResult{Started: true}
+		exited1  = CommandResult{Started: true, ExitCode: 1}
+		exited75 = CommandResult{Started: true, ExitCode: 75}
+		killed   = CommandResult{Started: true, ExitCode: -1, Signal: Signal(syscall.SIGKILL)}
+		timedOut = CommandResult{
+			Started:  true,
+			ExitCode: -1,
+			Signal:   Signal(syscall.SIGINT),
+			Stop:     StopResult{Step: 1, Signal: Signal(syscall.SIGINT)},
+			Err:      errors.New("timed out after 1s"),
+		}
+		unstarted = CommandResult{Err: errors.New("exec: \"missing\": executable file not found in $PATH")}
+	)
+
+	for _, c := range [...]struct {
+		name      string
+		exitCodes []int
+		result    *CommandResult
+		want      bool
+	}{
+		{"AnySuccess", nil, &success, false},
+		{"AnyExitCode", nil, &exited1, true},
+		{"AnySignal", nil, &killed, true},
+		{"AnyTimeout", nil, &timedOut, true},
+		{"AnyUnstarted", nil, &unstarted, true},
+		{"ExitCodesSuccess", []int{0, 75}, &success, false},
+		{"ExitCodesMatch", []int{75}, &exited75, true},
+		{"ExitCodesMismatch", []int{75}, &exited1, false},
+		{"ExitCodesSignal", []int{75}, &killed, false},
+		{"ExitCodesSignalExitCode", []int{-1}, &killed, false},
+		{"ExitCodesTimeout", []int{-1, 75}, &timedOut, false},
+		{"ExitCodesUnstarted", []int{0, 75}, &unstarted, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			p := RetryPolicy{MaxAttempts: 3, ExitCodes: c.exitCodes}
+			if got := p.retryable(c.result); got != c.want {
+				t.Errorf("p.retryable() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestAttemptsFooter(t *testing.T) {
+	attempts := []CommandResult{
+		{Started: true, ExitCode: 1, Duration: 1500 * time.Millisecond},
+		{
+			Started:  true,
+			ExitCode: -1,
+			Signal:   Signal(syscall.SIGKILL),
+			Duration: 2*time.Second + 345678*time.Microsecond,
+			Err:      errors.New("timed out after 2s"),
+		},
+		{Err: errors.New("exec: \"missing\": executable file not found in $PATH")},
+		{Started: true},
+	}
+
+	for _, c := range [...]struct {
+		name     string
+		attempts []CommandResult
+		want     string
+	}{
+		{"None", nil, ""},
+		{"Single", attempts[:1], ""},
+		{"Two", attempts[:2], "🔁 attempt 1/2: exited with code 1, 1\\.5s\n"},
+		{
+			"Four",
+			attempts,
+			"🔁 attempt 1/4: exited with code 1, 1\\.5s\n" +
+				"🔁 attempt 2/4: killed by SIGKILL \\(timed out after 2s\\), 2\\.346s\n" +
+				"🔁 attempt 3/4: exec: \"missing\": executable file not found in $PATH\n",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := attemptsFooter(c.attempts); got != c.want {
+				t.Errorf("attemptsFooter() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package rcebot
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/database64128/cubic-rce-bot/jsoncfg"
+)
+
+// ResourceLimits is a set of resource limits applied to a command before it is executed.
+//
+// Each limit is set as both the soft and the hard limit, so the command cannot raise it.
+// A limit must not exceed the bot's own hard limit. Unset limits are inherited from the bot.
+type ResourceLimits struct {
+	// AddressSpace is the maximum size of the virtual memory of each process in bytes (RLIMIT_AS).
+	AddressSpace *uint64 `json:"addressSpace,omitzero"`
+
+	// CPUTime is the maximum CPU time of each process, rounded up to whole seconds (RLIMIT_CPU).
+	// The process receives SIGXCPU when it reaches the limit, and SIGKILL a second later.
+	CPUTime *jsoncfg.Duration `json:"cpuTime,omitzero"`
+
+	// OpenFiles is the maximum number of open file descriptors of each process (RLIMIT_NOFILE).
+	OpenFiles *uint64 `json:"openFiles,omitzero"`
+
+	// Processes is the maximum number of processes (RLIMIT_NPROC).
+	//
+	// It counts all processes of the user the command runs as, not just the command and its descendants.
+	Processes *uint64 `json:"processes,omitzero"`
+
+	// CoreSize is the maximum size of a core dump in bytes (RLIMIT_CORE).
+	// Set it to 0 to disable core dumps.
+	CoreSize *uint64 `json:"coreSize,omitzero"`
+
+	// FileSize is the maximum size of a file the process may create or extend in bytes (RLIMIT_FSIZE).
+	FileSize *uint64 `json:"fileSize,omitzero"`
+}
+
+// isZero returns whether no limit is set.
+func (l *ResourceLimits) isZero() bool {
+	return *l == ResourceLimits{}
+}
+
+// merge returns l with unset limits taken from defaults.
+func (l ResourceLimits) merge(defaults ResourceLimits) ResourceLimits {
+	return ResourceLimits{
+		AddressSpace: cmpOrPointer(l.AddressSpace, defaults.AddressSpace),
+		CPUTime:      cmpOrPointer(l.CPUTime, defaults.CPUTime),
+		OpenFiles:    cmpOrPointer(l.OpenFiles, defaults.OpenFiles),
+		Processes:    cmpOrPointer(l.Processes, defaults.Processes),
+		CoreSize:     cmpOrPointer(l.CoreSize, defaults.CoreSize),
+		FileSize:     cmpOrPointer(l.FileSize, defaults.FileSize),
+	}
+}
+
+// cmpOrPointer returns p if it is not nil, or fallback otherwise.
+func cmpOrPointer[T any](p, fallback *T) *T {
+	if p != nil {
+		return p
+	}
+	return fallback
+}
+
+// cpuSeconds returns the CPU time limit in whole seconds, rounded up.
+func (l *ResourceLimits) cpuSeconds() uint64 {
+	return uint64((l.CPUTime.Value() + time.Second - 1) / time.Second)
+}
+
+// initRlimits merges the command's resource limits with the global defaults,
+// and resolves them into the limits applied by the exec helper.
+func (c *Command) initRlimits() error {
+	limits := c.defaultLimits
+	if c.Limits != nil {
+		limits = c.Limits.merge(limits)
+	}
+	c.limits = limits
+	c.rlimits = nil
+
+	if limits.isZero() {
+		return nil
+	}
+
+	if !rlimitSupported {
+		return fmt.Errorf("resource limits are not supported on %s", runtime.GOOS)
+	}
+
+	if limits.CPUTime != nil && limits.CPUTime.Value() <= 0 {
+		return fmt.Errorf("CPU time limit %s is not positive", limits.CPUTime.Value())
+	}
+
+	rlimits, err := limits.rlimits()
+	if err != nil {
+		return err
+	}
+	c.rlimits = rlimits
+	return nil
+}
@@ -0,0 +1,98 @@
+package rcebot
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+const rlimitSupported = true
+
+// rlimits returns the limits to apply to the command,
+// failing if any of them exceeds the bot's own hard limit.
+func (l *ResourceLimits) rlimits() ([]execHelperRlimit, error) {
+	var rlimits []execHelperRlimit
+
+	add := func(name string, resource int, value uint64, extra uint64) error {
+		var current syscall.Rlimit
+		if err := syscall.Getrlimit(resource, &current); err != nil {
+			return fmt.Errorf("failed to get %s limit: %w", name, err)
+		}
+		if value > current.Max {
+			return fmt.Errorf("%s limit %d exceeds the bot's hard limit %d", name, value, current.Max)
+		}
+		rlimits = append(rlimits, execHelperRlimit{
+			Resource: resource,
+			Cur:      value,
+			Max:      min(value+extra, current.Max),
+		})
+		return nil
+	}
+
+	if l.AddressSpace != nil {
+		if err := add("address space", syscall.RLIMIT_AS, *l.AddressSpace, 0); err != nil {
+			return nil, err
+		}
+	}
+	if l.CPUTime != nil {
+		// Leave a second between the soft and the hard limit, so that SIGXCPU is delivered before SIGKILL.
+		if err := add("CPU time", syscall.RLIMIT_CPU, l.cpuSeconds(), 1); err != nil {
+			return nil, err
+		}
+	}
+	if l.OpenFiles != nil {
+		if err := add("open files", syscall.RLIMIT_NOFILE, *l.OpenFiles, 0); err != nil {
+			return nil, err
+		}
+	}
+	if l.Processes != nil {
+		if err := add("processes", rlimitNPROC, *l.Processes, 0); err != nil {
+			return nil, err
+		}
+	}
+	if l.CoreSize != nil {
+		if err := add("core size", syscall.RLIMIT_CORE, *l.CoreSize, 0); err != nil {
+			return nil, err
+		}
+	}
+	if l.FileSize != nil {
+		if err := add("file size", syscall.RLIMIT_FSIZE, *l.FileSize, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	return rlimits, nil
+}
+
+// setRlimits applies the limits to the current process.
+func setRlimits(rlimits []execHelperRlimit) error {
+	for _, rlimit := range rlimits {
+		if err := syscall.Setrlimit(rlimit.Resource, &syscall.Rlimit{
+			Cur: rlimit.Cur,
+			Max: rlimit.Max,
+		}); err != nil {
+			return fmt.Errorf("failed to set resource limit %d: %w", rlimit.Resource, err)
+		}
+	}
+	return nil
+}
+
+// limitCause returns a description of the resource limit that most likely caused the command to die,
+// or an empty string if none did.
+func (l *ResourceLimits) limitCause(result *CommandResult) string {
+	switch {
+	case l.CPUTime != nil && (result.Signal.Value() == syscall.SIGXCPU ||
+		result.Signal.Value() == syscall.SIGKILL && result.Stop.Step == 0 &&
+			result.UserTime+result.SystemTime >= time.Duration(l.cpuSeconds())*time.Second):
+		return "CPU time limit of " + (time.Duration(l.cpuSeconds()) * time.Second).String() + " exceeded"
+
+	case l.FileSize != nil && result.Signal.Value() == syscall.SIGXFSZ:
+		return "file size limit of " + formatByteSize(int64(*l.FileSize)) + " exceeded"
+
+	case l.AddressSpace != nil && (result.Signal.Value() == syscall.SIGSEGV ||
+		result.Signal.Value() == syscall.SIGBUS ||
+		result.Signal.Value() == syscall.SIGABRT):
+		return "possibly exceeded the address space limit of " + formatByteSize(int64(*l.AddressSpace))
+	}
+	return ""
+}
@@ -0,0 +1,134 @@
+package rcebot
+
+import (
+	"math"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/database64128/cubic-rce-bot/jsoncfg"
+)
+
+func TestCommandInitRlimits(t *testing.T) {
+	var current syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &current); err != nil {
+		t.Fatal(err)
+	}
+	openFiles := min(current.Max, 64)
+	cpuTime := jsoncfg.Duration(1500 * time.Millisecond)
+
+	c := Command{
+		Name:          "true",
+		Limits:        &ResourceLimits{CPUTime: &cpuTime},
+		defaultLimits: ResourceLimits{OpenFiles: &openFiles},
+	}
+	if err := c.initRlimits(); err != nil {
+		t.Fatalf("c.initRlimits() = %v", err)
+	}
+	if c.limits.OpenFiles == nil || *c.limits.OpenFiles != openFiles {
+		t.Errorf("c.limits.OpenFiles = %v, want %d", formatLimit(c.limits.OpenFiles), openFiles)
+	}
+
+	want := map[int]execHelperRlimit{
+		syscall.RLIMIT_CPU:    {Resource: syscall.RLIMIT_CPU, Cur: 2, Max: 3},
+		syscall.RLIMIT_NOFILE: {Resource: syscall.RLIMIT_NOFILE, Cur: openFiles, Max: openFiles},
+	}
+	if len(c.rlimits) != len(want) {
+		t.Fatalf("c.rlimits = %+v, want %d limits", c.rlimits, len(want))
+	}
+	for _, got := range c.rlimits {
+		w := want[got.Resource]
+		// The hard limit is capped by the bot's own.
+		if got.Resource == syscall.RLIMIT_CPU {
+			var cpu syscall.Rlimit
+			if err := syscall.Getrlimit(syscall.RLIMIT_CPU, &cpu); err != nil {
+				t.Fatal(err)
+			}
+			w.Max = min(w.Max, cpu.Max)
+		}
+		if got != w {
+			t.Errorf("rlimit %d = %+v, want %+v", got.Resource, got, w)
+		}
+	}
+
+	over := current.Max + 1
+	if current.Max != math.MaxUint64 {
+		c = Command{Name: "true", defaultLimits: ResourceLimits{OpenFiles: &over}}
+		if err := c.initRlimits(); err == nil {
+			t.Error("c.initRlimits() = nil, want error for a limit over the hard limit")
+		}
+	}
+}
+
+func TestResourceLimitsLimitCause(t *testing.T) {
+	ptr := func(v uint64) *uint64 { return &v }
+	cpuTime := jsoncfg.Duration(1500 * time.Millisecond)
+
+	for _, c := range [...]struct {
+		name   string
+		limits ResourceLimits
+		result CommandResult
+		want   string
+	}{
+		{
+			name:   "NoLimits",
+			result: CommandResult{Signal: Signal(syscall.SIGXCPU)},
+		},
+		{
+			name:   "SIGXCPU",
+			limits: ResourceLimits{CPUTime: &cpuTime},
+			result: CommandResult{Signal: Signal(syscall.SIGXCPU)},
+			want:   "CPU time limit of 2s exceeded",
+		},
+		{
+			name:   "SIGKILLAfterCPUTime",
+			limits: ResourceLimits{CPUTime: &cpuTime},
+			result: CommandResult{Signal: Signal(syscall.SIGKILL), UserTime: 1500 * time.Millisecond, SystemTime: 500 * time.Millisecond},
+			want:   "CPU time limit of 2s exceeded",
+		},
+		{
+			name:   "SIGKILLBeforeCPUTime",
+			limits: ResourceLimits{CPUTime: &cpuTime},
+			result: CommandResult{Signal: Signal(syscall.SIGKILL), UserTime: time.Second},
+		},
+		{
+			name:   "SIGKILLByStopLadder",
+			limits: ResourceLimits{CPUTime: &cpuTime},
+			result: CommandResult{Signal: Signal(syscall.SIGKILL), UserTime: 3 * time.Second, Stop: StopResult{Step: 1, Steps: 1}},
+		},
+		{
+			name:   "SIGXFSZ",
+			limits: ResourceLimits{FileSize: ptr(1 << 20)},
+			result: CommandResult{Signal: Signal(syscall.SIGXFSZ)},
+			want:   "file size limit of 1.0 MiB exceeded",
+		},
+		{
+			name:   "SIGXFSZWithoutLimit",
+			limits: ResourceLimits{AddressSpace: ptr(1 << 30)},
+			result: CommandResult{Signal: Signal(syscall.SIGXFSZ)},
+		},
+		{
+			name:   "SIGSEGVWithAddressSpace",
+			limits: ResourceLimits{AddressSpace: ptr(1 << 30)},
+			result: CommandResult{Signal: Signal(syscall.SIGSEGV)},
+			want:   "possibly exceeded the address space limit of 1.0 GiB",
+		},
+		{
+			name:   "SIGABRTWithAddressSpace",
+			limits: ResourceLimits{AddressSpace: ptr(1 << 30)},
+			result: CommandResult{Signal: Signal(syscall.SIGABRT)},
+			want:   "possibly exceeded the address space limit of 1.0 GiB",
+		},
+		{
+			name:   "ExitCode",
+			limits: ResourceLimits{AddressSpace: ptr(1 << 30), CPUTime: &cpuTime, FileSize: ptr(1 << 20)},
+			result: CommandResult{ExitCode: 1},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.limits.limitCause(&c.result); got != c.want {
+				t.Errorf("limitCause() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,6 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le && !sparc64
+
+package rcebot
+
+// rlimitNPROC is RLIMIT_NPROC from <asm-generic/resource.h>, which package syscall does not define.
+const rlimitNPROC = 6
@@ -0,0 +1,6 @@
+//go:build linux && (mips || mipsle || mips64 || mips64le)
+
+package rcebot
+
+// rlimitNPROC is RLIMIT_NPROC from <asm/resource.h> on MIPS.
+const rlimitNPROC = 8
@@ -0,0 +1,6 @@
+//go:build linux && sparc64
+
+package rcebot
+
+// rlimitNPROC is RLIMIT_NPROC from <asm/resource.h> on SPARC.
+const rlimitNPROC = 7
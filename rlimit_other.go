@@ -0,0 +1,22 @@
+//go:build !linux
+
+package rcebot
+
+import "errors"
+
+const rlimitSupported = false
+
+func (l *ResourceLimits) rlimits() ([]execHelperRlimit, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func setRlimits(rlimits []execHelperRlimit) error {
+	if len(rlimits) == 0 {
+		return nil
+	}
+	return errors.ErrUnsupported
+}
+
+func (l *ResourceLimits) limitCause(_ *CommandResult) string {
+	return ""
+}
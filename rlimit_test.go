@@ -0,0 +1,76 @@
+package rcebot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/database64128/cubic-rce-bot/jsoncfg"
+)
+
+func TestResourceLimitsMerge(t *testing.T) {
+	ptr := func(v uint64) *uint64 { return &v }
+	cpuTime := jsoncfg.Duration(time.Minute)
+	defaultCPUTime := jsoncfg.Duration(time.Hour)
+
+	for _, c := range [...]struct {
+		name     string
+		limits   ResourceLimits
+		defaults ResourceLimits
+		want     ResourceLimits
+	}{
+		{
+			name: "Empty",
+		},
+		{
+			name:     "DefaultsOnly",
+			defaults: ResourceLimits{AddressSpace: ptr(1 << 30), CPUTime: &defaultCPUTime},
+			want:     ResourceLimits{AddressSpace: ptr(1 << 30), CPUTime: &defaultCPUTime},
+		},
+		{
+			name:   "CommandOnly",
+			limits: ResourceLimits{OpenFiles: ptr(64), CPUTime: &cpuTime},
+			want:   ResourceLimits{OpenFiles: ptr(64), CPUTime: &cpuTime},
+		},
+		{
+			name:     "Override",
+			limits:   ResourceLimits{AddressSpace: ptr(1 << 20), CPUTime: &cpuTime},
+			defaults: ResourceLimits{AddressSpace: ptr(1 << 30), CPUTime: &defaultCPUTime, Processes: ptr(32)},
+			want:     ResourceLimits{AddressSpace: ptr(1 << 20), CPUTime: &cpuTime, Processes: ptr(32)},
+		},
+		{
+			// An explicit zero is a limit, not an unset value.
+			name:     "OverrideWithZero",
+			limits:   ResourceLimits{CoreSize: ptr(0)},
+			defaults: ResourceLimits{CoreSize: ptr(1 << 20), FileSize: ptr(1 << 20)},
+			want:     ResourceLimits{CoreSize: ptr(0), FileSize: ptr(1 << 20)},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got := c.limits.merge(c.defaults)
+			for _, f := range [...]struct {
+				name      string
+				got, want *uint64
+			}{
+				{"AddressSpace", got.AddressSpace, c.want.AddressSpace},
+				{"OpenFiles", got.OpenFiles, c.want.OpenFiles},
+				{"Processes", got.Processes, c.want.Processes},
+				{"CoreSize", got.CoreSize, c.want.CoreSize},
+				{"FileSize", got.FileSize, c.want.FileSize},
+			} {
+				if (f.got == nil) != (f.want == nil) || f.got != nil && *f.got != *f.want {
+					t.Errorf("%s = %v, want %v", f.name, formatLimit(f.got), formatLimit(f.want))
+				}
+			}
+			if (got.CPUTime == nil) != (c.want.CPUTime == nil) || got.CPUTime != nil && *got.CPUTime != *c.want.CPUTime {
+				t.Errorf("CPUTime = %v, want %v", got.CPUTime, c.want.CPUTime)
+			}
+		})
+	}
+}
+
+func formatLimit(p *uint64) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
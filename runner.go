@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
 	"time"
 
 	"github.com/database64128/cubic-rce-bot/jsoncfg"
@@ -14,6 +16,14 @@ import (
 	"github.com/go-telegram/bot/models"
 )
 
+// allowedUpdates is the list of update types the bot subscribes to.
+//
+// Documents for standard input and uploads arrive in message updates, along with their `/exec` caption.
+var allowedUpdates = []string{
+	models.AllowedUpdateMessage,
+	models.AllowedUpdateCallbackQuery,
+}
+
 // Runner loads the configuration and creates a handler.
 type Runner struct {
 	configPath    string
@@ -30,8 +40,26 @@ func (r *Runner) loadConfig() error {
 		return err
 	}
 
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	userCommandsByID, err := config.UserCommandsByID()
+	if err != nil {
+		return err
+	}
+
+	schedules, err := config.ScheduledCommands()
+	if err != nil {
+		return err
+	}
+
 	r.config = config
-	r.handler.ReplaceUserCommandsByID(config.UserCommandsByID())
+	r.handler.SetMaxConcurrentJobs(config.MaxConcurrentJobs)
+	r.handler.SetJobHistorySize(config.JobHistorySize)
+	r.handler.ReplaceAdminIDs(config.AdminIDs())
+	r.handler.ReplaceUserCommandsByID(userCommandsByID)
+	r.handler.ReplaceSchedules(schedules)
 	return nil
 }
 
@@ -65,7 +93,7 @@ func NewRunner(configPath string, logger *tslog.Logger) (*Runner, error) {
 		bot.WithErrorsHandler(func(err error) {
 			logger.Warn("Failed to handle update", tslog.Err(err))
 		}),
-		bot.WithAllowedUpdates(bot.AllowedUpdates{models.AllowedUpdateMessage}),
+		bot.WithAllowedUpdates(allowedUpdates),
 	)
 
 	b, err := bot.New(r.config.Token, opts...)
@@ -121,7 +149,7 @@ func (r *Runner) Start(ctx context.Context) error {
 	if err := retryOnError(func() error {
 		_, err := r.bot.SetWebhook(ctx, &bot.SetWebhookParams{
 			URL:            r.config.Webhook.URL,
-			AllowedUpdates: []string{models.AllowedUpdateMessage},
+			AllowedUpdates: allowedUpdates,
 			SecretToken:    r.config.Webhook.SecretToken,
 		})
 		return err
@@ -139,6 +167,8 @@ func (r *Runner) Start(ctx context.Context) error {
 		go r.bot.Start(ctx)
 	}
 
+	go r.handler.RunSchedules(ctx, r.bot)
+
 	r.logger.Info("Started bot",
 		slog.Int64("id", me.ID),
 		slog.String("firstName", me.FirstName),
@@ -170,6 +200,31 @@ func isFatalAPIError(err error) bool {
 	}
 }
 
+// CheckSandboxProfiles checks whether the running kernel supports each sandbox profile in the configuration,
+// and logs the results.
+func (r *Runner) CheckSandboxProfiles() {
+	if len(r.config.SandboxProfiles) == 0 {
+		return
+	}
+
+	abi := landlockABI()
+	for _, name := range slices.Sorted(maps.Keys(r.config.SandboxProfiles)) {
+		profile := r.config.SandboxProfiles[name]
+		if err := profile.Check(); err != nil {
+			r.logger.Warn("Sandbox profile is not supported by the running kernel",
+				slog.String("profile", name),
+				slog.Int("landlockABI", abi),
+				tslog.Err(err),
+			)
+			continue
+		}
+		r.logger.Info("Sandbox profile is supported by the running kernel",
+			slog.String("profile", name),
+			slog.Int("landlockABI", abi),
+		)
+	}
+}
+
 // Stop stops the runner.
 func (r *Runner) Stop() {
 	// Stop the webhook server if it exists.
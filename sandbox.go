@@ -0,0 +1,117 @@
+package rcebot
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"slices"
+)
+
+// SandboxProfile is a set of restrictions applied to a command before it is executed,
+// as defense in depth against a command doing more than intended.
+//
+// Sandbox profiles are only supported on Linux.
+type SandboxProfile struct {
+	// NoNewPrivs sets the no_new_privs bit, so that the command and its descendants
+	// cannot gain privileges through set-user-ID or set-group-ID programs or file capabilities.
+	//
+	// It is always set when Landlock rules are in effect.
+	NoNewPrivs bool `json:"noNewPrivs,omitzero"`
+
+	// ReadOnlyPaths is the list of absolute paths, beneath which the command may read and execute files.
+	//
+	// If either this or [SandboxProfile.ReadWritePaths] is not null, Landlock restricts the command's
+	// file system access to the listed paths. The command's own executable, its interpreter and
+	// shared libraries (e.g., "/usr", "/lib") must be covered as well, or the command fails to start.
+	// Landlock must be supported and enabled in the running kernel.
+	//
+	// The script file of a command with [Command.Script] is always included.
+	ReadOnlyPaths []string `json:"readOnlyPaths,omitzero"`
+
+	// ReadWritePaths is the list of absolute paths, beneath which the command may also create, modify and remove files.
+	//
+	// The scratch directory of a command with [Command.Artifacts] and [Command.UploadDir] are always included.
+	ReadWritePaths []string `json:"readWritePaths,omitzero"`
+
+	// IsolateNetwork runs the command in a new network namespace with only a loopback interface.
+	IsolateNetwork bool `json:"isolateNetwork,omitzero"`
+
+	// PrivateTmp runs the command in a new mount namespace with an empty tmpfs mounted on /tmp.
+	PrivateTmp bool `json:"privateTmp,omitzero"`
+}
+
+// usesLandlock returns whether the profile restricts file system access with Landlock.
+func (p *SandboxProfile) usesLandlock() bool {
+	return p.ReadOnlyPaths != nil || p.ReadWritePaths != nil
+}
+
+// usesNamespaces returns whether the profile runs the command in new namespaces.
+//
+// A new user namespace is always created along with other namespaces,
+// so that they can be created without privileges.
+func (p *SandboxProfile) usesNamespaces() bool {
+	return p.IsolateNetwork || p.PrivateTmp
+}
+
+// withPaths returns the profile with readOnlyPaths added to [SandboxProfile.ReadOnlyPaths]
+// and readWritePaths added to [SandboxProfile.ReadWritePaths],
+// if it restricts file system access with Landlock. Otherwise, it returns the profile as is.
+func (p *SandboxProfile) withPaths(readOnlyPaths, readWritePaths []string) *SandboxProfile {
+	if !p.usesLandlock() || len(readOnlyPaths)+len(readWritePaths) == 0 {
+		return p
+	}
+	profile := *p
+	if len(readOnlyPaths) > 0 {
+		profile.ReadOnlyPaths = append(slices.Clip(p.ReadOnlyPaths), readOnlyPaths...)
+	}
+	if len(readWritePaths) > 0 {
+		profile.ReadWritePaths = append(slices.Clip(p.ReadWritePaths), readWritePaths...)
+	}
+	return &profile
+}
+
+// Validate returns an error if the profile is invalid or unsupported on the current platform.
+func (p *SandboxProfile) Validate() error {
+	if !sandboxSupported {
+		return fmt.Errorf("sandbox profiles are not supported on %s", runtime.GOOS)
+	}
+	for _, path := range p.ReadOnlyPaths {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("read-only path %q is not absolute", path)
+		}
+	}
+	for _, path := range p.ReadWritePaths {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("read-write path %q is not absolute", path)
+		}
+	}
+	return nil
+}
+
+// Check returns an error if the running kernel does not support the profile.
+// It works by starting a probe process that applies the profile and exits.
+func (p *SandboxProfile) Check() error {
+	return p.probe()
+}
+
+// initSandbox resolves the sandbox profile of the command.
+func (c *Command) initSandbox() error {
+	c.sandbox = nil
+
+	if c.Sandbox == "" {
+		return nil
+	}
+
+	profile, ok := c.sandboxProfiles[c.Sandbox]
+	if !ok {
+		return fmt.Errorf("unknown sandbox profile %q", c.Sandbox)
+	}
+
+	if profile.usesNamespaces() && c.credential != nil {
+		return errors.New("sandbox profiles with namespaces cannot be combined with running as a different user or group")
+	}
+
+	c.sandbox = &profile
+	return nil
+}
@@ -0,0 +1,240 @@
+package rcebot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const sandboxSupported = true
+
+const (
+	capNetAdmin = 12
+	capSysAdmin = 21
+
+	prSetNoNewPrivs       = 38
+	prCapAmbient          = 47
+	prCapAmbientClearAll  = 4
+	oPath                 = 0x200000
+	siocGetInterfaceFlags = 0x8913
+	siocSetInterfaceFlags = 0x8914
+)
+
+// Landlock constants from <linux/landlock.h>.
+const (
+	landlockCreateRulesetVersion = 1 << 0
+	landlockRulePathBeneath      = 1
+
+	landlockAccessFSExecute    = 1 << 0
+	landlockAccessFSWriteFile  = 1 << 1
+	landlockAccessFSReadFile   = 1 << 2
+	landlockAccessFSReadDir    = 1 << 3
+	landlockAccessFSRefer      = 1 << 13
+	landlockAccessFSTruncate   = 1 << 14
+	landlockAccessFSIoctlDev   = 1 << 15
+	landlockAccessFSABI1       = 1<<13 - 1
+	landlockAccessFSReadOnly   = landlockAccessFSExecute | landlockAccessFSReadFile | landlockAccessFSReadDir
+	landlockAccessFSFileRights = landlockAccessFSExecute | landlockAccessFSWriteFile | landlockAccessFSReadFile |
+		landlockAccessFSTruncate | landlockAccessFSIoctlDev
+)
+
+// setSandboxSysProcAttr makes the command start in the namespaces required by the profile.
+//
+// The bot's user and group are mapped to themselves in the new user namespace. The capabilities
+// needed to set up the other namespaces are passed to the exec helper as ambient capabilities,
+// which it clears before executing the command.
+func setSandboxSysProcAttr(attr *syscall.SysProcAttr, p *SandboxProfile) {
+	if p == nil || !p.usesNamespaces() {
+		return
+	}
+
+	attr.Cloneflags |= syscall.CLONE_NEWUSER
+	if p.IsolateNetwork {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	if p.PrivateTmp {
+		attr.Cloneflags |= syscall.CLONE_NEWNS
+	}
+
+	uid, gid := os.Geteuid(), os.Getegid()
+	attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}}
+	attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}}
+	attr.GidMappingsEnableSetgroups = false
+	attr.AmbientCaps = []uintptr{capNetAdmin, capSysAdmin}
+}
+
+// applySandbox applies the profile to the current process. The process must then execute the command
+// without returning to other goroutines, as some of the restrictions only apply to the calling thread.
+func applySandbox(p *SandboxProfile) error {
+	if p == nil {
+		return nil
+	}
+
+	runtime.LockOSThread()
+
+	if p.PrivateTmp {
+		if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+			return fmt.Errorf("failed to make mounts private: %w", err)
+		}
+		if err := syscall.Mount("tmpfs", "/tmp", "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+			return fmt.Errorf("failed to mount private /tmp: %w", err)
+		}
+	}
+
+	if p.IsolateNetwork {
+		if err := bringUpLoopback(); err != nil {
+			return fmt.Errorf("failed to bring up loopback interface: %w", err)
+		}
+	}
+
+	if p.usesNamespaces() {
+		if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0); errno != 0 {
+			return fmt.Errorf("failed to clear ambient capabilities: %w", errno)
+		}
+	}
+
+	if p.NoNewPrivs || p.usesLandlock() {
+		if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+			return fmt.Errorf("failed to set no_new_privs: %w", errno)
+		}
+	}
+
+	if p.usesLandlock() {
+		if err := restrictLandlock(p.ReadOnlyPaths, p.ReadWritePaths); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bringUpLoopback brings up the loopback interface of the current network namespace.
+func bringUpLoopback() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	// struct ifreq from <net/if.h>, with ifr_flags in the union.
+	var ifr struct {
+		name  [syscall.IFNAMSIZ]byte
+		flags uint16
+		_     [22]byte
+	}
+	copy(ifr.name[:], "lo")
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), siocGetInterfaceFlags, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return os.NewSyscallError("ioctl", errno)
+	}
+	ifr.flags |= syscall.IFF_UP
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), siocSetInterfaceFlags, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return os.NewSyscallError("ioctl", errno)
+	}
+	return nil
+}
+
+// landlockABI returns the Landlock ABI version supported by the running kernel,
+// or 0 if Landlock is not supported or not enabled.
+func landlockABI() int {
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		return 0
+	}
+	return int(abi)
+}
+
+// restrictLandlock restricts the file system access of the current thread and its future children
+// to reading and executing beneath readOnlyPaths, and full access beneath readWritePaths.
+func restrictLandlock(readOnlyPaths, readWritePaths []string) error {
+	abi := landlockABI()
+	if abi < 1 {
+		return errors.New("landlock is not supported or not enabled in the running kernel")
+	}
+
+	handled := uint64(landlockAccessFSABI1)
+	if abi >= 2 {
+		handled |= landlockAccessFSRefer
+	}
+	if abi >= 3 {
+		handled |= landlockAccessFSTruncate
+	}
+	if abi >= 5 {
+		handled |= landlockAccessFSIoctlDev
+	}
+
+	// struct landlock_ruleset_attr, of which only handled_access_fs is set.
+	// The kernel accepts a prefix of the struct.
+	rulesetAttr := handled
+	rulesetFD, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&rulesetAttr)), unsafe.Sizeof(rulesetAttr), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to create landlock ruleset: %w", errno)
+	}
+	defer syscall.Close(int(rulesetFD))
+
+	addRule := func(path string, access uint64) error {
+		fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("failed to open %q: %w", path, err)
+		}
+		defer syscall.Close(fd)
+
+		var st syscall.Stat_t
+		if err := syscall.Fstat(fd, &st); err != nil {
+			return fmt.Errorf("failed to stat %q: %w", path, err)
+		}
+		if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+			access &= landlockAccessFSFileRights
+		}
+
+		// struct landlock_path_beneath_attr is packed, so it is encoded by hand.
+		var attr [12]byte
+		binary.NativeEndian.PutUint64(attr[:8], access&handled)
+		binary.NativeEndian.PutUint32(attr[8:], uint32(int32(fd)))
+		if _, _, errno := syscall.Syscall6(sysLandlockAddRule, rulesetFD, landlockRulePathBeneath, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+			return fmt.Errorf("failed to add landlock rule for %q: %w", path, errno)
+		}
+		return nil
+	}
+
+	for _, path := range readOnlyPaths {
+		if err := addRule(path, landlockAccessFSReadOnly); err != nil {
+			return err
+		}
+	}
+	for _, path := range readWritePaths {
+		if err := addRule(path, handled); err != nil {
+			return err
+		}
+	}
+
+	if _, _, errno := syscall.Syscall(sysLandlockRestrictSelf, rulesetFD, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to enforce landlock ruleset: %w", errno)
+	}
+	return nil
+}
+
+// probe starts the exec helper to apply the profile and exit, and returns any error it reports.
+func (p *SandboxProfile) probe() error {
+	cmd := exec.Command("/proc/self/exe")
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+	setSandboxSysProcAttr(cmd.SysProcAttr, p)
+	if err := useExecHelper(cmd, &execHelperSpec{Sandbox: p, Probe: true}); err != nil {
+		return err
+	}
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if output = bytes.TrimSpace(output); len(output) > 0 {
+			return fmt.Errorf("%w: %s", err, output)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package rcebot
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/database64128/cubic-rce-bot/jsoncfg"
+)
+
+func TestUserCommandsByIDSandbox(t *testing.T) {
+	sandboxProfiles := map[string]SandboxProfile{
+		"nnp":      {NoNewPrivs: true},
+		"landlock": {ReadOnlyPaths: []string{"/usr", "/lib"}, ReadWritePaths: []string{"/var/tmp"}},
+		"offline":  {IsolateNetwork: true},
+		"tmp":      {PrivateTmp: true},
+	}
+
+	for _, c := range [...]struct {
+		name     string
+		commands []Command
+		wantErr  string
+	}{
+		{
+			name:     "Sandbox",
+			commands: []Command{{Name: "true", Sandbox: "landlock"}, {Name: "true", Sandbox: "offline"}},
+		},
+		{
+			name:     "UnknownSandbox",
+			commands: []Command{{Name: "true", Sandbox: "missing"}},
+			wantErr:  `unknown sandbox profile "missing"`,
+		},
+		{
+			name:     "WithoutNamespacesRunAsGroup",
+			commands: []Command{{Name: "true", Sandbox: "nnp", RunAsGroup: jsoncfg.IntOrStringFromInt(os.Getgid())}},
+		},
+		{
+			name:     "IsolateNetworkRunAsGroup",
+			commands: []Command{{Name: "true", Sandbox: "offline", RunAsGroup: jsoncfg.IntOrStringFromInt(os.Getgid())}},
+			wantErr:  "sandbox profiles with namespaces cannot be combined with running as a different user or group",
+		},
+		{
+			name:     "PrivateTmpSupplementaryGroups",
+			commands: []Command{{Name: "true", Sandbox: "tmp", SupplementaryGroups: []jsoncfg.IntOrString{}}},
+			wantErr:  "sandbox profiles with namespaces cannot be combined with running as a different user or group",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			config := Config{
+				SandboxProfiles: sandboxProfiles,
+				Users:           []User{{ID: 1, Commands: c.commands}},
+			}
+			err := config.Validate()
+			if err == nil {
+				_, err = config.UserCommandsByID()
+			}
+			if c.wantErr == "" {
+				if err != nil {
+					t.Errorf("UserCommandsByID() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("UserCommandsByID() error = %v, want %q", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestCommandNewCmdLandlockWritablePaths(t *testing.T) {
+	uploadDir := t.TempDir()
+	config := Config{
+		SandboxProfiles: map[string]SandboxProfile{
+			"landlock": {ReadOnlyPaths: []string{"/usr", "/lib"}, ReadWritePaths: []string{"/var/tmp"}},
+			"nnp":      {NoNewPrivs: true},
+		},
+		Users: []User{{ID: 1, Commands: []Command{
+			{Name: "build", Script: "make\n", Artifacts: []string{"*"}, UploadDir: uploadDir, Sandbox: "landlock"},
+			{Name: "missing", Script: "ls\n", UploadDir: filepath.Join(uploadDir, "missing"), Sandbox: "landlock"},
+			{Name: "build", Script: "make\n", Artifacts: []string{"*"}, UploadDir: uploadDir, Sandbox: "nnp"},
+		}}},
+	}
+	userCommandsByID, err := config.UserCommandsByID()
+	if err != nil {
+		t.Fatalf("UserCommandsByID() = %v", err)
+	}
+	commands := userCommandsByID[1]
+
+	// helperSpec returns the exec helper spec of the command's process, and the path of its script file.
+	helperSpec := func(command *Command, scratchDir string) (execHelperSpec, string) {
+		t.Helper()
+		cmd, cleanup, err := command.newCmd(nil, scratchDir)
+		if err != nil {
+			t.Fatalf("command.newCmd() = %v", err)
+		}
+		t.Cleanup(cleanup)
+
+		var spec execHelperSpec
+		for _, kv := range cmd.Env {
+			if specJSON, ok := strings.CutPrefix(kv, execHelperEnvKey+"="); ok {
+				if err := json.Unmarshal([]byte(specJSON), &spec); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if spec.Sandbox == nil {
+			t.Fatal("exec helper spec has no sandbox profile")
+		}
+		return spec, cmd.Args[3]
+	}
+
+	scratchDir := t.TempDir()
+	spec, scriptPath := helperSpec(&commands[0], scratchDir)
+	if want := []string{"/usr", "/lib", scriptPath}; !slices.Equal(spec.Sandbox.ReadOnlyPaths, want) {
+		t.Errorf("read-only paths = %q, want %q", spec.Sandbox.ReadOnlyPaths, want)
+	}
+	if want := []string{"/var/tmp", scratchDir, uploadDir}; !slices.Equal(spec.Sandbox.ReadWritePaths, want) {
+		t.Errorf("read-write paths = %q, want %q", spec.Sandbox.ReadWritePaths, want)
+	}
+	if want := []string{"/usr", "/lib"}; !slices.Equal(commands[0].sandbox.ReadOnlyPaths, want) {
+		t.Errorf("profile read-only paths = %q, want %q", commands[0].sandbox.ReadOnlyPaths, want)
+	}
+	if want := []string{"/var/tmp"}; !slices.Equal(commands[0].sandbox.ReadWritePaths, want) {
+		t.Errorf("profile read-write paths = %q, want %q", commands[0].sandbox.ReadWritePaths, want)
+	}
+
+	// An upload directory that does not exist yet is left out.
+	spec, _ = helperSpec(&commands[1], "")
+	if want := []string{"/var/tmp"}; !slices.Equal(spec.Sandbox.ReadWritePaths, want) {
+		t.Errorf("without upload directory, read-write paths = %q, want %q", spec.Sandbox.ReadWritePaths, want)
+	}
+
+	// Profiles without Landlock rules are left as they are.
+	spec, _ = helperSpec(&commands[2], scratchDir)
+	if spec.Sandbox.ReadWritePaths != nil {
+		t.Errorf("without Landlock, read-write paths = %q, want none", spec.Sandbox.ReadWritePaths)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+
+package rcebot
+
+import (
+	"errors"
+	"syscall"
+)
+
+const sandboxSupported = false
+
+func setSandboxSysProcAttr(_ *syscall.SysProcAttr, _ *SandboxProfile) {}
+
+func applySandbox(p *SandboxProfile) error {
+	if p == nil {
+		return nil
+	}
+	return errors.ErrUnsupported
+}
+
+func landlockABI() int {
+	return 0
+}
+
+func (p *SandboxProfile) probe() error {
+	return errors.ErrUnsupported
+}
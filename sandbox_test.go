@@ -0,0 +1,63 @@
+package rcebot
+
+import (
+	"cmp"
+	"strings"
+	"testing"
+)
+
+func TestConfigValidateSandboxProfiles(t *testing.T) {
+	// On other platforms, any sandbox profile is rejected.
+	unsupported := ""
+	if !sandboxSupported {
+		unsupported = "sandbox profiles are not supported"
+	}
+
+	for _, c := range [...]struct {
+		name    string
+		profile SandboxProfile
+		wantErr string
+	}{
+		{
+			name:    "NoNewPrivs",
+			profile: SandboxProfile{NoNewPrivs: true},
+			wantErr: unsupported,
+		},
+		{
+			name:    "Landlock",
+			profile: SandboxProfile{ReadOnlyPaths: []string{"/usr"}, ReadWritePaths: []string{"/var/tmp"}},
+			wantErr: unsupported,
+		},
+		{
+			name:    "Namespaces",
+			profile: SandboxProfile{IsolateNetwork: true, PrivateTmp: true},
+			wantErr: unsupported,
+		},
+		{
+			name:    "RelativeReadOnlyPath",
+			profile: SandboxProfile{ReadOnlyPaths: []string{"usr"}},
+			wantErr: cmp.Or(unsupported, `read-only path "usr" is not absolute`),
+		},
+		{
+			name:    "RelativeReadWritePath",
+			profile: SandboxProfile{ReadWritePaths: []string{"/usr", "./data"}},
+			wantErr: cmp.Or(unsupported, `read-write path "./data" is not absolute`),
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			config := Config{
+				SandboxProfiles: map[string]SandboxProfile{"test": c.profile},
+			}
+			err := config.Validate()
+			if c.wantErr == "" {
+				if err != nil {
+					t.Errorf("config.Validate() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("config.Validate() error = %v, want %q", err, c.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,379 @@
+package rcebot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/database64128/cubic-rce-bot/tslog"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// Schedule is a command that runs on a schedule, with its output posted to chats.
+type Schedule struct {
+	// Name is the unique name of the schedule, shown in `/schedules` and in the posted results.
+	Name string `json:"name"`
+
+	// Spec is the schedule specification. It is either a standard 5-field cron expression
+	// (minute, hour, day of month, month, day of week, e.g., "*/15 * * * *"),
+	// a predefined descriptor ("@yearly", "@monthly", "@weekly", "@daily", "@hourly"),
+	// or a fixed interval in the form "@every <duration>" (e.g., "@every 1h").
+	Spec string `json:"spec"`
+
+	// Timezone is the optional IANA time zone name in which cron expressions are evaluated.
+	//
+	// If empty, the local time zone is used.
+	Timezone string `json:"timezone,omitzero"`
+
+	// Chats is the list of chats the results are posted to.
+	Chats []ScheduleChat `json:"chats"`
+
+	// Priority is the queue priority of the scheduled executions.
+	Priority int `json:"priority,omitzero"`
+
+	// UserID is the optional ID of the configured user who owns the schedule.
+	// Scheduled executions are recorded in the user's job history, so that the user can view them
+	// with `/jobs`, `/status` and `/output`, and cancel them with `/cancel <job ID>`.
+	//
+	// If zero, only admins can view and cancel scheduled executions.
+	UserID int64 `json:"userID,omitzero"`
+
+	// Params is the optional map of parameter values to execute the command with.
+	Params map[string]string `json:"params,omitzero"`
+
+	// Command is the command to run.
+	//
+	// Scheduled commands cannot be interactive, stream their output, or read stdin from messages.
+	// Results are posted according to [Command.Notify].
+	Command Command `json:"command"`
+
+	cron     *CronSchedule
+	location *time.Location
+	args     []string
+}
+
+// ScheduleChat is a chat that the results of a schedule are posted to.
+type ScheduleChat struct {
+	// ID is the chat ID.
+	ID int64 `json:"id"`
+
+	// ThreadID is the optional message thread (topic) ID in a forum supergroup.
+	ThreadID int `json:"threadID,omitzero"`
+}
+
+// init validates the schedule and initializes its internal state, except for the command.
+func (s *Schedule) init() error {
+	if s.Name == "" {
+		return errors.New("missing name")
+	}
+
+	cron, err := ParseCronSchedule(s.Spec)
+	if err != nil {
+		return fmt.Errorf("invalid spec %q: %w", s.Spec, err)
+	}
+	if err = cron.validate(); err != nil {
+		return fmt.Errorf("invalid spec %q: %w", s.Spec, err)
+	}
+	s.cron = cron
+
+	s.location = time.Local
+	if s.Timezone != "" {
+		if s.location, err = time.LoadLocation(s.Timezone); err != nil {
+			return err
+		}
+	}
+
+	if len(s.Chats) == 0 {
+		return errors.New("no chats to post results to")
+	}
+
+	command := &s.Command
+	switch {
+	case command.isPipeline():
+		return errors.New("scheduled commands cannot be pipelines")
+	case command.Interactive:
+		return errors.New("scheduled commands cannot be interactive")
+	case command.Stream:
+		return errors.New("scheduled commands cannot stream their output")
+	case command.Stdin != StdinModeNone:
+		return errors.New("scheduled commands cannot read stdin from messages or documents")
+	case command.UploadDir != "":
+		return errors.New("scheduled commands cannot take uploads")
+	}
+
+	if s.args, err = command.ResolveArgs(s.Params); err != nil {
+		return err
+	}
+	return nil
+}
+
+// next returns the first time after t that the schedule runs at, or the zero time if it never runs again.
+func (s *Schedule) next(t time.Time) time.Time {
+	return s.cron.Next(t.In(s.location))
+}
+
+// nextAfterRun is like [Schedule.next], but for cron expressions, it skips the times that are not later
+// on the wall clock than the last run, so that the hour repeated when clocks fall back does not run twice.
+// last is the zero time if the schedule has not run.
+func (s *Schedule) nextAfterRun(t, last time.Time) time.Time {
+	next := s.next(t)
+	if s.cron.every > 0 || last.IsZero() {
+		return next
+	}
+	lastWallClock := wallClock(last.In(s.location))
+	for !next.IsZero() && !wallClock(next).After(lastWallClock) {
+		next = s.next(next)
+	}
+	return next
+}
+
+// wallClock returns the date and time shown on the wall clock of t's location, as a time in UTC.
+func wallClock(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// scheduleManager keeps track of the next run time of each schedule,
+// and of the last run time and whether it is running by schedule name, across config reloads.
+type scheduleManager struct {
+	mu        sync.Mutex
+	schedules []Schedule
+	nextTimes []time.Time
+	lastTimes map[string]time.Time
+	running   map[string]bool
+	changed   chan struct{}
+}
+
+// replace replaces the schedules, and wakes up the run loop to reschedule.
+func (m *scheduleManager) replace(schedules []Schedule) {
+	m.mu.Lock()
+	m.schedules = schedules
+	m.nextTimes = make([]time.Time, len(schedules))
+	m.mu.Unlock()
+
+	select {
+	case m.changed <- struct{}{}:
+	default:
+	}
+}
+
+// due returns the schedules due to run at now, and advances their next run times.
+// It also returns the duration until the next schedule is due.
+func (m *scheduleManager) due(now time.Time) (due []*Schedule, wait time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Check back at least once an hour, in case the system clock jumps.
+	wait = time.Hour
+
+	for i := range m.schedules {
+		s := &m.schedules[i]
+		next := &m.nextTimes[i]
+
+		if next.IsZero() {
+			*next = s.nextAfterRun(now, m.lastTimes[s.Name])
+		} else if !next.After(now) {
+			due = append(due, s)
+			if m.lastTimes == nil {
+				m.lastTimes = make(map[string]time.Time)
+			}
+			m.lastTimes[s.Name] = *next
+			*next = s.nextAfterRun(now, *next)
+		}
+
+		if !next.IsZero() {
+			wait = min(wait, next.Sub(now))
+		}
+	}
+
+	return due, wait
+}
+
+// tryStart marks the schedule as running, and returns false if it is already running.
+func (m *scheduleManager) tryStart(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.running[name] {
+		return false
+	}
+	if m.running == nil {
+		m.running = make(map[string]bool)
+	}
+	m.running[name] = true
+	return true
+}
+
+// done marks the schedule as no longer running.
+func (m *scheduleManager) done(name string) {
+	m.mu.Lock()
+	delete(m.running, name)
+	m.mu.Unlock()
+}
+
+// scheduleStatus is the status of a schedule shown in `/schedules`.
+type scheduleStatus struct {
+	name    string
+	spec    string
+	next    time.Time
+	running bool
+}
+
+// statuses returns the status of each schedule.
+func (m *scheduleManager) statuses() []scheduleStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	statuses := make([]scheduleStatus, len(m.schedules))
+	for i := range m.schedules {
+		s := &m.schedules[i]
+		next := m.nextTimes[i]
+		if next.IsZero() {
+			next = s.next(time.Now())
+		}
+		statuses[i] = scheduleStatus{
+			name:    s.Name,
+			spec:    s.Spec,
+			next:    next,
+			running: m.running[s.Name],
+		}
+	}
+	return statuses
+}
+
+// RunSchedules runs the scheduled commands until ctx is done, posting their results with b.
+//
+// A run is skipped if the previous run of the same schedule is still going.
+func (h *Handler) RunSchedules(ctx context.Context, b *bot.Bot) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-h.schedules.changed:
+		}
+
+		due, wait := h.schedules.due(time.Now())
+		for _, s := range due {
+			h.startScheduled(ctx, b, s)
+		}
+		timer.Reset(wait)
+	}
+}
+
+// startScheduled starts a run of the schedule in the background, unless the previous run is still going.
+func (h *Handler) startScheduled(ctx context.Context, b *bot.Bot, s *Schedule) {
+	if !h.schedules.tryStart(s.Name) {
+		h.logger.Warn("Skipped scheduled run, as the previous run is still going", slog.String("schedule", s.Name))
+		return
+	}
+
+	h.wg.Go(func() {
+		defer h.schedules.done(s.Name)
+
+		if err := h.runScheduled(ctx, b, s); err != nil {
+			h.logger.Warn("Failed to run scheduled command",
+				slog.String("schedule", s.Name),
+				tslog.Err(err),
+			)
+			return
+		}
+
+		h.logger.Info("Ran scheduled command", slog.String("schedule", s.Name))
+	})
+}
+
+// runScheduled runs the schedule's command as a job, and posts the result to the schedule's chats
+// if the command's notify policy calls for it.
+func (h *Handler) runScheduled(ctx context.Context, b *bot.Bot, s *Schedule) error {
+	j := h.jobs.start(ctx, s.UserID, s.Chats[0].ID, &s.Command, -1, s.args, nil)
+	defer h.jobs.remove(j)
+	defer j.finish(StopResult{})
+
+	if err := h.scheduler.acquire(j.ctx, s.Command.priority, func(int) {}); err != nil {
+		j.finish(StopResult{Unstarted: true})
+		j.record(nil, "", "canceled before it started", false)
+		return err
+	}
+	defer h.scheduler.release()
+	j.markStarted()
+
+	result, _, err := j.runWithRetries(ctx, b, nil, h.logger)
+	defer j.artifacts.close()
+	if err != nil {
+		j.recordError(err)
+		return err
+	}
+	j.recordResult(&result)
+	j.recordBaseline(&h.baselines)
+
+	if !j.notifies() {
+		return nil
+	}
+
+	header := "🕒 *" + EscapeMarkdownV2Plaintext(s.Name) + "*\n"
+
+	var errs []error
+	for _, chat := range s.Chats {
+		target := &models.Message{
+			Chat:            models.Chat{ID: chat.ID},
+			MessageThreadID: chat.ThreadID,
+		}
+		if err := j.sendResponse(ctx, b, target, nil, header, &result, nil, h.logger); err != nil {
+			errs = append(errs, fmt.Errorf("chat %d: %w", chat.ID, err))
+			continue
+		}
+		if err := sendArtifacts(ctx, b, target, &j.artifacts); err != nil {
+			errs = append(errs, fmt.Errorf("chat %d: %w", chat.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// newSchedulesHandler returns a new handler that handles the `/schedules` command.
+func newSchedulesHandler(
+	schedules *scheduleManager,
+) func(ctx context.Context, b *bot.Bot, message *models.Message, cmdArg string, commands []Command) error {
+	return func(ctx context.Context, b *bot.Bot, message *models.Message, _ string, _ []Command) error {
+		statuses := schedules.statuses()
+
+		var sb strings.Builder
+		if len(statuses) == 0 {
+			sb.WriteString("No schedules are configured\\.")
+		}
+		for _, status := range statuses {
+			sb.WriteString("*")
+			sb.WriteString(EscapeMarkdownV2Plaintext(status.name))
+			sb.WriteString("* `")
+			sb.WriteString(EscapeMarkdownV2CodeBlock(status.spec))
+			sb.WriteString("`\n    next run: ")
+			if status.next.IsZero() {
+				sb.WriteString("never")
+			} else {
+				sb.WriteString(EscapeMarkdownV2Plaintext(status.next.Format("2006-01-02 15:04:05 MST")))
+			}
+			if status.running {
+				sb.WriteString(" \\(running\\)")
+			}
+			sb.WriteByte('\n')
+		}
+
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:          message.Chat.ID,
+			MessageThreadID: message.MessageThreadID,
+			Text:            sb.String(),
+			ParseMode:       models.ParseModeMarkdown,
+			ReplyParameters: &models.ReplyParameters{
+				MessageID: message.ID,
+			},
+		})
+		return err
+	}
+}
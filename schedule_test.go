@@ -0,0 +1,154 @@
+package rcebot
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestScheduleManagerDSTFallBack(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Clocks fall back from 02:00 EDT to 01:00 EST on 2026-11-01, repeating the hour from 01:00.
+	from := time.Date(2026, time.October, 31, 23, 0, 0, 0, loc)
+	until := time.Date(2026, time.November, 2, 3, 0, 0, 0, loc)
+
+	for _, c := range [...]struct {
+		spec string
+		want []time.Time
+	}{
+		{
+			spec: "30 1 * * *",
+			want: []time.Time{
+				time.Date(2026, time.November, 1, 5, 30, 0, 0, time.UTC),
+				time.Date(2026, time.November, 2, 6, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			spec: "0,30 1,2 1 11 *",
+			want: []time.Time{
+				time.Date(2026, time.November, 1, 5, 0, 0, 0, time.UTC),
+				time.Date(2026, time.November, 1, 5, 30, 0, 0, time.UTC),
+				time.Date(2026, time.November, 1, 7, 0, 0, 0, time.UTC),
+				time.Date(2026, time.November, 1, 7, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			// Fixed intervals are not affected by the wall clock.
+			spec: "@every 30m",
+			want: []time.Time{
+				time.Date(2026, time.November, 1, 3, 30, 0, 0, time.UTC),
+			},
+		},
+	} {
+		t.Run(c.spec, func(t *testing.T) {
+			cron, err := ParseCronSchedule(c.spec)
+			if err != nil {
+				t.Fatalf("ParseCronSchedule(%q) = %v", c.spec, err)
+			}
+			m := scheduleManager{
+				schedules: []Schedule{{Name: "test", Spec: c.spec, cron: cron, location: loc}},
+				nextTimes: make([]time.Time, 1),
+			}
+
+			var fired []time.Time
+			now := from
+			for now.Before(until) {
+				due, wait := m.due(now)
+				if len(due) > 0 {
+					fired = append(fired, now)
+				}
+				now = now.Add(wait)
+			}
+
+			if cron.every > 0 {
+				// Only check that the interval is kept across the transition.
+				for i := 1; i < len(fired); i++ {
+					if d := fired[i].Sub(fired[i-1]); d != 30*time.Minute {
+						t.Fatalf("run %d is %s after the previous one, want 30m", i, d)
+					}
+				}
+				if len(fired) == 0 || !fired[0].Equal(c.want[0]) {
+					t.Fatalf("first run = %v, want %v", fired, c.want[0])
+				}
+				return
+			}
+
+			if len(fired) != len(c.want) {
+				t.Fatalf("fired at %v, want %v", fired, c.want)
+			}
+			for i := range fired {
+				if !fired[i].Equal(c.want[i]) {
+					t.Errorf("run %d at %v, want %v", i, fired[i].UTC(), c.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestScheduleManagerReplaceKeepsLastRun(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cron, err := ParseCronSchedule("30 1 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedules := []Schedule{{Name: "test", Spec: "30 1 * * *", cron: cron, location: loc}}
+
+	m := scheduleManager{changed: make(chan struct{}, 1)}
+	m.replace(schedules)
+	firstRun := time.Date(2026, time.November, 1, 5, 30, 0, 0, time.UTC)
+	m.due(firstRun.Add(-time.Minute))
+	if due, _ := m.due(firstRun); len(due) != 1 {
+		t.Fatalf("m.due(%v) = %d schedules, want 1", firstRun, len(due))
+	}
+
+	// A config reload in the repeated hour does not make the schedule run again at the same wall-clock time.
+	m.replace(schedules)
+	m.due(firstRun.Add(10 * time.Minute))
+	m.mu.Lock()
+	next := m.nextTimes[0]
+	m.mu.Unlock()
+	if want := time.Date(2026, time.November, 2, 6, 30, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("next run after reload = %v, want %v", next.UTC(), want)
+	}
+}
+
+func TestConfigScheduledCommandsUserID(t *testing.T) {
+	for _, c := range [...]struct {
+		name    string
+		userID  int64
+		wantErr bool
+	}{
+		{"AdminOnly", 0, false},
+		{"Owner", 1, false},
+		{"UnknownUser", 2, true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			config := Config{
+				Users: []User{{ID: 1, Commands: []Command{{Name: "true"}}}},
+				Schedules: []Schedule{{
+					Name:    "test",
+					Spec:    "@hourly",
+					Chats:   []ScheduleChat{{ID: 1}},
+					UserID:  c.userID,
+					Command: Command{Name: "true"},
+				}},
+			}
+			_, err := config.ScheduledCommands()
+			if gotErr := err != nil; gotErr != c.wantErr {
+				t.Errorf("ScheduledCommands() = %v, want error %v", err, c.wantErr)
+			}
+		})
+	}
+
+	owned := &job{userID: 1, commandIndex: -1}
+	if got, want := jobOwner(owned), "scheduled for user 1"; got != want {
+		t.Errorf("jobOwner(owned) = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,148 @@
+package rcebot
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// jobScheduler limits the number of concurrently running jobs across all users and commands.
+//
+// Jobs that cannot start immediately wait in a queue, ordered by descending priority, then FIFO.
+type jobScheduler struct {
+	mu      sync.Mutex
+	limit   int
+	running int
+	queue   []*jobWaiter
+}
+
+// jobWaiter is a job waiting in the queue.
+type jobWaiter struct {
+	priority int
+	ready    chan struct{}
+
+	// moved is signaled when position changes.
+	moved chan struct{}
+
+	// position is the 1-based position of the waiter in the queue. It is protected by the scheduler's mutex.
+	position int
+}
+
+// setLimit sets the maximum number of concurrently running jobs.
+// Zero means no limit.
+func (s *jobScheduler) setLimit(limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit = limit
+	s.dispatchLocked()
+}
+
+// acquire blocks until the job may start or ctx is done.
+//
+// If the job has to wait, onQueued is called with its 1-based position in the queue,
+// and again whenever the position changes while it waits. onQueued is called on the calling goroutine.
+// On success, the caller must call [jobScheduler.release] when the job finishes.
+func (s *jobScheduler) acquire(ctx context.Context, priority int, onQueued func(position int)) error {
+	s.mu.Lock()
+	if s.hasFreeSlotLocked() && len(s.queue) == 0 {
+		s.running++
+		s.mu.Unlock()
+		return nil
+	}
+
+	w := &jobWaiter{
+		priority: priority,
+		ready:    make(chan struct{}),
+		moved:    make(chan struct{}, 1),
+	}
+
+	// Insert after all waiters with the same or higher priority.
+	i := slices.IndexFunc(s.queue, func(qw *jobWaiter) bool {
+		return qw.priority < priority
+	})
+	if i == -1 {
+		i = len(s.queue)
+	}
+	s.queue = slices.Insert(s.queue, i, w)
+	s.updatePositionsLocked()
+	// The initial position is reported below.
+	<-w.moved
+	s.mu.Unlock()
+
+	onQueued(i + 1)
+
+wait:
+	for {
+		select {
+		case <-w.ready:
+			return nil
+		case <-w.moved:
+			s.mu.Lock()
+			position := w.position
+			s.mu.Unlock()
+			select {
+			case <-w.ready:
+				return nil
+			default:
+				onQueued(position)
+			}
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	s.mu.Lock()
+	select {
+	case <-w.ready:
+		// We were dispatched after all. Give the slot to the next waiter.
+		s.running--
+		s.dispatchLocked()
+	default:
+		s.queue = slices.DeleteFunc(s.queue, func(qw *jobWaiter) bool {
+			return qw == w
+		})
+		s.updatePositionsLocked()
+	}
+	s.mu.Unlock()
+	return ctx.Err()
+}
+
+// release releases a slot acquired by [jobScheduler.acquire], and starts the next queued job, if any.
+func (s *jobScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running--
+	s.dispatchLocked()
+}
+
+func (s *jobScheduler) hasFreeSlotLocked() bool {
+	return s.limit <= 0 || s.running < s.limit
+}
+
+func (s *jobScheduler) dispatchLocked() {
+	var dispatched bool
+	for len(s.queue) > 0 && s.hasFreeSlotLocked() {
+		w := s.queue[0]
+		s.queue = s.queue[1:]
+		s.running++
+		close(w.ready)
+		dispatched = true
+	}
+	if dispatched {
+		s.updatePositionsLocked()
+	}
+}
+
+// updatePositionsLocked updates the positions of the waiters in the queue,
+// and signals those whose positions changed.
+func (s *jobScheduler) updatePositionsLocked() {
+	for i, w := range s.queue {
+		if w.position != i+1 {
+			w.position = i + 1
+			select {
+			case w.moved <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
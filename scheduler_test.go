@@ -0,0 +1,141 @@
+package rcebot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testWaiter is a job acquiring a slot from a [jobScheduler] in the background.
+type testWaiter struct {
+	positions chan int
+	acquired  chan error
+}
+
+func queueTestWaiter(ctx context.Context, s *jobScheduler, priority int) *testWaiter {
+	w := &testWaiter{
+		positions: make(chan int, 16),
+		acquired:  make(chan error, 1),
+	}
+	go func() {
+		w.acquired <- s.acquire(ctx, priority, func(position int) {
+			w.positions <- position
+		})
+	}()
+	return w
+}
+
+func (w *testWaiter) expectPosition(t *testing.T, name string, want int) {
+	t.Helper()
+	select {
+	case got := <-w.positions:
+		if got != want {
+			t.Fatalf("%s position = %d, want %d", name, got, want)
+		}
+	case err := <-w.acquired:
+		t.Fatalf("%s acquired = %v, want position %d", name, err, want)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s position not reported, want %d", name, want)
+	}
+}
+
+func (w *testWaiter) expectAcquired(t *testing.T, name string, want error) {
+	t.Helper()
+	select {
+	case got := <-w.acquired:
+		if !errors.Is(got, want) {
+			t.Fatalf("%s acquired = %v, want %v", name, got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s did not acquire", name)
+	}
+}
+
+func (w *testWaiter) expectWaiting(t *testing.T, name string) {
+	t.Helper()
+	select {
+	case err := <-w.acquired:
+		t.Fatalf("%s acquired = %v, want waiting", name, err)
+	default:
+	}
+}
+
+func TestJobScheduler(t *testing.T) {
+	var s jobScheduler
+	s.setLimit(1)
+
+	ctx := t.Context()
+	if err := s.acquire(ctx, 0, func(int) {
+		t.Error("the first job was queued")
+	}); err != nil {
+		t.Fatalf("s.acquire() = %v", err)
+	}
+
+	// Waiters are ordered by descending priority, then FIFO.
+	a := queueTestWaiter(ctx, &s, 0)
+	a.expectPosition(t, "a", 1)
+	bCtx, bCancel := context.WithCancel(ctx)
+	defer bCancel()
+	b := queueTestWaiter(bCtx, &s, 0)
+	b.expectPosition(t, "b", 2)
+	c := queueTestWaiter(ctx, &s, 5)
+	c.expectPosition(t, "c", 1)
+	a.expectPosition(t, "a", 2)
+	b.expectPosition(t, "b", 3)
+	d := queueTestWaiter(ctx, &s, 0)
+	d.expectPosition(t, "d", 4)
+
+	// A canceled waiter leaves the queue without taking a slot.
+	bCancel()
+	b.expectAcquired(t, "b", context.Canceled)
+	d.expectPosition(t, "d", 3)
+	s.mu.Lock()
+	running, queued := s.running, len(s.queue)
+	s.mu.Unlock()
+	if running != 1 || queued != 3 {
+		t.Fatalf("running = %d, queued = %d, want 1, 3", running, queued)
+	}
+
+	// Each release hands the slot to the next waiter.
+	s.release()
+	c.expectAcquired(t, "c", nil)
+	a.expectPosition(t, "a", 1)
+	d.expectPosition(t, "d", 2)
+	a.expectWaiting(t, "a")
+
+	s.release()
+	a.expectAcquired(t, "a", nil)
+	d.expectPosition(t, "d", 1)
+	d.expectWaiting(t, "d")
+
+	s.release()
+	d.expectAcquired(t, "d", nil)
+
+	s.release()
+	s.mu.Lock()
+	running, queued = s.running, len(s.queue)
+	s.mu.Unlock()
+	if running != 0 || queued != 0 {
+		t.Errorf("running = %d, queued = %d, want 0, 0", running, queued)
+	}
+}
+
+func TestJobSchedulerSetLimit(t *testing.T) {
+	var s jobScheduler
+	s.setLimit(1)
+
+	ctx := t.Context()
+	if err := s.acquire(ctx, 0, func(int) {}); err != nil {
+		t.Fatalf("s.acquire() = %v", err)
+	}
+	a := queueTestWaiter(ctx, &s, 0)
+	a.expectPosition(t, "a", 1)
+	b := queueTestWaiter(ctx, &s, 0)
+	b.expectPosition(t, "b", 2)
+
+	// Removing the limit starts all waiters.
+	s.setLimit(0)
+	a.expectAcquired(t, "a", nil)
+	b.expectAcquired(t, "b", nil)
+}
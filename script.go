@@ -0,0 +1,117 @@
+package rcebot
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultInterpreter is the default interpreter of inline scripts.
+const DefaultInterpreter = "/bin/sh"
+
+// ScriptMode specifies how an inline script is passed to its interpreter.
+type ScriptMode string
+
+const (
+	// ScriptModeFile writes the script to a private temporary file,
+	// and passes its path to the interpreter before the command arguments.
+	// The file is removed after the command exits.
+	ScriptModeFile ScriptMode = "file"
+
+	// ScriptModeStdin pipes the script to the interpreter's standard input.
+	// The interpreter arguments must make it read the script from standard input (e.g., "/bin/sh -s", "python3 -").
+	ScriptModeStdin ScriptMode = "stdin"
+)
+
+// IsValid returns whether the mode is a known mode.
+func (m ScriptMode) IsValid() bool {
+	switch m {
+	case ScriptModeFile, ScriptModeStdin:
+		return true
+	default:
+		return false
+	}
+}
+
+// initScript validates the inline script settings of the command.
+func (c *Command) initScript() error {
+	if c.Script == "" {
+		if c.Interpreter != "" || c.ScriptMode != "" {
+			return errors.New("interpreter and script mode require a script")
+		}
+		return nil
+	}
+
+	if c.Interpreter == "" {
+		c.Interpreter = DefaultInterpreter
+	}
+	if len(strings.Fields(c.Interpreter)) == 0 {
+		return errors.New("empty interpreter")
+	}
+
+	if c.ScriptMode == "" {
+		c.ScriptMode = ScriptModeFile
+	}
+	if !c.ScriptMode.IsValid() {
+		return fmt.Errorf("unknown script mode %q", c.ScriptMode)
+	}
+
+	switch c.ScriptMode {
+	case ScriptModeFile:
+		if c.tempDirHidden() {
+			return errors.New("script files in the temporary directory are hidden by the private /tmp of the sandbox, use the stdin script mode instead")
+		}
+	case ScriptModeStdin:
+		if c.Stdin != StdinModeNone {
+			return errors.New("scripts passed on stdin cannot be combined with stdin from messages or documents")
+		}
+		if c.Interactive {
+			return errors.New("scripts passed on stdin cannot be run in interactive sessions")
+		}
+	}
+
+	return nil
+}
+
+// describeScript returns a short human-readable description of the command's inline script.
+func (c *Command) describeScript() string {
+	lines := strings.Count(strings.TrimSuffix(c.Script, "\n"), "\n") + 1
+	var sb strings.Builder
+	sb.WriteString("inline script (")
+	sb.WriteString(strconv.Itoa(lines))
+	if lines == 1 {
+		sb.WriteString(" line")
+	} else {
+		sb.WriteString(" lines")
+	}
+	sb.WriteString(") run by ")
+	sb.WriteString(c.Interpreter)
+	return sb.String()
+}
+
+// writeScriptFile writes the command's inline script to a new private temporary file, readable by the user
+// the command runs as, and returns its path and a function that removes it.
+func (c *Command) writeScriptFile() (path string, remove func(), err error) {
+	dir, remove, err := c.mkdirTemp("cubic-rce-bot-script-")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create script directory: %w", err)
+	}
+
+	path = filepath.Join(dir, "script")
+	if err = os.WriteFile(path, []byte(c.Script), 0o600); err != nil {
+		remove()
+		return "", nil, fmt.Errorf("failed to write script file: %w", err)
+	}
+
+	if c.credential != nil {
+		if err = os.Chown(path, int(c.credential.uid), int(c.credential.gid)); err != nil {
+			remove()
+			return "", nil, fmt.Errorf("failed to change owner of script file: %w", err)
+		}
+	}
+
+	return path, remove, nil
+}
@@ -0,0 +1,202 @@
+package rcebot
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestCommandInitScript(t *testing.T) {
+	for _, c := range [...]struct {
+		name            string
+		command         *Command
+		wantInterpreter string
+		wantMode        ScriptMode
+		expectErr       bool
+	}{
+		{
+			name:            "Defaults",
+			command:         &Command{Name: "hello", Script: "echo hello\n"},
+			wantInterpreter: DefaultInterpreter,
+			wantMode:        ScriptModeFile,
+		},
+		{
+			name:            "StdinMode",
+			command:         &Command{Name: "hello", Script: "print('hello')", Interpreter: "python3 -", ScriptMode: ScriptModeStdin},
+			wantInterpreter: "python3 -",
+			wantMode:        ScriptModeStdin,
+		},
+		{
+			name:            "FileModeWithStdin",
+			command:         &Command{Name: "hello", Script: "cat", Stdin: StdinModeMessage},
+			wantInterpreter: DefaultInterpreter,
+			wantMode:        ScriptModeFile,
+		},
+		{
+			name:      "StdinModeWithStdin",
+			command:   &Command{Name: "hello", Script: "cat", ScriptMode: ScriptModeStdin, Stdin: StdinModeMessage},
+			expectErr: true,
+		},
+		{
+			name:      "StdinModeWithDocument",
+			command:   &Command{Name: "hello", Script: "cat", ScriptMode: ScriptModeStdin, Stdin: StdinModeDocument},
+			expectErr: true,
+		},
+		{
+			name:      "BlankInterpreter",
+			command:   &Command{Name: "hello", Script: "echo hello", Interpreter: " \t"},
+			expectErr: true,
+		},
+		{
+			name:      "UnknownMode",
+			command:   &Command{Name: "hello", Script: "echo hello", ScriptMode: "argv"},
+			expectErr: true,
+		},
+		{
+			name:      "InterpreterWithoutScript",
+			command:   &Command{Name: "hello", Interpreter: "/bin/bash"},
+			expectErr: true,
+		},
+		{
+			name:      "ModeWithoutScript",
+			command:   &Command{Name: "hello", ScriptMode: ScriptModeFile},
+			expectErr: true,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			command := c.command
+			err := command.init()
+			if c.expectErr {
+				if err == nil {
+					t.Error("command.init() = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("command.init() = %v", err)
+			}
+			if command.Interpreter != c.wantInterpreter {
+				t.Errorf("command.Interpreter = %q, want %q", command.Interpreter, c.wantInterpreter)
+			}
+			if command.ScriptMode != c.wantMode {
+				t.Errorf("command.ScriptMode = %q, want %q", command.ScriptMode, c.wantMode)
+			}
+		})
+	}
+}
+
+func TestCommandNewCmdScript(t *testing.T) {
+	const script = "echo \"$@\"\n"
+
+	t.Run("File", func(t *testing.T) {
+		command := Command{Name: "hello", Script: script, Interpreter: "/bin/sh  -eu"}
+		if err := command.init(); err != nil {
+			t.Fatalf("command.init() = %v", err)
+		}
+
+		cmd, cleanup, err := command.newCmd([]string{"a", "b c"}, "")
+		if err != nil {
+			t.Fatalf("command.newCmd() = %v", err)
+		}
+
+		if len(cmd.Args) != 5 {
+			t.Fatalf("cmd.Args = %q, want 5 arguments", cmd.Args)
+		}
+		path := cmd.Args[2]
+		if want := []string{"/bin/sh", "-eu", path, "a", "b c"}; !slices.Equal(cmd.Args, want) {
+			t.Errorf("cmd.Args = %q, want %q", cmd.Args, want)
+		}
+		if cmd.Path != "/bin/sh" {
+			t.Errorf("cmd.Path = %q, want %q", cmd.Path, "/bin/sh")
+		}
+		if cmd.Stdin != nil {
+			t.Error("cmd.Stdin is set in file mode")
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read script file: %v", err)
+		}
+		if string(data) != script {
+			t.Errorf("script file = %q, want %q", data, script)
+		}
+
+		cleanup()
+		if _, err = os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("script file still exists after cleanup: %v", err)
+		}
+	})
+
+	t.Run("Stdin", func(t *testing.T) {
+		command := Command{Name: "hello", Script: script, Interpreter: "/bin/sh -s", ScriptMode: ScriptModeStdin}
+		if err := command.init(); err != nil {
+			t.Fatalf("command.init() = %v", err)
+		}
+
+		for range 2 {
+			cmd, cleanup, err := command.newCmd([]string{"a"}, "")
+			if err != nil {
+				t.Fatalf("command.newCmd() = %v", err)
+			}
+			defer cleanup()
+
+			if want := []string{"/bin/sh", "-s", "a"}; !slices.Equal(cmd.Args, want) {
+				t.Errorf("cmd.Args = %q, want %q", cmd.Args, want)
+			}
+			if cmd.Stdin == nil {
+				t.Fatal("cmd.Stdin is not set in stdin mode")
+			}
+			data, err := io.ReadAll(cmd.Stdin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != script {
+				t.Errorf("cmd.Stdin = %q, want %q", data, script)
+			}
+		}
+	})
+}
+
+func TestHandleListScript(t *testing.T) {
+	b, api := newFakeBotAPI(t, func(req fakeBotAPIRequest) fakeBotAPIResponse {
+		if req.Method == "sendMessage" {
+			return fakeBotAPIResponse{Result: models.Message{ID: 100}}
+		}
+		return fakeBotAPIResponse{ErrorCode: http.StatusNotFound, Description: "Not Found"}
+	})
+
+	const secret = "curl -H 'Authorization: Bearer s3cr3t' https://example.com"
+	commands := []Command{{
+		Name:        "deploy",
+		Description: "Deploy the site",
+		Script:      "set -x\n" + secret + "\n",
+		Interpreter: "/bin/sh -eu",
+	}}
+	if err := commands[0].init(); err != nil {
+		t.Fatalf("commands[0].init() = %v", err)
+	}
+
+	message := &models.Message{ID: 1, Chat: models.Chat{ID: 2}, From: &models.User{ID: 1}}
+	if err := handleList(t.Context(), b, message, "", commands); err != nil {
+		t.Fatalf("handleList() = %v", err)
+	}
+
+	sends := api.Requests("sendMessage")
+	if len(sends) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sends))
+	}
+	text := sends[0].Form.Get("text")
+	if want := "script: inline script \\(2 lines\\) run by /bin/sh \\-eu"; !strings.Contains(text, want) {
+		t.Errorf("list = %q, want it to contain %q", text, want)
+	}
+	for _, s := range [...]string{"set -x", "s3cr3t", "curl"} {
+		if strings.Contains(text, s) {
+			t.Errorf("list = %q, contains %q from the script body", text, s)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package rcebot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultIdleTimeout is the default duration after which an interactive session without input or output is stopped.
+	DefaultIdleTimeout = 5 * time.Minute
+
+	// ptyRows and ptyCols are the dimensions of the pseudo-terminal allocated for interactive sessions.
+	ptyRows = 24
+	ptyCols = 80
+)
+
+// errSessionIdle is the cancellation cause of an interactive session that idled out.
+var errSessionIdle = errors.New("session idled out")
+
+// ptySession is an interactive session of a command running on a pseudo-terminal.
+type ptySession struct {
+	master       *os.File
+	tty          *os.File
+	lastActivity atomic.Int64
+	copyDone     chan struct{}
+}
+
+// newPTYSession allocates a pseudo-terminal and attaches cmd to it.
+// Output read from the terminal is written to output with escape sequences removed.
+//
+// The caller must call [ptySession.close] after the command exits.
+func newPTYSession(cmd *exec.Cmd, c *Command, output io.Writer) (*ptySession, error) {
+	master, tty, err := openPTY(ptyRows, ptyCols)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd.Stdin = tty
+	cmd.Stdout = tty
+	cmd.Stderr = tty
+	setPTYSysProcAttr(cmd)
+
+	// Discourage programs from emitting escape sequences, unless the command sets its own terminal type.
+	if _, ok := c.Env["TERM"]; !ok {
+		env := cmd.Env
+		if env == nil {
+			env = os.Environ()
+		}
+		cmd.Env = append(env, "TERM=dumb")
+	}
+
+	s := ptySession{
+		master:   master,
+		tty:      tty,
+		copyDone: make(chan struct{}),
+	}
+	s.touch()
+
+	go func() {
+		defer close(s.copyDone)
+		_, _ = io.Copy(&activityWriter{w: &ansiStripper{w: output}, s: &s}, master)
+	}()
+
+	return &s, nil
+}
+
+// touch records activity on the session.
+func (s *ptySession) touch() {
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+// writeInput types text into the terminal, one line at a time.
+func (s *ptySession) writeInput(text string) error {
+	s.touch()
+	_, err := io.WriteString(s.master, strings.ReplaceAll(text, "\n", "\r")+"\r")
+	return err
+}
+
+// watchIdle calls cancel with [errSessionIdle] when the session has had no activity for timeout,
+// and returns when ctx is done.
+func (s *ptySession) watchIdle(ctx context.Context, timeout time.Duration, cancel context.CancelCauseFunc) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		idle := time.Since(time.Unix(0, s.lastActivity.Load()))
+		if idle >= timeout {
+			cancel(fmt.Errorf("%w after %s", errSessionIdle, timeout))
+			return
+		}
+		timer.Reset(timeout - idle)
+	}
+}
+
+// close releases the terminal after the command exits. It waits up to waitDelay for
+// remaining output to be read, in case a descendant still holds the terminal open.
+func (s *ptySession) close(waitDelay time.Duration) {
+	// Reading from the master end fails once all slave ends are closed.
+	_ = s.tty.Close()
+
+	timer := time.NewTimer(waitDelay)
+	select {
+	case <-s.copyDone:
+	case <-timer.C:
+	}
+	timer.Stop()
+
+	_ = s.master.Close()
+	<-s.copyDone
+}
+
+// activityWriter records activity on the session for every write.
+type activityWriter struct {
+	w io.Writer
+	s *ptySession
+}
+
+// Write implements [io.Writer].
+func (w *activityWriter) Write(p []byte) (int, error) {
+	w.s.touch()
+	return w.w.Write(p)
+}
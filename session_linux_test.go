@@ -0,0 +1,88 @@
+package rcebot
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/database64128/cubic-rce-bot/jsoncfg"
+	"github.com/database64128/cubic-rce-bot/tslog"
+)
+
+func TestJobRunInteractiveSession(t *testing.T) {
+	const idleTimeout = 500 * time.Millisecond
+	command := Command{
+		Name:        "sh",
+		Args:        []string{"-c", `echo "TERM=$TERM"; [ -t 0 ] && echo "stdin is a terminal"; exec cat`},
+		OutputMode:  OutputModeMerged,
+		Interactive: true,
+		IdleTimeout: jsoncfg.Duration(idleTimeout),
+	}
+	if err := command.init(); err != nil {
+		t.Fatalf("command.init() = %v", err)
+	}
+	if command.ExecTimeout != 0 {
+		t.Errorf("command.ExecTimeout = %v, want no timeout for interactive sessions", command.ExecTimeout.Value())
+	}
+
+	j := newJob(t.Context(), 1, 2, &command, 0, command.Args, nil)
+	logger := tslog.Config{}.NewLogger(io.Discard)
+
+	type runResult struct {
+		result CommandResult
+		err    error
+	}
+	done := make(chan runResult, 1)
+	go func() {
+		result, _, err := j.run(t.Context(), nil, nil, logger)
+		done <- runResult{result, err}
+	}()
+
+	// waitOutput waits for the output to contain want at least n times.
+	waitOutput := func(want string, n int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for strings.Count(string(j.output.Bytes()), want) < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("output = %q, want %q at least %d times", j.output.Bytes(), want, n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitOutput("stdin is a terminal", 1)
+	if output := string(j.output.Bytes()); !strings.Contains(output, "TERM=dumb\n") {
+		t.Errorf("output = %q, want TERM=dumb", output)
+	}
+
+	session := j.session.Load()
+	if session == nil {
+		t.Fatal("job has no session while running")
+	}
+	if err := session.writeInput("hello"); err != nil {
+		t.Fatalf("session.writeInput() = %v", err)
+	}
+	// The line is echoed by the terminal, and then again by cat.
+	waitOutput("hello", 2)
+	idleFrom := time.Now()
+
+	var r runResult
+	select {
+	case r = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("session was not stopped after idling out")
+	}
+	if r.err != nil {
+		t.Fatalf("j.run() = %v", r.err)
+	}
+	if elapsed := time.Since(idleFrom); elapsed < idleTimeout/2 {
+		t.Errorf("session was stopped after %v without activity, want about %v", elapsed, idleTimeout)
+	}
+	if r.result.Err == nil || !strings.Contains(r.result.Err.Error(), errSessionIdle.Error()) {
+		t.Errorf("result error = %v, want %q", r.result.Err, errSessionIdle)
+	}
+	if j.session.Load() != nil {
+		t.Error("job still has a session after the command exited")
+	}
+}
@@ -0,0 +1,188 @@
+package rcebot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// DefaultMaxStdinSize is the default maximum size of a command's standard input in bytes.
+const DefaultMaxStdinSize = 1 << 20
+
+// fileDownloadTimeout bounds the time it takes to download a file, so that a stalled download
+// does not hold up the command, nor the bot's shutdown.
+const fileDownloadTimeout = 2 * time.Minute
+
+// StdinMode specifies where a command's standard input comes from.
+type StdinMode string
+
+const (
+	// StdinModeNone runs the command with no standard input.
+	StdinModeNone StdinMode = "none"
+
+	// StdinModeMessage pipes the text of the message that `/exec` replies to.
+	StdinModeMessage StdinMode = "message"
+
+	// StdinModeDocument pipes the contents of the document that `/exec` is the caption of,
+	// or of the document in the message that `/exec` replies to.
+	StdinModeDocument StdinMode = "document"
+
+	// StdinModeAny accepts either a document or the text of a replied-to message, preferring documents.
+	StdinModeAny StdinMode = "any"
+)
+
+// IsValid returns whether the mode is a known mode.
+func (m StdinMode) IsValid() bool {
+	switch m {
+	case StdinModeNone, StdinModeMessage, StdinModeDocument, StdinModeAny:
+		return true
+	default:
+		return false
+	}
+}
+
+// allowsMessage returns whether the mode accepts the text of a replied-to message.
+func (m StdinMode) allowsMessage() bool {
+	return m == StdinModeMessage || m == StdinModeAny
+}
+
+// allowsDocument returns whether the mode accepts a document.
+func (m StdinMode) allowsDocument() bool {
+	return m == StdinModeDocument || m == StdinModeAny
+}
+
+// Describe returns a human-readable description of where the standard input comes from.
+func (m StdinMode) Describe() string {
+	switch m {
+	case StdinModeMessage:
+		return "text of the replied-to message"
+	case StdinModeDocument:
+		return "attached or replied-to document"
+	case StdinModeAny:
+		return "attached or replied-to document, or text of the replied-to message"
+	default:
+		return "none"
+	}
+}
+
+// initStdin validates the standard input settings of the command.
+func (c *Command) initStdin() error {
+	if c.Stdin == "" {
+		c.Stdin = StdinModeNone
+	}
+	if !c.Stdin.IsValid() {
+		return fmt.Errorf("unknown stdin mode %q", c.Stdin)
+	}
+
+	if c.MaxStdinSize < 0 {
+		return fmt.Errorf("negative max stdin size %d", c.MaxStdinSize)
+	}
+	if c.MaxStdinSize == 0 {
+		c.MaxStdinSize = DefaultMaxStdinSize
+	}
+	return nil
+}
+
+// errStdinTooLarge is returned when the standard input exceeds the command's size limit.
+var errStdinTooLarge = errors.New("input is too large")
+
+// readStdin returns the standard input for the command from message, according to the command's stdin mode.
+// It returns nil if the command takes no standard input.
+//
+// A document attached to message takes precedence over one in the replied-to message,
+// which in turn takes precedence over the text of the replied-to message.
+func (c *Command) readStdin(ctx context.Context, b *bot.Bot, message *models.Message) ([]byte, error) {
+	if c.Stdin == StdinModeNone {
+		return nil, nil
+	}
+
+	reply := message.ReplyToMessage
+
+	if c.Stdin.allowsDocument() {
+		document := message.Document
+		if document == nil && reply != nil {
+			document = reply.Document
+		}
+		if document != nil {
+			if document.FileSize > c.MaxStdinSize {
+				return nil, fmt.Errorf("%w: document is %d bytes, limit is %d bytes", errStdinTooLarge, document.FileSize, c.MaxStdinSize)
+			}
+			return downloadFile(ctx, b, document.FileID, c.MaxStdinSize)
+		}
+	}
+
+	if c.Stdin.allowsMessage() && reply != nil && reply.Text != "" {
+		if int64(len(reply.Text)) > c.MaxStdinSize {
+			return nil, fmt.Errorf("%w: message is %d bytes, limit is %d bytes", errStdinTooLarge, len(reply.Text), c.MaxStdinSize)
+		}
+		return []byte(reply.Text), nil
+	}
+
+	return nil, errors.New("the command reads its input from the " + c.Stdin.Describe())
+}
+
+// downloadFile downloads the file with the given ID, failing if it is larger than maxSize bytes,
+// or if it takes longer than [fileDownloadTimeout].
+func downloadFile(ctx context.Context, b *bot.Bot, fileID string, maxSize int64) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, fileDownloadTimeout)
+	defer cancel()
+
+	file, err := b.GetFile(ctx, &bot.GetFileParams{
+		FileID: fileID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+
+	// A local Bot API server returns the absolute path of the file on its file system.
+	if filepath.IsAbs(file.FilePath) {
+		f, err := os.Open(file.FilePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		defer f.Close()
+		return readAllLimited(f, maxSize)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.FileDownloadLink(file), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// Do not leak the bot token in the download link.
+		if urlErr, ok := errors.AsType[*url.Error](err); ok {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download file: unexpected status %s", resp.Status)
+	}
+
+	return readAllLimited(resp.Body, maxSize)
+}
+
+// readAllLimited reads r until EOF, failing if it yields more than maxSize bytes.
+func readAllLimited(r io.Reader, maxSize int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%w: limit is %d bytes", errStdinTooLarge, maxSize)
+	}
+	return data, nil
+}
@@ -0,0 +1,119 @@
+package rcebot
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestReadAllLimited(t *testing.T) {
+	for _, c := range [...]struct {
+		name      string
+		input     string
+		maxSize   int64
+		expectErr bool
+	}{
+		{"Empty", "", 4, false},
+		{"UnderLimit", "abc", 4, false},
+		{"AtLimit", "abcd", 4, false},
+		{"OverLimit", "abcde", 4, true},
+		{"ZeroLimit", "a", 0, true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			data, err := readAllLimited(strings.NewReader(c.input), c.maxSize)
+			if c.expectErr {
+				if !errors.Is(err, errStdinTooLarge) {
+					t.Errorf("readAllLimited() error = %v, want %v", err, errStdinTooLarge)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readAllLimited() = %v", err)
+			}
+			if string(data) != c.input {
+				t.Errorf("readAllLimited() = %q, want %q", data, c.input)
+			}
+		})
+	}
+}
+
+func TestDownloadFile(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "local.txt")
+	if err := os.WriteFile(localPath, []byte("local"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, api := newFakeBotAPI(t, func(req fakeBotAPIRequest) fakeBotAPIResponse {
+		if req.Method != "getFile" {
+			return fakeBotAPIResponse{ErrorCode: http.StatusNotFound, Description: "Not Found"}
+		}
+		fileID := req.Form.Get("file_id")
+		if fileID == "missing" {
+			return fakeBotAPIResponse{ErrorCode: http.StatusBadRequest, Description: "Bad Request: invalid file_id"}
+		}
+		filePath := "documents/" + fileID
+		if fileID == "local" {
+			filePath = localPath
+		}
+		return fakeBotAPIResponse{Result: models.File{FileID: fileID, FilePath: filePath}}
+	})
+
+	const filePrefix = "GET /file/bot" + fakeBotToken + "/documents/"
+	api.Mux.HandleFunc(filePrefix+"data", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("abcd"))
+	})
+	api.Mux.HandleFunc(filePrefix+"gone", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+	})
+	api.Mux.HandleFunc(filePrefix+"reset", func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("failed to hijack connection: %v", err)
+			return
+		}
+		_ = conn.Close()
+	})
+
+	for _, c := range [...]struct {
+		name      string
+		fileID    string
+		maxSize   int64
+		want      string
+		expectErr error
+	}{
+		{name: "AtLimit", fileID: "data", maxSize: 4, want: "abcd"},
+		{name: "OverLimit", fileID: "data", maxSize: 3, expectErr: errStdinTooLarge},
+		{name: "LocalAtLimit", fileID: "local", maxSize: 5, want: "local"},
+		{name: "LocalOverLimit", fileID: "local", maxSize: 4, expectErr: errStdinTooLarge},
+		{name: "GetFileError", fileID: "missing", maxSize: 4},
+		{name: "StatusError", fileID: "gone", maxSize: 4},
+		{name: "ConnectionError", fileID: "reset", maxSize: 4},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			data, err := downloadFile(t.Context(), b, c.fileID, c.maxSize)
+			if c.want != "" {
+				if err != nil {
+					t.Fatalf("downloadFile() = %v", err)
+				}
+				if string(data) != c.want {
+					t.Errorf("downloadFile() = %q, want %q", data, c.want)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("downloadFile() = nil, want error")
+			}
+			if c.expectErr != nil && !errors.Is(err, c.expectErr) {
+				t.Errorf("downloadFile() error = %v, want %v", err, c.expectErr)
+			}
+			if secret := fakeBotToken[strings.IndexByte(fakeBotToken, ':')+1:]; strings.Contains(err.Error(), secret) {
+				t.Errorf("downloadFile() error %q leaks the bot token", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package rcebot
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/database64128/cubic-rce-bot/jsoncfg"
+)
+
+// signalNames maps signal names without the "SIG" prefix to signals available on all platforms.
+// Platform-specific signals are added by init functions.
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"ILL":  syscall.SIGILL,
+	"TRAP": syscall.SIGTRAP,
+	"ABRT": syscall.SIGABRT,
+	"BUS":  syscall.SIGBUS,
+	"FPE":  syscall.SIGFPE,
+	"KILL": syscall.SIGKILL,
+	"SEGV": syscall.SIGSEGV,
+	"PIPE": syscall.SIGPIPE,
+	"ALRM": syscall.SIGALRM,
+	"TERM": syscall.SIGTERM,
+}
+
+// Signal is a [syscall.Signal] that can be specified by name (e.g., "SIGTERM" or "TERM") or number in text form.
+type Signal syscall.Signal
+
+// Value returns the signal as [syscall.Signal].
+func (s Signal) Value() syscall.Signal {
+	return syscall.Signal(s)
+}
+
+// String returns the signal name, or its number if it has no known name.
+func (s Signal) String() string {
+	for name, sig := range signalNames {
+		if sig == syscall.Signal(s) {
+			return "SIG" + name
+		}
+	}
+	return strconv.Itoa(int(s))
+}
+
+// MarshalText implements [encoding.TextMarshaler].
+func (s Signal) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements [encoding.TextUnmarshaler].
+func (s *Signal) UnmarshalText(text []byte) error {
+	name := strings.TrimPrefix(strings.ToUpper(string(text)), "SIG")
+	if sig, ok := signalNames[name]; ok {
+		*s = Signal(sig)
+		return nil
+	}
+
+	n, err := strconv.Atoi(string(text))
+	if err != nil || n <= 0 {
+		return fmt.Errorf("unknown signal %q", text)
+	}
+	*s = Signal(n)
+	return nil
+}
+
+// StopStep is a step in the ladder of signals sent to stop a command.
+type StopStep struct {
+	// Signal is the signal to send to the command's process group.
+	Signal Signal `json:"signal"`
+
+	// Wait is how long to wait for the command to exit before proceeding to the next step.
+	//
+	// It may only be zero for the last step, in which case [DefaultExitTimeout] is used.
+	Wait jsoncfg.Duration `json:"wait,omitzero"`
+}
+
+// stopLadder returns the effective stop signal ladder of the command.
+func (c *Command) stopLadder() []StopStep {
+	if len(c.StopSignals) > 0 {
+		return c.StopSignals
+	}
+	return []StopStep{
+		{Signal: Signal(syscall.SIGINT), Wait: c.ExitTimeout},
+		{Signal: Signal(syscall.SIGKILL), Wait: jsoncfg.Duration(DefaultExitTimeout)},
+	}
+}
+
+// initStopSignals validates the stop signal ladder.
+func (c *Command) initStopSignals() error {
+	for i := range c.StopSignals {
+		step := &c.StopSignals[i]
+		if step.Signal <= 0 {
+			return fmt.Errorf("stop step %d: missing signal", i)
+		}
+		if step.Wait == 0 {
+			if i != len(c.StopSignals)-1 {
+				return fmt.Errorf("stop step %d: wait must be positive for all but the last step", i)
+			}
+			step.Wait = jsoncfg.Duration(DefaultExitTimeout)
+		}
+	}
+	return nil
+}
+
+// StopResult describes how a command was stopped.
+type StopResult struct {
+	// Step is the 1-based index of the step in the stop signal ladder whose signal the command exited after,
+	// or 0 if the command exited without being signaled.
+	//
+	// If the command survived the entire ladder, it is one past the last step, for the final SIGKILL.
+	Step int
+
+	// Steps is the number of steps in the stop signal ladder.
+	Steps int
+
+	// Signal is the last signal sent to the command's process group.
+	Signal Signal
+
+	// Unstarted is true if the job was canceled while waiting in the queue, before the command was started.
+	Unstarted bool
+}
+
+// String returns a human-readable description of the result.
+func (r StopResult) String() string {
+	switch {
+	case r.Unstarted:
+		return "was removed from the queue before it started"
+	case r.Step == 0:
+		return "exited without being signaled"
+	case r.Step > r.Steps:
+		return "stopped by the final " + r.Signal.String() + " after all " + strconv.Itoa(r.Steps) + " steps"
+	default:
+		return "stopped by " + r.Signal.String() + " at step " + strconv.Itoa(r.Step) + " of " + strconv.Itoa(r.Steps)
+	}
+}
+
+// run starts cmd and waits for it to exit. When ctx is done before then, it walks the stop signal ladder,
+// signaling the command's process group, until the command exits, and then kills the rest of the group.
+func (c *Command) run(ctx context.Context, cmd *exec.Cmd) (StopResult, error) {
+	ladder := c.stopLadder()
+	result := StopResult{Steps: len(ladder)}
+
+	if err := cmd.Start(); err != nil {
+		return result, err
+	}
+
+	waitCh := make(chan error, 1)
+	go func() {
+		waitCh <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-waitCh:
+		return result, err
+	case <-ctx.Done():
+	}
+
+	for i, step := range ladder {
+		result.Step = i + 1
+		result.Signal = step.Signal
+		_ = signalProcessGroup(cmd.Process, step.Signal.Value())
+
+		timer := time.NewTimer(step.Wait.Value())
+		select {
+		case err := <-waitCh:
+			timer.Stop()
+			// Kill the descendants that survived the signal, such as the background jobs of a shell,
+			// which ignore SIGINT.
+			_ = signalProcessGroup(cmd.Process, syscall.SIGKILL)
+			return result, err
+		case <-timer.C:
+		}
+	}
+
+	result.Step++
+	result.Signal = Signal(syscall.SIGKILL)
+	_ = signalProcessGroup(cmd.Process, syscall.SIGKILL)
+	return result, <-waitCh
+}
@@ -0,0 +1,131 @@
+package rcebot
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/database64128/cubic-rce-bot/jsoncfg"
+)
+
+// startStopTest runs the command in the background until ctx is done, and returns its command,
+// a reader of the first lines of its standard output, and a channel that receives its stop result.
+func startStopTest(t *testing.T, ctx context.Context, command *Command) (*exec.Cmd, *bufio.Reader, <-chan StopResult) {
+	t.Helper()
+
+	if err := command.init(); err != nil {
+		t.Fatalf("command.init() = %v", err)
+	}
+	cmd, cleanup, err := command.newCmd(command.Args, "")
+	if err != nil {
+		t.Fatalf("command.newCmd() = %v", err)
+	}
+	t.Cleanup(cleanup)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = r.Close()
+	})
+	cmd.Stdout = w
+
+	done := make(chan StopResult, 1)
+	go func() {
+		defer w.Close()
+		result, err := command.run(ctx, cmd)
+		if err != nil && cmd.ProcessState == nil {
+			t.Errorf("command.run() = %v", err)
+		}
+		done <- result
+	}()
+	return cmd, bufio.NewReader(r), done
+}
+
+func TestCommandRunStopLadderEscalates(t *testing.T) {
+	const wait = 300 * time.Millisecond
+	command := Command{
+		Name: "sh",
+		Args: []string{"-c", `trap "" INT; echo ready; while :; do sleep 1; done`},
+		StopSignals: []StopStep{
+			{Signal: Signal(syscall.SIGINT), Wait: jsoncfg.Duration(wait)},
+			{Signal: Signal(syscall.SIGKILL)},
+		},
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	cmd, stdout, done := startStopTest(t, ctx, &command)
+
+	if line, err := stdout.ReadString('\n'); line != "ready\n" {
+		t.Fatalf("read %q, %v, want the command to be ready", line, err)
+	}
+	stopTime := time.Now()
+	cancel()
+
+	var result StopResult
+	select {
+	case result = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("command was not stopped")
+	}
+	if elapsed := time.Since(stopTime); elapsed < wait {
+		t.Errorf("command was stopped after %v, want at least %v", elapsed, wait)
+	}
+	if want := (StopResult{Step: 2, Steps: 2, Signal: Signal(syscall.SIGKILL)}); result != want {
+		t.Errorf("stop result = %+v, want %+v", result, want)
+	}
+	if sig := processSignal(cmd.ProcessState); sig != Signal(syscall.SIGKILL) {
+		t.Errorf("command was killed by %v, want SIGKILL", sig)
+	}
+}
+
+func TestCommandRunStopKillsProcessGroup(t *testing.T) {
+	// Background jobs of a non-interactive shell ignore SIGINT.
+	command := Command{
+		Name:        "sh",
+		Args:        []string{"-c", `sleep 100 & echo $!; wait`},
+		ExitTimeout: jsoncfg.Duration(5 * time.Second),
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	_, stdout, done := startStopTest(t, ctx, &command)
+
+	line, err := stdout.ReadString('\n')
+	pid, parseErr := strconv.Atoi(strings.TrimSpace(line))
+	if parseErr != nil {
+		t.Fatalf("read %q, %v, want the PID of the background job", line, err)
+	}
+	cancel()
+
+	var result StopResult
+	select {
+	case result = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("command was not stopped")
+	}
+	if want := (StopResult{Step: 1, Steps: 2, Signal: Signal(syscall.SIGINT)}); result != want {
+		t.Errorf("stop result = %+v, want %+v", result, want)
+	}
+
+	// The background job is gone, or left as a zombie for its new parent to reap.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+		if err != nil {
+			break
+		}
+		if _, fields, ok := strings.Cut(string(stat), ") "); ok && strings.HasPrefix(fields, "Z") {
+			break
+		}
+		if time.Now().After(deadline) {
+			_ = syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("background job %d survived the stop: %s", pid, stat)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
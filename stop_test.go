@@ -0,0 +1,78 @@
+package rcebot_test
+
+import (
+	"syscall"
+	"testing"
+
+	rcebot "github.com/database64128/cubic-rce-bot"
+)
+
+func TestSignal(t *testing.T) {
+	for _, c := range [...]struct {
+		name         string
+		input        string
+		expectErr    bool
+		expected     syscall.Signal
+		expectedText string
+	}{
+		{
+			name:         "SIGTERM",
+			input:        "SIGTERM",
+			expected:     syscall.SIGTERM,
+			expectedText: "SIGTERM",
+		},
+		{
+			name:         "NoPrefix",
+			input:        "kill",
+			expected:     syscall.SIGKILL,
+			expectedText: "SIGKILL",
+		},
+		{
+			name:         "Number",
+			input:        "2",
+			expected:     syscall.SIGINT,
+			expectedText: "SIGINT",
+		},
+		{
+			name:         "UnknownNumber",
+			input:        "42",
+			expected:     42,
+			expectedText: "42",
+		},
+		{
+			name:      "Unknown",
+			input:     "SIGFOO",
+			expectErr: true,
+		},
+		{
+			name:      "Zero",
+			input:     "0",
+			expectErr: true,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var sig rcebot.Signal
+			if err := sig.UnmarshalText([]byte(c.input)); err != nil {
+				if !c.expectErr {
+					t.Fatalf("UnmarshalText(%q) = %v", c.input, err)
+				}
+				return
+			}
+			if c.expectErr {
+				t.Fatalf("UnmarshalText(%q) = nil, want error", c.input)
+			}
+
+			if sig.Value() != c.expected {
+				t.Errorf("UnmarshalText(%q) = %d, want %d", c.input, sig, c.expected)
+			}
+
+			text, err := sig.MarshalText()
+			if err != nil {
+				t.Fatalf("MarshalText() = %v", err)
+			}
+			if string(text) != c.expectedText {
+				t.Errorf("MarshalText() = %q, want %q", text, c.expectedText)
+			}
+		})
+	}
+}
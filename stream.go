@@ -0,0 +1,167 @@
+package rcebot
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	// DefaultStreamInterval is the default interval between edits of a streamed command output message.
+	DefaultStreamInterval = 3 * time.Second
+
+	// MinStreamInterval is the minimum allowed interval between edits of a streamed command output message.
+	//
+	// Telegram does not allow bots to edit messages in the same chat more often than about once per second.
+	MinStreamInterval = time.Second
+
+	// streamStatusMaxLength is the maximum length of the status line below the header of a streamed command output message.
+	streamStatusMaxLength = len("\n⏳ Running for 2562047h47m16s\n")
+
+	// streamMinTailLength is the length of output the header of a streamed command output message always leaves room for.
+	streamMinTailLength = 1024
+)
+
+// outputStreamer periodically edits a message to show the progress of a running command.
+type outputStreamer struct {
+	b           *bot.Bot
+	chatID      int64
+	messageID   int
+	header      string
+	output      *OutputBuffer
+	replyMarkup models.ReplyMarkup
+	startTime   time.Time
+	lastText    string
+	tail        []byte
+}
+
+// newOutputStreamer sends the initial progress message in reply to message and returns a streamer for it.
+//
+// header is a MarkdownV2 line identifying the command.
+// replyMarkup is attached to every progress message, and removed by the final edit.
+func newOutputStreamer(
+	ctx context.Context,
+	b *bot.Bot,
+	message *models.Message,
+	header string,
+	output *OutputBuffer,
+	replyMarkup models.ReplyMarkup,
+) (*outputStreamer, error) {
+	s := outputStreamer{
+		b:           b,
+		chatID:      message.Chat.ID,
+		header:      header,
+		output:      output,
+		replyMarkup: replyMarkup,
+		startTime:   time.Now(),
+	}
+	s.lastText = s.buildText()
+
+	sent, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:          message.Chat.ID,
+		MessageThreadID: message.MessageThreadID,
+		Text:            s.lastText,
+		ParseMode:       models.ParseModeMarkdown,
+		ReplyParameters: &models.ReplyParameters{
+			MessageID: message.ID,
+		},
+		ReplyMarkup: replyMarkup,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.messageID = sent.ID
+	return &s, nil
+}
+
+// streamHeader returns the header of a streamed command output message, made of prefix,
+// the inline code entity commandLine, and suffix. The command line is truncated
+// if needed to leave room for the status line and [streamMinTailLength] of output.
+func streamHeader(prefix, commandLine, suffix string) string {
+	budget := MaxMessageLength - streamStatusMaxLength - codeBlockOverhead - streamMinTailLength -
+		MessageLength(prefix) - MessageLength(suffix)
+	if MessageLength(commandLine) > budget {
+		code := strings.TrimSuffix(strings.TrimPrefix(commandLine, "`"), "`")
+		commandLine = "`" + truncateMarkdownV2Plaintext(code, budget-2) + "`"
+	}
+	return prefix + commandLine + suffix
+}
+
+// buildText builds the progress message text from the current output.
+func (s *outputStreamer) buildText() string {
+	var sb strings.Builder
+	sb.WriteString(s.header)
+	sb.WriteString("\n⏳ Running for ")
+	sb.WriteString(EscapeMarkdownV2Plaintext(time.Since(s.startTime).Truncate(time.Second).String()))
+	sb.WriteByte('\n')
+
+	// No more output bytes than UTF-16 code units can fit, so fetch at most budget bytes,
+	// then keep the longest suffix that still fits after escaping.
+	budget := max(MaxMessageLength-MessageLength(sb.String())-codeBlockOverhead, 0)
+	s.tail = s.output.AppendTail(s.tail[:0], budget)
+	s.tail = s.tail[len(s.tail)-codeBlockSuffixLen(s.tail, budget):]
+	if len(s.tail) > 0 {
+		sb.WriteString("```\n")
+		sb.WriteString(EscapeMarkdownV2CodeBlock(string(s.tail)))
+		if s.tail[len(s.tail)-1] != '\n' {
+			sb.WriteByte('\n')
+		}
+		sb.WriteString("```")
+	}
+	return sb.String()
+}
+
+// Run edits the progress message every interval until done is closed.
+func (s *outputStreamer) Run(ctx context.Context, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		text := s.buildText()
+		if text == s.lastText {
+			continue
+		}
+
+		_, err := s.b.EditMessageText(ctx, &bot.EditMessageTextParams{
+			ChatID:      s.chatID,
+			MessageID:   s.messageID,
+			Text:        text,
+			ParseMode:   models.ParseModeMarkdown,
+			ReplyMarkup: s.replyMarkup,
+		})
+		if err != nil {
+			// Back off as instructed when rate limited. Other errors are ignored,
+			// as the next tick will retry with fresh output anyway.
+			if tmrErr, ok := errors.AsType[*bot.TooManyRequestsError](err); ok {
+				select {
+				case <-done:
+					return
+				case <-time.After(time.Duration(tmrErr.RetryAfter) * time.Second):
+				}
+			}
+			continue
+		}
+		s.lastText = text
+	}
+}
+
+// Finish replaces the progress message with the final response text.
+func (s *outputStreamer) Finish(ctx context.Context, text string) error {
+	_, err := s.b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:    s.chatID,
+		MessageID: s.messageID,
+		Text:      text,
+		ParseMode: models.ParseModeMarkdown,
+	})
+	return err
+}
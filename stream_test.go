@@ -0,0 +1,119 @@
+package rcebot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestOutputStreamer(t *testing.T) {
+	var editCount atomic.Int32
+	b, api := newFakeBotAPI(t, func(req fakeBotAPIRequest) fakeBotAPIResponse {
+		switch req.Method {
+		case "sendMessage":
+			return fakeBotAPIResponse{Result: models.Message{ID: 100}}
+		case "editMessageText":
+			if editCount.Add(1) == 1 {
+				return fakeBotAPIResponse{
+					ErrorCode:   http.StatusTooManyRequests,
+					Description: "Too Many Requests: retry after 1",
+					RetryAfter:  1,
+				}
+			}
+			return fakeBotAPIResponse{Result: models.Message{ID: 100}}
+		default:
+			return fakeBotAPIResponse{ErrorCode: http.StatusNotFound, Description: "Not Found"}
+		}
+	})
+
+	ctx := t.Context()
+	output := NewOutputBuffer(1024, 1024)
+	message := &models.Message{ID: 1, Chat: models.Chat{ID: 2}}
+	s, err := newOutputStreamer(ctx, b, message, "Job 42", output, newCancelButtonMarkup(42))
+	if err != nil {
+		t.Fatalf("newOutputStreamer() = %v", err)
+	}
+
+	sends := api.Requests("sendMessage")
+	if len(sends) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sends))
+	}
+	var markup models.InlineKeyboardMarkup
+	if err = json.Unmarshal([]byte(sends[0].Form.Get("reply_markup")), &markup); err != nil {
+		t.Fatalf("failed to unmarshal reply markup %q: %v", sends[0].Form.Get("reply_markup"), err)
+	}
+	if len(markup.InlineKeyboard) != 1 || len(markup.InlineKeyboard[0]) != 1 {
+		t.Fatalf("reply markup = %+v, want a single button", markup)
+	}
+	if got, want := markup.InlineKeyboard[0][0].CallbackData, "/cancel 42"; got != want {
+		t.Errorf("callback data = %q, want %q", got, want)
+	}
+
+	_, _ = output.Write([]byte("hello\n"))
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		s.Run(ctx, 10*time.Millisecond, done)
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(api.Requests("editMessageText")) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The text is unchanged until the elapsed time reaches the next second, so no more edits are made.
+	time.Sleep(200 * time.Millisecond)
+	close(done)
+	wg.Wait()
+
+	edits := api.Requests("editMessageText")
+	if len(edits) != 2 {
+		t.Fatalf("made %d edits, want 2", len(edits))
+	}
+	if gap := edits[1].Time.Sub(edits[0].Time); gap < time.Second {
+		t.Errorf("retried %s after being rate limited, want at least 1s", gap)
+	}
+	if text := edits[1].Form.Get("text"); !strings.Contains(text, "hello") {
+		t.Errorf("edited text = %q, want it to contain the output", text)
+	}
+	if got := edits[1].Form.Get("reply_markup"); got != sends[0].Form.Get("reply_markup") {
+		t.Errorf("edited reply markup = %q, want %q", got, sends[0].Form.Get("reply_markup"))
+	}
+
+	if err = s.Finish(context.Background(), "done"); err != nil {
+		t.Fatalf("s.Finish() = %v", err)
+	}
+	edits = api.Requests("editMessageText")
+	if got := edits[len(edits)-1].Form.Get("reply_markup"); got != "" {
+		t.Errorf("final edit reply markup = %q, want none", got)
+	}
+}
+
+func TestOutputStreamerBuildTextFits(t *testing.T) {
+	var sb strings.Builder
+	writeCommandLine(&sb, "echo", []string{strings.Repeat("`😀 ", MaxMessageLength)})
+	header := streamHeader("Job 42: ", sb.String(), "")
+	if !strings.HasPrefix(header, "Job 42: `echo") || !strings.HasSuffix(header, "…`") {
+		t.Errorf("header = %q, want truncated command line in inline code", header)
+	}
+
+	output := NewOutputBuffer(4*MaxMessageLength, 4*MaxMessageLength)
+	_, _ = output.Write([]byte(strings.Repeat("`\\😀\n", MaxMessageLength)))
+
+	s := outputStreamer{header: header, output: output, startTime: time.Now()}
+	text := s.buildText()
+	if n := MessageLength(text); n > MaxMessageLength {
+		t.Errorf("MessageLength(text) = %d, want <= %d", n, MaxMessageLength)
+	}
+	if !strings.HasSuffix(text, "😀\n```") {
+		t.Errorf("text does not end with the output tail: %q", text[max(len(text)-64, 0):])
+	}
+}
@@ -0,0 +1,260 @@
+package rcebot
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/database64128/cubic-rce-bot/tslog"
+	"github.com/go-telegram/bot/models"
+)
+
+// ResponseTemplateData is the data passed to response templates.
+type ResponseTemplateData struct {
+	// JobID is the ID of the job.
+	JobID uint64
+
+	// Command is the command name.
+	Command string
+
+	// Args is the list of command arguments, with parameters substituted.
+	Args []string
+
+	// UserID is the Telegram user ID of the requesting user.
+	// For scheduled commands, it is the schedule's [Schedule.UserID], which may be 0.
+	UserID int64
+
+	// Username is the Telegram username of the requesting user, if any.
+	Username string
+
+	// UserFirstName is the first name of the requesting user, if any.
+	UserFirstName string
+
+	// Output is the command's output as shown in the default response:
+	// both streams in [OutputModeMerged], and standard output followed by the standard error it shows otherwise.
+	Output string
+
+	// Stdout is the command's standard output, or both streams in [OutputModeMerged].
+	Stdout string
+
+	// Stderr is the command's standard error, or empty in [OutputModeMerged].
+	Stderr string
+
+	// OutputTruncated is whether the output fields have been shortened in the middle
+	// for the response to fit in a message.
+	OutputTruncated bool
+
+	// Started is whether the command was started.
+	Started bool
+
+	// Success is whether the command was started, exited with code 0, and no error occurred.
+	Success bool
+
+	// ExitCode is the exit code of the command, or -1 if it was killed by a signal.
+	ExitCode int
+
+	// Signal is the name of the signal that killed the command, or empty if it exited on its own.
+	Signal string
+
+	// Status is the label of the exit code in [Command.ExitStatuses], or a short description of how the command exited.
+	Status string
+
+	// Error is the error that prevented the command from starting or from completing normally, if any.
+	Error string
+
+	// Duration is the wall time of the command, rounded to milliseconds.
+	Duration time.Duration
+
+	// Attempts is the number of attempts made to run the command.
+	Attempts int
+
+	// Footer is the MarkdownV2 footer of the default response,
+	// which reports the error, the exit status, and the resource usage of the command.
+	Footer string
+}
+
+// responseTemplateFuncs are the functions available to response templates.
+var responseTemplateFuncs = template.FuncMap{
+	"escape":     EscapeMarkdownV2Plaintext,
+	"escapeCode": EscapeMarkdownV2CodeBlock,
+	"code": func(s string) string {
+		return "`" + EscapeMarkdownV2CodeBlock(s) + "`"
+	},
+	"codeBlock": func(s string) string {
+		var sb strings.Builder
+		sb.WriteString("```\n")
+		sb.WriteString(EscapeMarkdownV2CodeBlock(s))
+		if !strings.HasSuffix(s, "\n") {
+			sb.WriteByte('\n')
+		}
+		sb.WriteString("```")
+		return sb.String()
+	},
+}
+
+// ParseResponseTemplate parses text as a response template named name.
+//
+// Response templates use the syntax of [text/template], are executed with [ResponseTemplateData],
+// and produce MarkdownV2 text. In addition to the built-in functions, they have access to
+// "escape" and "escapeCode", which escape a string for use in MarkdownV2 plaintext and code,
+// "code", which formats a string as inline code, and "codeBlock", which formats a string as a code block.
+func ParseResponseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(responseTemplateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// initResponseTemplate parses the response template of the command, or the global one if it has none,
+// and validates the exit status labels.
+func (c *Command) initResponseTemplate() error {
+	c.responseTemplate = nil
+
+	if c.ResponseTemplate != "" {
+		switch {
+		case c.isPipeline():
+			return errors.New("pipelines cannot have response templates")
+		case c.Diff:
+			return errors.New("commands that show diffs cannot have response templates")
+		case c.Overflow == OverflowPolicySplit:
+			return errors.New("commands with the split overflow policy cannot have response templates")
+		}
+	}
+
+	text := c.ResponseTemplate
+	if text == "" && !c.isPipeline() && !c.Diff && c.Overflow != OverflowPolicySplit {
+		text = c.globalResponseTemplate
+	}
+	if text != "" {
+		tmpl, err := ParseResponseTemplate(c.Name, text)
+		if err != nil {
+			return fmt.Errorf("invalid response template: %w", err)
+		}
+		c.responseTemplate = tmpl
+	}
+
+	if len(c.ExitStatuses) > 0 && c.responseTemplate == nil {
+		return errors.New("exit statuses require a response template")
+	}
+	return nil
+}
+
+// templateData returns the data to execute the command's response template with for the job's result.
+// user is the requesting user, or nil for scheduled commands.
+func (j *job) templateData(result *CommandResult, user *models.User) ResponseTemplateData {
+	data := ResponseTemplateData{
+		JobID:    j.id,
+		Command:  j.command.Name,
+		Args:     j.args,
+		UserID:   j.userID,
+		Stdout:   string(j.output.Bytes()),
+		Started:  result.Started,
+		Success:  result.Success(),
+		ExitCode: result.ExitCode,
+		Status:   result.Status(),
+		Duration: result.Duration.Round(time.Millisecond),
+		Attempts: len(j.attempts),
+		Footer:   j.footer,
+	}
+	if user != nil {
+		data.Username = user.Username
+		data.UserFirstName = user.FirstName
+	}
+	if j.stderr != nil {
+		data.Stderr = string(j.stderr.Bytes())
+	}
+	var output strings.Builder
+	for _, section := range j.sections {
+		output.Write(section.Output)
+	}
+	data.Output = output.String()
+	if result.Signal != 0 {
+		data.Signal = result.Signal.String()
+	}
+	if label, ok := j.command.ExitStatuses[result.ExitCode]; ok && result.Started && result.Signal == 0 {
+		data.Status = label
+	}
+	if result.Err != nil {
+		data.Error = result.Err.Error()
+	}
+	return data
+}
+
+// buildTemplateResponse builds the response to the job from the command's response template,
+// along with the output document if the response had to be truncated and the overflow policy calls for one.
+//
+// It returns false if the command has no response template, or if the template fails,
+// in which case the default response should be sent instead.
+func (j *job) buildTemplateResponse(result *CommandResult, user *models.User, logger *tslog.Logger) (messages []string, documentName string, document []byte, ok bool) {
+	tmpl := j.command.responseTemplate
+	if tmpl == nil || result == nil {
+		return nil, "", nil, false
+	}
+
+	data := j.templateData(result, user)
+	text, err := executeResponseTemplate(tmpl, &data)
+	if err != nil {
+		logger.Warn("Failed to execute response template",
+			slog.Uint64("jobID", j.id),
+			slog.String("command", j.command.Name),
+			tslog.Err(err),
+		)
+		return nil, "", nil, false
+	}
+
+	if data.OutputTruncated && j.command.Overflow == OverflowPolicyDocument {
+		documentName, document, err = outputDocument(j.sections, j.command.CompressDocument)
+		if err != nil {
+			logger.Warn("Failed to create output document",
+				slog.Uint64("jobID", j.id),
+				slog.String("command", j.command.Name),
+				tslog.Err(err),
+			)
+			return nil, "", nil, false
+		}
+	}
+	return []string{text}, documentName, document, true
+}
+
+// errResponseTemplateTooLong is returned when a response template does not fit in a message even without output.
+var errResponseTemplateTooLong = errors.New("response does not fit in a message")
+
+// executeResponseTemplate executes tmpl with data, and returns the resulting text.
+//
+// If the text does not fit in a message, the output fields of data are shortened in the middle,
+// and data.OutputTruncated is set.
+func executeResponseTemplate(tmpl *template.Template, data *ResponseTemplateData) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	if MessageLength(sb.String()) <= MaxMessageLength {
+		return sb.String(), nil
+	}
+
+	output, stdout, stderr := []byte(data.Output), []byte(data.Stdout), []byte(data.Stderr)
+	data.OutputTruncated = true
+	for budget := MaxMessageLength / 2; budget > 0; budget /= 2 {
+		data.Output = string(truncateToFit(output, budget))
+		data.Stdout = string(truncateToFit(stdout, budget))
+		data.Stderr = string(truncateToFit(stderr, budget))
+
+		sb.Reset()
+		if err := tmpl.Execute(&sb, data); err != nil {
+			return "", err
+		}
+		if MessageLength(sb.String()) <= MaxMessageLength {
+			return sb.String(), nil
+		}
+	}
+	return "", errResponseTemplateTooLong
+}
+
+// truncateToFit returns output as is if it fits in budget UTF-16 code units after escaping,
+// or its head and tail with the middle replaced with a marker.
+func truncateToFit(output []byte, budget int) []byte {
+	if codeBlockLength(output) <= budget {
+		return output
+	}
+	return truncateOutput(output, budget)
+}
@@ -0,0 +1,309 @@
+package rcebot
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/database64128/cubic-rce-bot/tslog"
+	"github.com/go-telegram/bot/models"
+)
+
+func TestUserCommandsByIDResponseTemplate(t *testing.T) {
+	for _, c := range [...]struct {
+		name           string
+		globalTemplate string
+		commands       []Command
+		wantErr        string
+	}{
+		{
+			name:     "CommandTemplate",
+			commands: []Command{{Name: "df", ResponseTemplate: "{{escape .Status}}", ExitStatuses: map[int]string{1: "degraded"}}},
+		},
+		{
+			name:           "GlobalTemplate",
+			globalTemplate: "{{codeBlock .Output}}",
+			commands:       []Command{{Name: "df", ExitStatuses: map[int]string{1: "degraded"}}},
+		},
+		{
+			name:           "GlobalTemplateSkipsDiff",
+			globalTemplate: "{{codeBlock .Output}}",
+			commands:       []Command{{Name: "df", Diff: true}},
+		},
+		{
+			name:     "InvalidTemplate",
+			commands: []Command{{Name: "df", ResponseTemplate: "{{end}}"}},
+			wantErr:  "invalid response template",
+		},
+		{
+			name:           "InvalidGlobalTemplate",
+			globalTemplate: "{{nope}}",
+			wantErr:        "invalid response template",
+		},
+		{
+			name:     "DiffTemplate",
+			commands: []Command{{Name: "df", Diff: true, ResponseTemplate: "{{.Output}}"}},
+			wantErr:  "commands that show diffs cannot have response templates",
+		},
+		{
+			name: "PipelineTemplate",
+			commands: []Command{
+				{Name: "df"},
+				{Name: "check", Steps: []PipelineStep{{Command: 0}}, ResponseTemplate: "{{.Output}}"},
+			},
+			wantErr: "pipelines cannot have response templates",
+		},
+		{
+			name:     "SplitTemplate",
+			commands: []Command{{Name: "df", ResponseTemplate: "{{.Output}}", Overflow: OverflowPolicySplit}},
+			wantErr:  "commands with the split overflow policy cannot have response templates",
+		},
+		{
+			name:           "GlobalTemplateSkipsSplit",
+			globalTemplate: "{{codeBlock .Output}}",
+			commands:       []Command{{Name: "df", Overflow: OverflowPolicySplit}},
+		},
+		{
+			name:     "ExitStatusesWithoutTemplate",
+			commands: []Command{{Name: "df", ExitStatuses: map[int]string{1: "degraded"}}},
+			wantErr:  "exit statuses require a response template",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			config := Config{
+				ResponseTemplate: c.globalTemplate,
+				Users:            []User{{ID: 1, Commands: c.commands}},
+			}
+			err := config.Validate()
+			if err == nil {
+				_, err = config.UserCommandsByID()
+			}
+			if c.wantErr == "" {
+				if err != nil {
+					t.Errorf("UserCommandsByID() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("UserCommandsByID() error = %v, want %q", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseResponseTemplate(t *testing.T) {
+	data := ResponseTemplateData{
+		Command:  "ping",
+		Args:     []string{"-c", "1", "1.1.1.1"},
+		Username: "alice_1",
+		Output:   "64 bytes from 1.1.1.1: time=1.2 ms\n",
+		ExitCode: 1,
+		Status:   "🟡 degraded",
+	}
+
+	for _, c := range [...]struct {
+		name      string
+		text      string
+		want      string
+		expectErr bool
+	}{
+		{
+			name: "Escape",
+			text: "{{escape .Status}} for @{{escape .Username}}",
+			want: "🟡 degraded for @alice\\_1",
+		},
+		{
+			name: "Code",
+			text: "{{range .Args}}{{code .}} {{end}}",
+			want: "`-c` `1` `1.1.1.1` ",
+		},
+		{
+			name: "CodeBlock",
+			text: "*{{escape .Command}}* exited with {{.ExitCode}}\n{{codeBlock .Output}}",
+			want: "*ping* exited with 1\n```\n64 bytes from 1.1.1.1: time=1.2 ms\n```",
+		},
+		{
+			name: "EscapeCode",
+			text: "`{{escapeCode \"a`b\\\\c\"}}`",
+			want: "`a\\`b\\\\c`",
+		},
+		{
+			name:      "UnknownField",
+			text:      "{{.Stdin}}",
+			expectErr: true,
+		},
+		{
+			name:      "SyntaxError",
+			text:      "{{if .Success}}",
+			expectErr: true,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			tmpl, err := ParseResponseTemplate(c.name, c.text)
+			if err == nil {
+				var sb strings.Builder
+				err = tmpl.Execute(&sb, &data)
+				if got := sb.String(); err == nil && got != c.want {
+					t.Errorf("Execute() = %q, want %q", got, c.want)
+				}
+			}
+			if (err != nil) != c.expectErr {
+				t.Errorf("error = %v, expectErr %v", err, c.expectErr)
+			}
+		})
+	}
+}
+
+func TestUserCommandsByIDResponseTemplatePrecedence(t *testing.T) {
+	config := Config{
+		ResponseTemplate: "global",
+		Users: []User{{ID: 1, Commands: []Command{
+			{Name: "own", ResponseTemplate: "own"},
+			{Name: "default"},
+			{Name: "diff", Diff: true},
+		}}},
+	}
+	userCommandsByID, err := config.UserCommandsByID()
+	if err != nil {
+		t.Fatalf("UserCommandsByID() = %v", err)
+	}
+
+	for i, want := range [...]string{"own", "global", ""} {
+		command := &userCommandsByID[1][i]
+		if command.responseTemplate == nil {
+			if want != "" {
+				t.Errorf("command %q has no response template, want %q", command.Name, want)
+			}
+			continue
+		}
+		var sb strings.Builder
+		if err := command.responseTemplate.Execute(&sb, &ResponseTemplateData{}); err != nil {
+			t.Fatalf("command %q response template: %v", command.Name, err)
+		}
+		if got := sb.String(); got != want {
+			t.Errorf("command %q response template = %q, want %q", command.Name, got, want)
+		}
+	}
+}
+
+func TestExecuteResponseTemplate(t *testing.T) {
+	tmpl, err := ParseResponseTemplate("test", "{{if .OutputTruncated}}✂️\n{{end}}{{codeBlock .Output}}{{with .Stderr}}{{codeBlock .}}{{end}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Fits", func(t *testing.T) {
+		data := ResponseTemplateData{Output: "ok\n"}
+		text, err := executeResponseTemplate(tmpl, &data)
+		if err != nil {
+			t.Fatalf("executeResponseTemplate() = %v", err)
+		}
+		if want := "```\nok\n```"; text != want {
+			t.Errorf("executeResponseTemplate() = %q, want %q", text, want)
+		}
+		if data.OutputTruncated {
+			t.Error("OutputTruncated = true, want false")
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		output := strings.Repeat("o", 2*MaxMessageLength)
+		stderr := strings.Repeat("e", MaxMessageLength)
+		data := ResponseTemplateData{Output: output, Stderr: stderr}
+		text, err := executeResponseTemplate(tmpl, &data)
+		if err != nil {
+			t.Fatalf("executeResponseTemplate() = %v", err)
+		}
+		if n := MessageLength(text); n > MaxMessageLength {
+			t.Errorf("message length = %d, want at most %d", n, MaxMessageLength)
+		}
+		if !data.OutputTruncated {
+			t.Error("OutputTruncated = false, want true")
+		}
+		if !strings.HasPrefix(text, "✂️\n```\nooo") || !strings.Contains(text, "eee") {
+			t.Errorf("executeResponseTemplate() = %q, want shortened output and stderr", text)
+		}
+		if len(data.Output) >= len(output) || len(data.Stderr) >= len(stderr) {
+			t.Errorf("output fields have lengths %d and %d, want them shortened", len(data.Output), len(data.Stderr))
+		}
+	})
+
+	t.Run("TooLong", func(t *testing.T) {
+		tmpl, err := ParseResponseTemplate("test", strings.Repeat("a", MaxMessageLength+1)+"{{.Output}}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := ResponseTemplateData{Output: "ok\n"}
+		if _, err = executeResponseTemplate(tmpl, &data); !errors.Is(err, errResponseTemplateTooLong) {
+			t.Errorf("executeResponseTemplate() = %v, want %v", err, errResponseTemplateTooLong)
+		}
+	})
+}
+
+func TestJobTemplateDataStatus(t *testing.T) {
+	command := Command{
+		Name:             "check",
+		ResponseTemplate: "{{escape .Status}}",
+		ExitStatuses:     map[int]string{0: "🟢 ok", 1: "🟡 degraded"},
+	}
+	if err := command.init(); err != nil {
+		t.Fatalf("command.init() = %v", err)
+	}
+	j := newJob(t.Context(), 1, 1, &command, 0, nil, nil)
+
+	for _, c := range [...]struct {
+		name   string
+		result CommandResult
+		want   string
+	}{
+		{"Labeled", CommandResult{Started: true, ExitCode: 1}, "🟡 degraded"},
+		{"LabeledSuccess", CommandResult{Started: true}, "🟢 ok"},
+		{"Unlabeled", CommandResult{Started: true, ExitCode: 2}, "exited with code 2"},
+		{"Killed", CommandResult{Started: true, ExitCode: -1, Signal: Signal(syscall.SIGKILL)}, "killed by " + Signal(syscall.SIGKILL).String()},
+		{"NotStarted", CommandResult{Err: errors.New("no such file")}, "failed to start"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := j.templateData(&c.result, nil).Status; got != c.want {
+				t.Errorf("Status = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestJobSendResponseTemplateFallback(t *testing.T) {
+	b, api := newFakeBotAPI(t, func(req fakeBotAPIRequest) fakeBotAPIResponse {
+		if strings.HasPrefix(req.Form.Get("text"), "*bad") {
+			return fakeBotAPIResponse{ErrorCode: http.StatusBadRequest, Description: "Bad Request: can't parse entities"}
+		}
+		return fakeBotAPIResponse{Result: models.Message{ID: 100}}
+	})
+
+	command := Command{Name: "echo", ResponseTemplate: "*bad {{escape .Status}}"}
+	if err := command.init(); err != nil {
+		t.Fatalf("command.init() = %v", err)
+	}
+	j := newJob(t.Context(), 1, 2, &command, 0, nil, nil)
+	_, _ = j.output.Write([]byte("hello\n"))
+	result := CommandResult{Started: true}
+	j.recordResult(&result)
+
+	message := &models.Message{ID: 1, Chat: models.Chat{ID: 2}}
+	logger := tslog.Config{}.NewLogger(io.Discard)
+	if err := j.sendResponse(t.Context(), b, message, nil, "", &result, nil, logger); err != nil {
+		t.Fatalf("sendResponse() = %v", err)
+	}
+
+	requests := api.Requests("sendMessage")
+	if len(requests) != 2 {
+		t.Fatalf("sent %d messages, want 2", len(requests))
+	}
+	if got := requests[0].Form.Get("text"); got != "*bad exited with code 0" {
+		t.Errorf("first message = %q, want the response from the template", got)
+	}
+	if got := requests[1].Form.Get("text"); !strings.HasPrefix(got, "```\nhello\n```") {
+		t.Errorf("second message = %q, want the default response", got)
+	}
+}
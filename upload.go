@@ -0,0 +1,281 @@
+package rcebot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	// UploadPlaceholder is the name of the argument placeholder, "${upload}",
+	// that is replaced with the path of the uploaded file in commands with [Command.UploadDir].
+	UploadPlaceholder = "upload"
+
+	// DefaultMaxUploadSize is the default maximum size of an uploaded file in bytes,
+	// which is the download limit of the official bot API.
+	DefaultMaxUploadSize = 20 * 1024 * 1024
+
+	// maxUploadNameLength is the maximum length in bytes of the sanitized name of an uploaded file.
+	maxUploadNameLength = 128
+)
+
+// initUpload validates the file upload settings of the command.
+func (c *Command) initUpload() error {
+	if c.UploadDir == "" {
+		if c.UploadMIMETypes != nil || c.MaxUploadSize != 0 || c.UploadRetention != 0 {
+			return errors.New("upload settings require an upload directory")
+		}
+		return nil
+	}
+
+	if !filepath.IsAbs(c.UploadDir) {
+		return fmt.Errorf("upload directory %q is not absolute", c.UploadDir)
+	}
+
+	for _, mimeType := range c.UploadMIMETypes {
+		typ, subtype, ok := strings.Cut(mimeType, "/")
+		if !ok || typ == "" || subtype == "" || strings.Contains(subtype, "/") {
+			return fmt.Errorf("invalid MIME type %q", mimeType)
+		}
+	}
+
+	if c.MaxUploadSize < 0 {
+		return fmt.Errorf("negative max upload size %d", c.MaxUploadSize)
+	}
+	if c.MaxUploadSize == 0 {
+		c.MaxUploadSize = DefaultMaxUploadSize
+	}
+
+	if c.UploadRetention < 0 {
+		return fmt.Errorf("negative upload retention %s", c.UploadRetention.Value())
+	}
+
+	if c.Stdin.allowsDocument() {
+		return errors.New("commands that take uploads cannot read stdin from documents")
+	}
+
+	if c.param(UploadPlaceholder) != nil {
+		return fmt.Errorf("parameter name %q is reserved for the path of the uploaded file", UploadPlaceholder)
+	}
+
+	return nil
+}
+
+// allowsMIMEType returns whether an uploaded file of the given MIME type is accepted.
+func (c *Command) allowsMIMEType(mimeType string) bool {
+	if c.UploadMIMETypes == nil {
+		return true
+	}
+	typ, _, _ := strings.Cut(mimeType, "/")
+	for _, allowed := range c.UploadMIMETypes {
+		if strings.EqualFold(allowed, mimeType) || strings.EqualFold(allowed, typ+"/*") || allowed == "*/*" {
+			return true
+		}
+	}
+	return false
+}
+
+// describeUpload returns a short human-readable description of the files the command takes.
+func (c *Command) describeUpload() string {
+	var sb strings.Builder
+	sb.WriteString("attached or replied-to document")
+	if c.UploadMIMETypes != nil {
+		sb.WriteString(" of type ")
+		sb.WriteString(strings.Join(c.UploadMIMETypes, ", "))
+	}
+	sb.WriteString(", up to ")
+	sb.WriteString(formatByteSize(c.MaxUploadSize))
+	return sb.String()
+}
+
+// uploadsInUse is the set of paths of saved documents whose executions are queued or running,
+// which are kept when expired files are removed from upload directories.
+// The zero value is an empty set.
+type uploadsInUse struct {
+	mu    sync.Mutex
+	paths map[string]int
+}
+
+// add adds path to the set. Each call must be paired with a call to [uploadsInUse.remove].
+func (u *uploadsInUse) add(path string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.paths == nil {
+		u.paths = make(map[string]int)
+	}
+	u.paths[path]++
+}
+
+// remove removes path from the set.
+func (u *uploadsInUse) remove(path string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.paths[path]--; u.paths[path] <= 0 {
+		delete(u.paths, path)
+	}
+}
+
+// contains returns whether path is in the set.
+func (u *uploadsInUse) contains(path string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	_, ok := u.paths[path]
+	return ok
+}
+
+// saveUpload downloads the document attached to message, or to the message it replies to,
+// into the command's upload directory under a sanitized unique name, and returns its path.
+// It returns an empty path if the command does not take uploads.
+//
+// The path is added to inUse, and the caller must remove it after the execution that uses the file.
+// Files in the upload directory older than [Command.UploadRetention] are removed first, unless they are in inUse.
+func (c *Command) saveUpload(ctx context.Context, b *bot.Bot, message *models.Message, inUse *uploadsInUse) (string, error) {
+	if c.UploadDir == "" {
+		return "", nil
+	}
+
+	document := message.Document
+	if document == nil && message.ReplyToMessage != nil {
+		document = message.ReplyToMessage.Document
+	}
+	if document == nil {
+		return "", errors.New("the command takes an " + c.describeUpload())
+	}
+	if !c.allowsMIMEType(document.MimeType) {
+		return "", fmt.Errorf("documents of type %q are not accepted", document.MimeType)
+	}
+	if document.FileSize > c.MaxUploadSize {
+		return "", fmt.Errorf("document is %s, limit is %s", formatByteSize(document.FileSize), formatByteSize(c.MaxUploadSize))
+	}
+
+	data, err := downloadFile(ctx, b, document.FileID, c.MaxUploadSize)
+	if err != nil {
+		return "", err
+	}
+
+	if err = c.mkdirUploadDir(); err != nil {
+		return "", err
+	}
+
+	c.removeExpiredUploads(inUse)
+
+	f, err := os.CreateTemp(c.UploadDir, "*-"+SanitizeFileName(document.FileName))
+	if err != nil {
+		return "", fmt.Errorf("failed to create upload file: %w", err)
+	}
+	path := f.Name()
+
+	_, err = f.Write(data)
+	if err == nil && c.credential != nil {
+		// Change the owner through the open file, so that the path cannot be swapped in between.
+		err = f.Chown(int(c.credential.uid), int(c.credential.gid))
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return "", fmt.Errorf("failed to save upload file: %w", err)
+	}
+
+	inUse.add(path)
+	return path, nil
+}
+
+// mkdirUploadDir creates the upload directory if it does not exist, along with its missing parents.
+// Only the upload directory itself is private to the command's user. The parents are left to the bot's user.
+func (c *Command) mkdirUploadDir() error {
+	err := os.Mkdir(c.UploadDir, 0o700)
+	if errors.Is(err, fs.ErrNotExist) {
+		if err = os.MkdirAll(filepath.Dir(c.UploadDir), 0o755); err == nil {
+			err = os.Mkdir(c.UploadDir, 0o700)
+		}
+	}
+	switch {
+	case err == nil:
+		if c.credential != nil {
+			if err = os.Chown(c.UploadDir, int(c.credential.uid), int(c.credential.gid)); err != nil {
+				return fmt.Errorf("failed to change owner of upload directory: %w", err)
+			}
+		}
+		return nil
+	case errors.Is(err, fs.ErrExist):
+		return nil
+	default:
+		return fmt.Errorf("failed to create upload directory: %w", err)
+	}
+}
+
+// removeExpiredUploads removes the regular files in the upload directory that were last modified
+// more than [Command.UploadRetention] ago, except those in inUse.
+// It does nothing if uploads are removed after each execution.
+func (c *Command) removeExpiredUploads(inUse *uploadsInUse) {
+	retention := c.UploadRetention.Value()
+	if retention <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(c.UploadDir)
+	if err != nil {
+		return
+	}
+
+	expiry := time.Now().Add(-retention)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(expiry) {
+			continue
+		}
+		path := filepath.Join(c.UploadDir, entry.Name())
+		if inUse.contains(path) {
+			continue
+		}
+		_ = os.Remove(path)
+	}
+}
+
+// SanitizeFileName returns a safe file name derived from the base name of name,
+// with characters other than ASCII letters, digits, '.', '-' and '_' replaced with '_',
+// without leading dots or dashes, and truncated to a bounded length, preserving the extension.
+// It returns "upload" if nothing is left.
+func SanitizeFileName(name string) string {
+	// Clients may send names with either kind of path separator.
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+	name = strings.TrimLeft(name, ".-")
+
+	if len(name) > maxUploadNameLength {
+		ext := filepath.Ext(name)
+		if len(ext) > maxUploadNameLength/4 {
+			ext = ""
+		}
+		name = name[:maxUploadNameLength-len(ext)] + ext
+	}
+
+	if name == "" {
+		return "upload"
+	}
+	return name
+}
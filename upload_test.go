@@ -0,0 +1,263 @@
+package rcebot
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/database64128/cubic-rce-bot/jsoncfg"
+	"github.com/go-telegram/bot/models"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	for _, c := range [...]struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"Simple", "report.pdf", "report.pdf"},
+		{"Spaces", "My Report (1).txt", "My_Report__1_.txt"},
+		{"UnixPath", "../../etc/passwd", "passwd"},
+		{"WindowsPath", `C:\Users\me\notes.md`, "notes.md"},
+		{"Hidden", ".bashrc", "bashrc"},
+		{"LeadingDash", "-rf", "rf"},
+		{"NonASCII", "résumé.pdf", "r_sum_.pdf"},
+		{"Empty", "", "upload"},
+		{"OnlyDots", "..", "upload"},
+		{"Long", strings.Repeat("a", 200) + ".tar.gz", strings.Repeat("a", 125) + ".gz"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := SanitizeFileName(c.input); got != c.want {
+				t.Errorf("SanitizeFileName(%q) = %q, want %q", c.input, got, c.want)
+			}
+		})
+	}
+}
+
+func TestUserCommandsByIDUpload(t *testing.T) {
+	for _, c := range [...]struct {
+		name     string
+		commands []Command
+		wantErr  string
+	}{
+		{
+			name:     "Valid",
+			commands: []Command{{Name: "file", Args: []string{"${upload}"}, UploadDir: "/var/lib/uploads", UploadMIMETypes: []string{"image/*", "application/pdf"}}},
+		},
+		{
+			name:     "PlaceholderWithoutUploadDir",
+			commands: []Command{{Name: "file", Args: []string{"${upload}"}}},
+			wantErr:  `invalid argument "${upload}": unknown parameter "upload"`,
+		},
+		{
+			name:     "RelativeUploadDir",
+			commands: []Command{{Name: "file", Args: []string{"${upload}"}, UploadDir: "uploads"}},
+			wantErr:  `upload directory "uploads" is not absolute`,
+		},
+		{
+			name:     "InvalidMIMEType",
+			commands: []Command{{Name: "file", UploadDir: "/var/lib/uploads", UploadMIMETypes: []string{"image"}}},
+			wantErr:  `invalid MIME type "image"`,
+		},
+		{
+			name:     "SettingsWithoutUploadDir",
+			commands: []Command{{Name: "file", MaxUploadSize: 1024}},
+			wantErr:  "upload settings require an upload directory",
+		},
+		{
+			name:     "StdinDocument",
+			commands: []Command{{Name: "file", UploadDir: "/var/lib/uploads", Stdin: StdinModeAny}},
+			wantErr:  "commands that take uploads cannot read stdin from documents",
+		},
+		{
+			name:     "ReservedParam",
+			commands: []Command{{Name: "file", UploadDir: "/var/lib/uploads", Params: []CommandParam{{Name: "upload"}}}},
+			wantErr:  `parameter name "upload" is reserved`,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			config := Config{
+				Users: []User{{ID: 1, Commands: c.commands}},
+			}
+			_, err := config.UserCommandsByID()
+			if c.wantErr == "" {
+				if err != nil {
+					t.Errorf("UserCommandsByID() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("UserCommandsByID() error = %v, want %q", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestCommandAllowsMIMEType(t *testing.T) {
+	for _, c := range [...]struct {
+		name     string
+		allowed  []string
+		mimeType string
+		want     bool
+	}{
+		{"Unrestricted", nil, "application/octet-stream", true},
+		{"UnrestrictedEmpty", nil, "", true},
+		{"NoneAllowed", []string{}, "text/plain", false},
+		{"Exact", []string{"application/pdf"}, "application/pdf", true},
+		{"ExactCaseInsensitive", []string{"Application/PDF"}, "application/pdf", true},
+		{"ExactMismatch", []string{"application/pdf"}, "application/zip", false},
+		{"SubtypeWildcard", []string{"image/*"}, "image/png", true},
+		{"SubtypeWildcardCaseInsensitive", []string{"image/*"}, "IMAGE/PNG", true},
+		{"SubtypeWildcardMismatch", []string{"image/*"}, "video/mp4", false},
+		{"SubtypeWildcardPrefix", []string{"image/*"}, "imagex/png", false},
+		{"FullWildcard", []string{"*/*"}, "video/mp4", true},
+		{"FullWildcardEmpty", []string{"*/*"}, "", true},
+		{"EmptyMismatch", []string{"text/plain"}, "", false},
+		{"Multiple", []string{"text/plain", "image/*"}, "image/jpeg", true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			command := Command{UploadMIMETypes: c.allowed}
+			if got := command.allowsMIMEType(c.mimeType); got != c.want {
+				t.Errorf("allowsMIMEType(%q) with %q = %v, want %v", c.mimeType, c.allowed, got, c.want)
+			}
+		})
+	}
+}
+
+func TestCommandRemoveExpiredUploads(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	for name, age := range map[string]time.Duration{
+		"expired.txt": 2 * time.Hour,
+		"fresh.txt":   30 * time.Minute,
+		"in-use.txt":  2 * time.Hour,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(-age)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Directories and symbolic links are never removed, even when old or pointing to expired files.
+	old := now.Add(-24 * time.Hour)
+	subdir := filepath.Join(dir, "subdir")
+	if err := os.Mkdir(subdir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(subdir, old, old); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink("expired.txt", link); err != nil {
+		t.Fatal(err)
+	}
+
+	listDir := func() []string {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return names
+	}
+
+	// Files used by queued or running executions are kept, however old.
+	var inUse uploadsInUse
+	inUse.add(filepath.Join(dir, "in-use.txt"))
+
+	// Without a retention, uploads are removed after each execution instead.
+	command := Command{UploadDir: dir}
+	command.removeExpiredUploads(&inUse)
+	if got, want := listDir(), []string{"expired.txt", "fresh.txt", "in-use.txt", "link", "subdir"}; !slices.Equal(got, want) {
+		t.Errorf("without retention, directory has %q, want %q", got, want)
+	}
+
+	command.UploadRetention = jsoncfg.Duration(time.Hour)
+	command.removeExpiredUploads(&inUse)
+	if got, want := listDir(), []string{"fresh.txt", "in-use.txt", "link", "subdir"}; !slices.Equal(got, want) {
+		t.Errorf("with retention, directory has %q, want %q", got, want)
+	}
+
+	// Once the execution is done, the file is removed like any other.
+	inUse.remove(filepath.Join(dir, "in-use.txt"))
+	command.removeExpiredUploads(&inUse)
+	if got, want := listDir(), []string{"fresh.txt", "link", "subdir"}; !slices.Equal(got, want) {
+		t.Errorf("after the execution, directory has %q, want %q", got, want)
+	}
+
+	// A missing upload directory is not an error.
+	command.UploadDir = filepath.Join(dir, "missing")
+	command.removeExpiredUploads(&inUse)
+}
+
+func TestCommandSaveUpload(t *testing.T) {
+	b, api := newFakeBotAPI(t, func(req fakeBotAPIRequest) fakeBotAPIResponse {
+		if req.Method != "getFile" {
+			return fakeBotAPIResponse{ErrorCode: http.StatusNotFound, Description: "Not Found"}
+		}
+		return fakeBotAPIResponse{Result: models.File{FileID: "doc", FilePath: "documents/doc"}}
+	})
+	api.Mux.HandleFunc("GET /file/bot"+fakeBotToken+"/documents/doc", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("%PDF-1.7"))
+	})
+
+	// Missing parents of the upload directory are created too.
+	parent := filepath.Join(t.TempDir(), "var", "lib")
+	command := Command{Name: "file", Args: []string{"${upload}"}, UploadDir: filepath.Join(parent, "uploads")}
+	if err := command.init(); err != nil {
+		t.Fatalf("command.init() = %v", err)
+	}
+
+	message := &models.Message{
+		ID:       1,
+		Chat:     models.Chat{ID: 2},
+		Document: &models.Document{FileID: "doc", FileName: "../Report (final).pdf", MimeType: "application/pdf", FileSize: 8},
+	}
+	var inUse uploadsInUse
+	path, err := command.saveUpload(t.Context(), b, message, &inUse)
+	if err != nil {
+		t.Fatalf("command.saveUpload() = %v", err)
+	}
+
+	if dir := filepath.Dir(path); dir != command.UploadDir {
+		t.Errorf("saved in %q, want %q", dir, command.UploadDir)
+	}
+	if !strings.HasSuffix(path, "-Report__final_.pdf") {
+		t.Errorf("saved as %q, want a sanitized name", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "%PDF-1.7" {
+		t.Errorf("saved file = %q, want %q", data, "%PDF-1.7")
+	}
+	if !inUse.contains(path) {
+		t.Error("saved file is not marked as in use")
+	}
+
+	for dir, want := range map[string]os.FileMode{
+		command.UploadDir: 0o700,
+		parent:            0o755,
+	} {
+		info, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm() &^ 0o022; got != want&^0o022 {
+			t.Errorf("%q mode = %v, want %v", dir, info.Mode().Perm(), want)
+		}
+	}
+}
@@ -8,10 +8,9 @@ import (
 	"net"
 	"net/http"
 	"os"
-	"os/user"
-	"strconv"
 
 	"github.com/database64128/cubic-rce-bot/jsoncfg"
+	"github.com/database64128/cubic-rce-bot/osuser"
 	"github.com/database64128/cubic-rce-bot/tslog"
 )
 
@@ -99,40 +98,14 @@ func (s *Server) Start(ctx context.Context) error {
 
 func (s *Server) configureUnixDomainSocket(listenAddress *net.UnixAddr) error {
 	if s.owner.IsValid() || s.group.IsValid() {
-		uid, gid := -1, -1
-
-		switch s.owner.Kind() {
-		case jsoncfg.IntOrStringKindInt:
-			uid = s.owner.Int()
-
-		case jsoncfg.IntOrStringKindString:
-			username := s.owner.String()
-			owner, err := user.Lookup(username)
-			if err != nil {
-				return fmt.Errorf("failed to lookup user %q: %w", username, err)
-			}
-
-			uid, err = strconv.Atoi(owner.Uid)
-			if err != nil {
-				return fmt.Errorf("failed to convert user ID %q to int: %w", owner.Uid, err)
-			}
+		uid, err := osuser.LookupUID(s.owner)
+		if err != nil {
+			return err
 		}
 
-		switch s.group.Kind() {
-		case jsoncfg.IntOrStringKindInt:
-			gid = s.group.Int()
-
-		case jsoncfg.IntOrStringKindString:
-			groupName := s.group.String()
-			group, err := user.LookupGroup(groupName)
-			if err != nil {
-				return fmt.Errorf("failed to lookup group %q: %w", groupName, err)
-			}
-
-			gid, err = strconv.Atoi(group.Gid)
-			if err != nil {
-				return fmt.Errorf("failed to convert group ID %q to int: %w", group.Gid, err)
-			}
+		gid, err := osuser.LookupGID(s.group)
+		if err != nil {
+			return err
 		}
 
 		if err := os.Chown(listenAddress.Name, uid, gid); err != nil {